@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var before string
@@ -41,8 +40,8 @@ Examples:
 			return errors.New("invalid argument supplied to 'only'. Expecting 'pings' or 'alerts'")
 		}
 
-		if len(viper.GetString(varApiKey)) < 10 {
-			return errors.New("you must provide an API key with this command or save a key using 'cronitor configure'")
+		if err := requireApiKey(cmd); err != nil {
+			return err
 		}
 
 		return nil
@@ -52,7 +51,7 @@ Examples:
 		url := createActivityApiUrl(args[0])
 		response, err := getCronitorApi().GetRawResponse(url)
 		if err != nil {
-			fatal(fmt.Sprintf("Request to %s failed: %s", url, err), 1)
+			fatal(fmt.Sprintf("Request to %s failed: %s", url, err), exitUnavailable)
 		}
 
 		buf := new(bytes.Buffer)
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local monitor cache",
+	Long: `
+The monitor list fetched by commands like status and discover is cached on disk for --cache-ttl (default 1m), in a
+"cache" directory next to your config file. Cache files are readable only by their owner and are kept separately for each API key.
+
+Example clearing the cache:
+  $ cronitor cache clear
+`,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached monitor lists",
+	Run: func(cmd *cobra.Command, args []string) {
+		removed, err := lib.ClearCache(cacheDirectory())
+		if err != nil {
+			fatal(fmt.Sprintf("The cache could not be cleared: %s", err.Error()), exitInternal)
+		}
+
+		printDoneText(fmt.Sprintf("Removed %d cached responses from %s", removed, cacheDirectory()), false)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
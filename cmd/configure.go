@@ -7,15 +7,27 @@ import (
 	"github.com/spf13/viper"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type ConfigFile struct {
-	ApiKey         string   `json:"CRONITOR_API_KEY"`
-	PingApiAuthKey string   `json:"CRONITOR_PING_API_KEY"`
-	ExcludeText    []string `json:"CRONITOR_EXCLUDE_TEXT,omitempty"`
-	Hostname       string   `json:"CRONITOR_HOSTNAME"`
-	Log            string   `json:"CRONITOR_LOG"`
-	Env            string   `json:"CRONITOR_ENV"`
+	ApiKey             string   `json:"CRONITOR_API_KEY"`
+	PingApiAuthKey     string   `json:"CRONITOR_PING_API_KEY"`
+	ExcludeText        []string `json:"CRONITOR_EXCLUDE_TEXT,omitempty"`
+	Hostname           string   `json:"CRONITOR_HOSTNAME"`
+	Log                string   `json:"CRONITOR_LOG"`
+	Env                string   `json:"CRONITOR_ENV"`
+	MessagePrefix      string   `json:"CRONITOR_MESSAGE_PREFIX,omitempty"`
+	MessageSuffix      string   `json:"CRONITOR_MESSAGE_SUFFIX,omitempty"`
+	PingApiKeyRequired bool     `json:"CRONITOR_PING_API_KEY_REQUIRED,omitempty"`
+	Headers            []string `json:"CRONITOR_HEADERS,omitempty"`
+	Http1Only          bool     `json:"CRONITOR_HTTP1_ONLY,omitempty"`
+	RequireTLS13       bool     `json:"CRONITOR_REQUIRE_TLS13,omitempty"`
+	RulesFile          string   `json:"CRONITOR_RULES_FILE,omitempty"`
+	AllowedCodes       []string `json:"CRONITOR_ALLOWED_CODES,omitempty"`
+	AllowedCodesFile   string   `json:"CRONITOR_ALLOWED_CODES_FILE,omitempty"`
+	ExtraHostTags      []string `json:"CRONITOR_EXTRA_HOST_TAGS,omitempty"`
 }
 
 // configureCmd represents the configure command
@@ -25,21 +37,56 @@ var configureCmd = &cobra.Command{
 	Long: `
 Optionally write configuration options to a JSON file.
 
-By default, configuration files are system-wide for ease of use in cron jobs and scripts. Default configuration file location varies by platform:
+When run as root, configuration files are system-wide for ease of use in cron jobs and scripts. Default configuration file location varies by platform:
   Linux        /etc/cronitor/cronitor.json
   MacOS        /etc/cronitor/cronitor.json
   Windows      %SystemDrive%\ProgramData\Cronitor\cronitor.json
 
+On Linux and MacOS, $XDG_CONFIG_HOME/cronitor (or ~/.config/cronitor), ~/.cronitor and /etc/cronitor are searched in order
+for a cronitor.json, cronitor.toml, cronitor.yaml or cronitor.yml file. For root, /etc/cronitor is searched first.
+
 CronitorCLI configuration can be supplied from a file, environment variables, or command line flags.
 You can use a default config file for some things and environment variables or command line arguments for others -- the goal is flexibility.
 
 Environment variables that are read:
+  CRONITOR_ALLOWED_CODES
+  CRONITOR_ALLOWED_CODES_FILE
   CRONITOR_API_KEY
+  CRONITOR_API_URL
+  CRONITOR_COMPLETE_PING_RETRIES
+  CRONITOR_COMPLETE_PING_TIMEOUT
   CRONITOR_CONFIG
+  CRONITOR_CONFIG_FORMAT
+  CRONITOR_DURATION_PRECISION
   CRONITOR_EXCLUDE_TEXT
+  CRONITOR_EXTRA_HOST_TAGS
+  CRONITOR_FAIL_PING_RETRIES
+  CRONITOR_FAIL_PING_TIMEOUT
+  CRONITOR_HEADERS
   CRONITOR_HOSTNAME
+  CRONITOR_HTTP1_ONLY
   CRONITOR_LOG
+  CRONITOR_MAX_MESSAGE_BYTES
+  CRONITOR_MESSAGE_PREFIX
+  CRONITOR_MESSAGE_SUFFIX
   CRONITOR_PING_API_KEY
+  CRONITOR_PING_API_KEY_REQUIRED
+  CRONITOR_PING_ENDPOINT_MAP
+  CRONITOR_PING_HMAC_SECRET
+  CRONITOR_PING_HOST
+  CRONITOR_PING_RETRIES
+  CRONITOR_PING_TIMEOUT
+  CRONITOR_REQUIRE_TLS13
+  CRONITOR_RULES_FILE
+  CRONITOR_RUN_PING_RETRIES
+  CRONITOR_RUN_PING_TIMEOUT
+  CRONITOR_SERIES
+  CRONITOR_SOCKS5
+  CRONITOR_USER_AGENT_SUFFIX
+
+Each of these except CRONITOR_CONFIG, CRONITOR_CONFIG_FORMAT and CRONITOR_SERIES can also be set in the config file,
+and a flag takes precedence over both. Config file formats, $VAR references, systemd credentials and network options are
+described in https://github.com/cronitorio/cronitor-cli/blob/master/docs/configuration.md
 
 Example setting your API Key:
   $ cronitor configure --api-key 4319e94e890a013dbaca57c2df2ff60c2
@@ -48,6 +95,12 @@ Example setting common exclude text for use with 'cronitor discover':
   $ cronitor configure -e "/var/app/code/path/" -e "/var/app/bin/" -e "> /dev/null"`,
 	Run: func(cmd *cobra.Command, args []string) {
 
+		if format := viper.GetString(varConfigFormat); len(format) > 0 && format != "json" {
+			fatal("configure writes JSON config files and cannot be used with --config-format "+format, exitUsage)
+		} else if format := configFileFormat(viper.ConfigFileUsed()); len(format) > 0 && format != "json" {
+			fatal(fmt.Sprintf("configure writes JSON config files and cannot update %s. Edit the file directly, or move it aside to create a cronitor.json", viper.ConfigFileUsed()), exitUsage)
+		}
+
 		configData := ConfigFile{}
 		configData.ApiKey = viper.GetString(varApiKey)
 		configData.PingApiAuthKey = viper.GetString(varPingApiKey)
@@ -55,6 +108,16 @@ Example setting common exclude text for use with 'cronitor discover':
 		configData.Hostname = viper.GetString(varHostname)
 		configData.Log = viper.GetString(varLog)
 		configData.Env = viper.GetString(varEnv)
+		configData.MessagePrefix = viper.GetString(varMessagePrefix)
+		configData.MessageSuffix = viper.GetString(varMessageSuffix)
+		configData.PingApiKeyRequired = viper.GetBool(varPingApiKeyRequired)
+		configData.Headers = effectiveHeaderLines()
+		configData.Http1Only = viper.GetBool(varHttp1Only)
+		configData.RequireTLS13 = viper.GetBool(varRequireTLS13)
+		configData.RulesFile = viper.GetString(varRulesFile)
+		configData.AllowedCodes = viper.GetStringSlice(varAllowedCodes)
+		configData.AllowedCodesFile = viper.GetString(varAllowedCodesFile)
+		configData.ExtraHostTags = effectiveExtraHostTags()
 
 		fmt.Println("\nConfiguration File:")
 		fmt.Println(configFilePath())
@@ -103,18 +166,35 @@ Example setting common exclude text for use with 'cronitor discover':
 			}
 		}
 
+		// Values expanded from environment variables are written back as references
+		keepConfigEnvTemplate(&configData.ApiKey, varApiKey)
+		keepConfigEnvTemplate(&configData.PingApiAuthKey, varPingApiKey)
+		keepConfigEnvTemplate(&configData.Hostname, varHostname)
+		keepConfigEnvTemplate(&configData.Log, varLog)
+		keepConfigEnvTemplate(&configData.Env, varEnv)
+		keepConfigEnvTemplate(&configData.MessagePrefix, varMessagePrefix)
+		keepConfigEnvTemplate(&configData.MessageSuffix, varMessageSuffix)
+
+		// Keys read from systemd credentials are never written to the config file
+		if configValue, ok := systemdCredentialOverrides[varApiKey]; ok {
+			configData.ApiKey = configValue
+		}
+		if configValue, ok := systemdCredentialOverrides[varPingApiKey]; ok {
+			configData.PingApiAuthKey = configValue
+		}
+
 		b, err := json.MarshalIndent(configData, "", "    ")
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(exitInternal)
 		}
 
-		os.MkdirAll(defaultConfigFileDirectory(), os.ModePerm)
+		os.MkdirAll(filepath.Dir(configFilePath()), os.ModePerm)
 		if ioutil.WriteFile(configFilePath(), b, 0644) != nil {
 			fmt.Fprintf(os.Stderr,
 				"\nERROR: The configuration file %s could not be written; check permissions and try again. "+
 					   "\n       By default, configuration files are system-wide for ease of use in cron jobs and scripts. Specify an alternate config file using the --config argument or CRONITOR_CONFIG environment variable.\n\n", configFilePath())
-			os.Exit(126)
+			os.Exit(exitCantCreate)
 		}
 	},
 }
@@ -125,7 +205,31 @@ func configFilePath() string {
 		return viperConfig
 	}
 
-	return fmt.Sprintf("%s/cronitor.json", defaultConfigFileDirectory())
+	return filepath.Join(writableConfigFileDirectory(), "cronitor.json")
+}
+
+func writableConfigFileDirectory() string {
+	for _, directory := range configFileDirectories() {
+		if os.MkdirAll(directory, os.ModePerm) != nil {
+			continue
+		}
+
+		if testFile, err := ioutil.TempFile(directory, ".cronitor-write-test-*"); err == nil {
+			testFile.Close()
+			os.Remove(testFile.Name())
+			return directory
+		}
+	}
+
+	return defaultConfigFileDirectory()
+}
+
+// keepConfigEnvTemplate restores the config file reference to environment variables for key, unless the value was
+// changed by a flag or environment variable
+func keepConfigEnvTemplate(value *string, key string) {
+	if template, ok := configEnvTemplates[strings.ToLower(key)]; ok && *value == template.Expanded {
+		*value = template.Raw
+	}
 }
 
 func init() {
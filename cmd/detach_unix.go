@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import "syscall"
+
+// detachedProcAttr starts the detached exec in a new session, so it has no controlling terminal and is not sent the
+// SIGHUP or SIGINT of the session that started it
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import "syscall"
+
+const detachedProcess = 0x00000008
+const createNewProcessGroup = 0x00000200
+
+// detachedProcAttr starts the detached exec without a console in a process group of its own, so it is not sent the
+// Ctrl+C of the console that started it
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | createNewProcessGroup}
+}
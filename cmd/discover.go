@@ -1,16 +1,19 @@
 package cmd
 
 import (
+	"crypto/sha1"
+	"encoding/json"
 	"github.com/cronitorio/cronitor-cli/lib"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
+	"regexp"
 	"strings"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 type ExistingMonitors struct {
@@ -62,6 +65,15 @@ func (em ExistingMonitors) GetNameForCurrent() (string, error) {
 	return "", errors.New("does not exist")
 }
 
+func (em ExistingMonitors) HasMonitorByKey(key string) bool {
+	for _, value := range em.Monitors {
+		if value.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (em ExistingMonitors) AddName(name string) {
 	em.Names = append(em.Names, name)
 }
@@ -75,7 +87,24 @@ var dryRun bool
 var timezone lib.TimezoneLocationName
 var maxNameLen = 75
 var notificationList string
+var pingApiKeyMapFile string
+var pingApiKeyMap []PingApiKeyMapping
+var importMonitors bool
+var manifestOutFile string
+var discoveredMonitors []*lib.Monitor
+var assertions []string
 var existingMonitors = ExistingMonitors{}
+var discoverNomad bool
+var nomadAddr string
+var nomadToken string
+var normalizeCommand bool
+var normalizeSortFlags bool
+var deterministicCodes bool
+var reportHostMetadata bool
+var hostMetadata *lib.HostMetadata
+
+// assignedCodes maps each deterministic code given out in this run to the key of its monitor
+var assignedCodes = map[string]string{}
 
 // To deprecate this feature we are hijacking this flag that will trigger removal of auto-discover lines from existing user's crontabs.
 var noAutoDiscover = true
@@ -84,25 +113,38 @@ var discoverCmd = &cobra.Command{
 	Use:   "discover <optional path>",
 	Short: "Attach monitoring to new cron jobs and watch for schedule updates",
 	Long: `
-Cronitor discover will parse your crontab and create or update monitors using the Cronitor API.
+Cronitor discover will parse your crontab and print the cron jobs it finds. With --import, it creates or updates monitors
+using the Cronitor API and adds Cronitor integration to your crontab.
+
+Discover runs in one of three modes:
+  (default)        Print the cron jobs that were found. Nothing is sent to Cronitor and no crontab is modified.
+  --import         Create or update monitors and rewrite the crontab with Cronitor integration.
+  --manifest-out   Write the monitors that would be created to a JSON file. Nothing is sent to Cronitor and no crontab is modified.
 
-Note: You must supply your Cronitor API key. This can be passed as a flag, environment variable, or saved in your Cronitor configuration file. See 'help configure' for more details.
+Note: With --import you must supply your Cronitor API key. This can be passed as a flag, environment variable, or saved in your Cronitor configuration file. See 'help configure' for more details.
 
 Example:
   $ cronitor discover
+      > Read user crontab, the system crontab and the drop-in directory and print each cron job found
+
+  $ cronitor discover --import
       > Read user crontab and step through line by line
       > Creates monitors on your Cronitor dashboard for each entry in the crontab. The command string will be used as the monitor name.
       > Adds Cronitor integration to your crontab
 
-  $ cronitor discover /path/to/crontab
+  $ cronitor discover /path/to/crontab --import
       > Instead of the user crontab, provide a crontab file (or directory of crontabs) to use
 
 Example that does not use an interactive shell:
-  $ cronitor discover --auto
+  $ cronitor discover --import --auto
       > The only output to stdout will be your updated crontab file, suitable for piplines or writing to another crontab.
 
+Example writing a manifest for review:
+  $ cronitor discover /path/to/crontab --manifest-out monitors.json
+      > Writes the monitors that --import would create or update, without contacting Cronitor
+
 Example excluding secrets or common text from monitor names:
-  $ cronitor discover /path/to/crontab -e "secret-token" -e "/var/common/app/path/"
+  $ cronitor discover /path/to/crontab --import -e "secret-token" -e "/var/common/app/path/"
       > Updates previously discovered monitors or creates new monitors, excluding the provided snippets from the monitor name.
       > Adds Cronitor integration to your crontab and outputs to stdout
       > Names you create yourself in "discover" or from the dashboard are unchanged.
@@ -110,9 +152,12 @@ Example excluding secrets or common text from monitor names:
   You can run the command as many times as you need, accumulating exclusion params until the job names on your Cronitor dashboard are clear and readable.
 
 Example where you perform a dry-run without any crontab modifications:
-  $ cronitor discover /path/to/crontab --dry-run
+  $ cronitor discover /path/to/crontab --import --dry-run
       > Steps line by line, creates or updates monitors
       > Checks permissions to ensure integration can be applied later
+
+Ping API key maps, assertions, stable monitor codes, Nomad jobs and the other options are described in
+https://github.com/cronitorio/cronitor-cli/blob/master/docs/discover.md
 	`,
 	Args: func(cmd *cobra.Command, args []string) error {
 
@@ -122,8 +167,44 @@ Example where you perform a dry-run without any crontab modifications:
 			isSilent = true
 		}
 
-		if len(viper.GetString(varApiKey)) < 10 {
-			return errors.New("you must provide a valid API key with this command or save a key using 'cronitor configure'")
+		if importMonitors && len(manifestOutFile) > 0 {
+			return errors.New("--import and --manifest-out cannot be used together. Write a manifest to review first, then run again with --import")
+		}
+
+		if discoverNomad && len(args) > 0 {
+			return errors.New("a crontab path cannot be used with --nomad")
+		}
+
+		if normalizeSortFlags && !normalizeCommand {
+			return errors.New("--normalize-sort-flags can only be used with --normalize-command")
+		}
+
+		if dryRun && !importMonitors {
+			return errors.New("--dry-run can only be used with --import")
+		}
+
+		if importMonitors {
+			if err := requireApiKey(cmd); err != nil {
+				return err
+			}
+		}
+
+		for _, assertion := range assertions {
+			if err := validateAssertion(assertion); err != nil {
+				return err
+			}
+		}
+
+		if reportHostMetadata {
+			metadata := lib.ReadHostMetadata()
+			hostMetadata = &metadata
+		}
+
+		if len(pingApiKeyMapFile) > 0 {
+			var err error
+			if pingApiKeyMap, err = readPingApiKeyMap(pingApiKeyMapFile); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -138,9 +219,15 @@ Example where you perform a dry-run without any crontab modifications:
 		printSuccessText("Scanning for cron jobs... (Use Ctrl-C to skip)", false)
 
 		// Fetch list of existing monitor names for easy unique name validation and prompt prefill later on
-		existingMonitors.Monitors, _ = getCronitorApi().GetMonitors()
+		if importMonitors {
+			existingMonitors.Monitors, _ = getCronitorApi().GetMonitors()
+		}
 
-		if len(args) > 0 {
+		if discoverNomad {
+			if processNomad() {
+				importedCrontabs++
+			}
+		} else if len(args) > 0 {
 			// A supplied argument can be a specific file or a directory
 			if isPathToDirectory(args[0]) {
 				processDirectory(username, args[0])
@@ -164,7 +251,19 @@ Example where you perform a dry-run without any crontab modifications:
 			processDirectory(username, lib.DROP_IN_DIRECTORY)
 		}
 
+		if len(manifestOutFile) > 0 {
+			if err := writeManifest(manifestOutFile, discoveredMonitors); err != nil {
+				fatal(err.Error(), exitCantCreate)
+			}
+			printSuccessText(fmt.Sprintf("Wrote %d monitors to %s", len(discoveredMonitors), manifestOutFile), false)
+		}
+
 		printDoneText("Discover complete", false)
+		if !importMonitors && len(manifestOutFile) == 0 && importedCrontabs > 0 && !isAutoDiscover {
+			printWarningText("Nothing was changed. To create monitors and add Cronitor integration, run:", true)
+			fmt.Println(fmt.Sprintf("      %s --import\n", strings.Join(os.Args, " ")))
+		}
+
 		if dryRun {
 			saveCommand := strings.Join(os.Args, " ")
 			saveCommand = strings.Replace(saveCommand, " --dry-run", "", -1)
@@ -218,7 +317,7 @@ func processCrontab(crontab *lib.Crontab) bool {
 	}
 
 	// Before going further, ensure we aren't going to run into permissions problems writing the crontab later
-	if !crontab.IsWritable() {
+	if importMonitors && !crontab.IsWritable() {
 		printWarningText(fmt.Sprintf("This crontab is not writeable. Re-run command with sudo. Skipping"), true)
 		return false
 	}
@@ -258,18 +357,26 @@ func processCrontab(crontab *lib.Crontab) bool {
 		rules := []lib.Rule{createRule(line.CronExpression)}
 		defaultName := createDefaultName(line, crontab, effectiveHostname(), excludeFromName, allNameCandidates)
 		tags := createTags()
-		key := line.Key(crontab.CanonicalName())
+		key := monitorKey(line, crontab)
 		name := defaultName
+		code := line.Code
+		if deterministicCodes && len(code) == 0 && !line.IsAutoDiscoverCommand() && (importMonitors || len(manifestOutFile) > 0) {
+			code = deterministicCode(line, key)
+		}
 		skip := false
 
 		// If we know this monitor exists already, return the name
 		existingMonitors.CurrentKey = key
-		existingMonitors.CurrentCode = line.Code
+		existingMonitors.CurrentCode = code
 		if existingName, err := existingMonitors.GetNameForCurrent(); err == nil {
 			name = existingName
 		}
 
-		if !isAutoDiscover && !line.IsAutoDiscoverCommand() {
+		if !importMonitors && len(manifestOutFile) == 0 {
+			if !line.IsAutoDiscoverCommand() && !isSilent {
+				printDiscoveredLine(line)
+			}
+		} else if importMonitors && !isAutoDiscover && !line.IsAutoDiscoverCommand() {
 			fmt.Println(fmt.Sprintf("\n    %s  %s", line.CronExpression, line.CommandToRun))
 			for {
 				prompt := promptui.Prompt{
@@ -309,6 +416,12 @@ func processCrontab(crontab *lib.Crontab) bool {
 			notificationListMap = map[string][]string{"templates": {notificationList}}
 		}
 
+		// A key written into the integration by an earlier run is kept when no pattern in the map matches
+		pingApiKey := pingApiKeyForCommand(line.CommandToRun)
+		if pingApiKey == "" {
+			pingApiKey = line.PingApiKey
+		}
+
 		line.Mon = lib.Monitor{
 			Name:             name,
 			DefaultName:      defaultName,
@@ -316,18 +429,26 @@ func processCrontab(crontab *lib.Crontab) bool {
 			Rules:            rules,
 			Tags:             tags,
 			Type:             "heartbeat",
-			Code:             line.Code,
+			Code:             code,
 			Timezone:         timezone.Name,
 			Note:             createNote(line, crontab),
 			Notifications:    notificationListMap,
+			Assertions:       assertions,
 			NoStdoutPassthru: noStdoutPassthru,
+			PingApiKey:       pingApiKey,
 		}
 
 		monitors[key] = &line.Mon
+		discoveredMonitors = append(discoveredMonitors, &line.Mon)
 	}
 
 	printLn()
 
+	// Without --import, discovery is read-only
+	if !importMonitors {
+		return len(monitors) > 0
+	}
+
 	if len(monitors) > 0 {
 		printDoneText("Sending to Cronitor", true)
 	}
@@ -335,7 +456,7 @@ func processCrontab(crontab *lib.Crontab) bool {
 	var err error
 	monitors, err = getCronitorApi().PutMonitors(monitors)
 	if err != nil {
-		fatal(err.Error(), 1)
+		fatal(err.Error(), exitUnavailable)
 	}
 
 	// Re-write crontab lines with new/updated monitoring
@@ -362,12 +483,222 @@ func processCrontab(crontab *lib.Crontab) bool {
 	return len(monitors) > 0
 }
 
+func processNomad() bool {
+	defer printLn()
+	client := lib.NomadClient{Addr: effectiveNomadAddr(), Token: effectiveNomadToken(), UserAgent: userAgent}
+	printSuccessText(fmt.Sprintf("Checking Nomad periodic jobs at %s", client.Addr), false)
+
+	stubs, err := client.ListJobs()
+	if err != nil {
+		fatal(err.Error(), exitUnavailable)
+	}
+
+	monitors := map[string]*lib.Monitor{}
+	for _, stub := range stubs {
+		if !stub.Periodic {
+			log(fmt.Sprintf("Skipping Nomad job %s: not a periodic job", stub.ID))
+			continue
+		}
+
+		job, err := client.GetJob(stub.ID, stub.Namespace)
+		if err != nil {
+			printWarningText(fmt.Sprintf("Skipping Nomad job %s: %s", stub.ID, err.Error()), true)
+			continue
+		}
+
+		spec, ok := job.CronSpec()
+		if !ok {
+			log(fmt.Sprintf("Skipping Nomad job %s: the periodic configuration is disabled or is not a cron spec", stub.ID))
+			continue
+		}
+
+		monitor := createNomadMonitor(job, spec)
+		existingMonitors.CurrentKey = monitor.Key
+		existingMonitors.CurrentCode = ""
+		if existingName, err := existingMonitors.GetNameForCurrent(); err == nil && existingName != monitor.DefaultName {
+			monitor.Name = existingName
+		}
+
+		if !importMonitors && len(manifestOutFile) == 0 && !isSilent {
+			fmt.Println(fmt.Sprintf("    %s  %s  (%s, %s)", spec, monitor.DefaultName, monitor.Timezone, monitor.Key))
+		}
+
+		monitors[monitor.Key] = monitor
+		discoveredMonitors = append(discoveredMonitors, monitor)
+	}
+
+	if !isAutoDiscover {
+		label := "jobs"
+		if len(monitors) == 1 {
+			label = "job"
+		}
+		printSuccessText(fmt.Sprintf("Found %d periodic Nomad %s", len(monitors), label), true)
+	}
+
+	if !importMonitors || len(monitors) == 0 {
+		return len(monitors) > 0
+	}
+
+	printDoneText("Sending to Cronitor", true)
+	if _, err := getCronitorApi().PutMonitors(monitors); err != nil {
+		fatal(err.Error(), exitUnavailable)
+	}
+
+	if !isSilent {
+		printDoneText("Monitors created. To report each run, wrap the task command of each job:", true)
+		for _, monitor := range monitors {
+			fmt.Println(fmt.Sprintf("      %s: cronitor exec %s <command>", monitor.DefaultName, monitor.Key))
+		}
+	}
+
+	return true
+}
+
+// createNomadMonitor returns the monitor for a Nomad periodic job. The key is derived from the region, namespace
+// and job ID so it does not change when the job is updated.
+func createNomadMonitor(job *lib.NomadJob, spec string) *lib.Monitor {
+	key := fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("nomad-%s-%s-%s", job.Region, job.Namespace, job.ID))))
+
+	name := job.Name
+	if len(name) == 0 {
+		name = job.ID
+	}
+
+	notificationListMap := map[string][]string{}
+	if notificationList != "" {
+		notificationListMap = map[string][]string{"templates": {notificationList}}
+	}
+
+	return &lib.Monitor{
+		DefaultName:      truncateString("[nomad] "+name, maxNameLen),
+		Key:              key,
+		Rules:            []lib.Rule{createRule(spec)},
+		Tags:             append(createTags(), "nomad"),
+		Type:             "heartbeat",
+		Timezone:         job.Timezone(),
+		Note:             fmt.Sprintf("Discovered in Nomad job %s/%s", job.Namespace, job.ID),
+		Notifications:    notificationListMap,
+		Assertions:       assertions,
+		NoStdoutPassthru: noStdoutPassthru,
+	}
+}
+
+func effectiveNomadAddr() string {
+	if len(nomadAddr) > 0 {
+		return nomadAddr
+	}
+
+	if addr := os.Getenv("NOMAD_ADDR"); len(addr) > 0 {
+		return addr
+	}
+
+	return "http://127.0.0.1:4646"
+}
+
+func effectiveNomadToken() string {
+	if len(nomadToken) > 0 {
+		return nomadToken
+	}
+
+	return os.Getenv("NOMAD_TOKEN")
+}
+
+// monitorKey returns the key used to match a cron job with its monitor. With --normalize-command the key is computed
+// from the normalized command, except for monitors that already exist under the key of the exact command.
+func monitorKey(line *lib.Line, crontab *lib.Crontab) string {
+	key := line.Key(crontab.CanonicalName())
+	if !normalizeCommand || existingMonitors.HasMonitorByKey(key) {
+		return key
+	}
+
+	return line.NormalizedKey(crontab.CanonicalName(), normalizeSortFlags)
+}
+
+// deterministicCode returns the code for a job with --deterministic-codes. Codes are lengthened until they are not used
+// by a monitor with a different key, either an existing monitor or one discovered earlier in this run.
+func deterministicCode(line *lib.Line, key string) string {
+	// Like monitor keys, codes use os.Hostname so that --hostname does not change them
+	hostname, _ := os.Hostname()
+	code := ""
+	for length := 12; length <= 64; length += 4 {
+		code = line.DeterministicCode(hostname, normalizeSortFlags, length)
+		if !deterministicCodeTaken(code, key) {
+			break
+		}
+		log(fmt.Sprintf("Code %s is used by another monitor, lengthening it", code))
+	}
+
+	assignedCodes[code] = key
+	return code
+}
+
+func deterministicCodeTaken(code string, key string) bool {
+	if assignedKey, ok := assignedCodes[code]; ok && assignedKey != key {
+		return true
+	}
+
+	for _, monitor := range existingMonitors.Monitors {
+		if monitor.Code == code && monitor.Key != key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printDiscoveredLine(line *lib.Line) {
+	status := "not monitored"
+	if len(line.Code) > 0 {
+		status = "monitored as " + line.Code
+	}
+
+	fmt.Println(fmt.Sprintf("    %s  %s  (%s)", line.CronExpression, line.CommandToRun, status))
+}
+
+// writeManifest writes the monitors that --import would send to the Cronitor API as a JSON array. The manifest is
+// checked against lib.ManifestSchema before it is written, so 'cronitor schema --validate' accepts it.
+func writeManifest(path string, monitors []*lib.Monitor) error {
+	if monitors == nil {
+		monitors = []*lib.Monitor{}
+	}
+
+	for _, monitor := range monitors {
+		// The schema requires arrays, so empty lists are written as [] rather than null
+		if monitor.Rules == nil {
+			monitor.Rules = []lib.Rule{}
+		}
+		if monitor.Tags == nil {
+			monitor.Tags = []string{}
+		}
+	}
+
+	contents, err := json.MarshalIndent(monitors, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if errs := lib.ValidateManifest(contents); len(errs) > 0 {
+		return errors.New(fmt.Sprintf("the manifest does not match its schema: %s", errs[0].Error()))
+	}
+
+	if err := ioutil.WriteFile(path, append(contents, '\n'), 0644); err != nil {
+		return errors.New(fmt.Sprintf("the manifest could not be written to %s: %s", path, err.Error()))
+	}
+
+	return nil
+}
+
 func createNote(line *lib.Line, crontab *lib.Crontab) string {
 	if line.IsAutoDiscoverCommand() {
 		return fmt.Sprintf("Watching for schedule changes and new entries in %s", crontab.DisplayName())
 	}
 
-	return fmt.Sprintf("Discovered in %s L%d", crontab.DisplayName(), line.LineNumber)
+	note := fmt.Sprintf("Discovered in %s L%d", crontab.DisplayName(), line.LineNumber)
+	if hostMetadata != nil {
+		note += "\nHost: " + hostMetadata.String()
+	}
+
+	return note
 }
 
 func createDefaultName(line *lib.Line, crontab *lib.Crontab, effectiveHostname string, excludeFromName []string, allNameCandidates map[string]bool) string {
@@ -432,7 +763,9 @@ func createDefaultName(line *lib.Line, crontab *lib.Crontab, effectiveHostname s
 		return candidate
 	}
 
-	// Keep the first and last portion of the command
+	// Keep the first and last portion of the command. The line number is added back after truncating so a name
+	// conflict does not end with it twice.
+	candidate = strings.TrimSuffix(candidate, lineNumSuffix)
 	separator := "..."
 
 	commandPrefixLen := 20 + len(formattedHostname) + len(formattedRunAs)
@@ -444,14 +777,88 @@ func createDefaultName(line *lib.Line, crontab *lib.Crontab, effectiveHostname s
 		strings.TrimSpace(candidate[len(candidate)-commandSuffixLen:]), lineNumSuffix)
 }
 
+type PingApiKeyMapping struct {
+	Pattern *regexp.Regexp
+	Key     string
+}
+
+// readPingApiKeyMap parses a file of "<glob pattern> <ping api key>" lines. The key is the last word of the line and the
+// rest is the pattern, so a pattern can contain spaces. The glob is matched against the full command, where * matches
+// any run of characters (including "/") and ? matches a single character.
+func readPingApiKeyMap(path string) ([]PingApiKeyMapping, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("the ping API key map %s could not be read: %s", path, err.Error()))
+	}
+
+	var mappings []PingApiKeyMapping
+	for lineNumber, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, errors.New(fmt.Sprintf("invalid ping API key map entry at %s L%d: expected a pattern and a key", path, lineNumber+1))
+		}
+
+		// Commands are matched with single spaces between words, see lib.Crontab.Parse
+		pattern := regexp.QuoteMeta(strings.Join(fields[:len(fields)-1], " "))
+		pattern = strings.Replace(pattern, "\\*", ".*", -1)
+		pattern = strings.Replace(pattern, "\\?", ".", -1)
+		mappings = append(mappings, PingApiKeyMapping{regexp.MustCompile("^" + pattern + "$"), fields[len(fields)-1]})
+	}
+
+	return mappings, nil
+}
+
+func pingApiKeyForCommand(command string) string {
+	for _, mapping := range pingApiKeyMap {
+		if mapping.Pattern.MatchString(command) {
+			return mapping.Key
+		}
+	}
+
+	return ""
+}
+
 func createTags() []string {
 	var tags []string
 	tags = append(tags, "cron-job")
+	if hostMetadata != nil {
+		tags = append(tags, hostMetadata.Tags()...)
+	}
 	return tags
 }
 
 func createRule(cronExpression string) lib.Rule {
-	return lib.Rule{"not_on_schedule", lib.RuleValue(cronExpression), "", 0}
+	return lib.Rule{RuleType: "not_on_schedule", Value: lib.RuleValue(cronExpression)}
+}
+
+var assertionRegex = regexp.MustCompile(`^metric\.(duration|count|error_count)\s*(<=|>=|!=|<|>|=)\s*([0-9]+(\.[0-9]+)?)\s*([a-z]*)$`)
+
+// validateAssertion checks the syntax of an assertion like "metric.duration < 60s" before it is sent to Cronitor
+func validateAssertion(assertion string) error {
+	matches := assertionRegex.FindStringSubmatch(strings.TrimSpace(assertion))
+	if matches == nil {
+		return errors.New(fmt.Sprintf("invalid assertion '%s', expected e.g. \"metric.duration < 60s\" or \"metric.error_count = 0\"", assertion))
+	}
+
+	metric, unit := matches[1], matches[5]
+	if metric == "duration" {
+		switch unit {
+		case "", "ms", "s", "sec", "seconds", "min", "minutes", "h", "hours":
+			return nil
+		}
+		return errors.New(fmt.Sprintf("invalid assertion '%s', unknown duration unit '%s'", assertion, unit))
+	}
+
+	if len(unit) > 0 {
+		return errors.New(fmt.Sprintf("invalid assertion '%s', metric.%s does not take a unit", assertion, metric))
+	}
+
+	return nil
 }
 
 func validateName(candidateName string) error {
@@ -486,11 +893,22 @@ func promptTemplates() *promptui.PromptTemplates {
 func init() {
 	RootCmd.AddCommand(discoverCmd)
 	discoverCmd.Flags().BoolVar(&saveCrontabFile, "save", saveCrontabFile, "Save the updated crontab file")
-	discoverCmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "Import crontab into Cronitor without applying necessary integration")
+	discoverCmd.Flags().BoolVar(&importMonitors, "import", importMonitors, "Create or update monitors in Cronitor and add integration to the crontab")
+	discoverCmd.Flags().StringVar(&manifestOutFile, "manifest-out", manifestOutFile, "Write the monitors that would be imported to this JSON file without contacting Cronitor")
+	discoverCmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "With --import, import crontab into Cronitor without applying necessary integration")
 	discoverCmd.Flags().StringArrayVarP(&excludeFromName, "exclude-from-name", "e", excludeFromName, "Substring to exclude from auto-generated monitor name e.g. $ cronitor discover -e '> /dev/null' -e '/path/to/app'")
 	discoverCmd.Flags().BoolVar(&noAutoDiscover, "no-auto-discover", noAutoDiscover, "Do not attach an automatic discover job to this crontab, or remove if already attached.")
 	discoverCmd.Flags().BoolVar(&noStdoutPassthru, "no-stdout", noStdoutPassthru, "Do not send cron job output to Cronitor when your job completes.")
 	discoverCmd.Flags().StringVar(&notificationList, "notification-list", notificationList, "Use the provided notification list when creating or updating monitors, or \"default\" list if omitted.")
+	discoverCmd.Flags().StringVar(&pingApiKeyMapFile, "ping-api-key-map", pingApiKeyMapFile, "File of \"<pattern> <ping api key>\" lines used to add a --ping-api-key to the integration of matching jobs")
+	discoverCmd.Flags().StringArrayVar(&assertions, "assert", assertions, "Assertion to add to each monitor, e.g. \"metric.duration < 5 min\". Can be repeated")
+	discoverCmd.Flags().BoolVar(&normalizeCommand, "normalize-command", normalizeCommand, "Match jobs to monitors using a normalized command so whitespace and \"cd <dir> &&\" changes do not create new monitors")
+	discoverCmd.Flags().BoolVar(&normalizeSortFlags, "normalize-sort-flags", normalizeSortFlags, "With --normalize-command, also ignore the order of command flags")
+	discoverCmd.Flags().BoolVar(&deterministicCodes, "deterministic-codes", deterministicCodes, "Give jobs without integration a monitor code hashed from the hostname, normalized command, schedule and user")
+	discoverCmd.Flags().BoolVar(&reportHostMetadata, "report-host-metadata", reportHostMetadata, "Tag monitors with the OS and architecture, and add the kernel version and uptime to the monitor note")
+	discoverCmd.Flags().BoolVar(&discoverNomad, "nomad", discoverNomad, "Discover HashiCorp Nomad periodic jobs instead of cron jobs")
+	discoverCmd.Flags().StringVar(&nomadAddr, "nomad-addr", nomadAddr, "Address of the Nomad API (default: NOMAD_ADDR or http://127.0.0.1:4646)")
+	discoverCmd.Flags().StringVar(&nomadToken, "nomad-token", nomadToken, "Nomad ACL token (default: NOMAD_TOKEN)")
 	discoverCmd.Flags().BoolVar(&isAutoDiscover, "auto", isAutoDiscover, "Do not use an interactive shell. Write updated crontab to stdout.")
 
 	discoverCmd.Flags().BoolVar(&isSilent, "silent", isSilent, "")
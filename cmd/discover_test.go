@@ -1,10 +1,17 @@
 package cmd
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+)
 
 func TestCreateDefaultNameHasAddCandidateSideEffect(t *testing.T) {
 	allNameCandidates := map[string]bool{"something": true}
-	createDefaultName("/var/some/command arg1 arg2", "", 11, false, "localhost", nil, allNameCandidates)
+	line := &lib.Line{CommandToRun: "/var/some/command arg1 arg2", LineNumber: 11}
+	createDefaultName(line, &lib.Crontab{Filename: "/discover/test"}, "localhost", nil, allNameCandidates)
 
 	if len(allNameCandidates) == 0 || allNameCandidates["[localhost] /var/some/command arg1 arg2"] != true {
 		t.Error("Name candidate not added to allNameCandidates")
@@ -13,7 +20,7 @@ func TestCreateDefaultNameHasAddCandidateSideEffect(t *testing.T) {
 
 func TestCreateDefaultName(t *testing.T) {
 
-	crontabPath = "/discover/test"
+	crontab := &lib.Crontab{Filename: "/discover/test"}
 
 	allNameCandidates := map[string]bool{
 		"[localhost] /var/some/command arg1 arg2": true,
@@ -21,21 +28,19 @@ func TestCreateDefaultName(t *testing.T) {
 	}
 
 	tables := []struct {
-		caseName              string
-		command               string
-		runAs                 string
-		lineNumber            int
-		isAutoDiscoverCommand bool
-		hostname              string
-		excludeFromName       []string
-		allNameCandidates     map[string]bool
-		expected              string
+		caseName          string
+		command           string
+		runAs             string
+		lineNumber        int
+		hostname          string
+		excludeFromName   []string
+		allNameCandidates map[string]bool
+		expected          string
 	}{
 		{"short command",
 			"/var/some/command arg1 arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -45,7 +50,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			allNameCandidates,
@@ -55,7 +59,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2",
 			"rando",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -65,7 +68,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2",
 			"rando",
 			11,
-			false,
 			"localhost",
 			nil,
 			allNameCandidates,
@@ -75,7 +77,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2 > /dev/null",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -85,7 +86,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2 >> /tmp/logfile",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -95,7 +95,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2 2>&1 > /dev/null",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -105,7 +104,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/some/boilerplate/prefix /var/some/command arg1 argToRemove arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			[]string{"/some/boilerplate/prefix", "argToRemove"},
 			map[string]bool{},
@@ -115,7 +113,6 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command \\'arg1\\' arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -125,17 +122,15 @@ func TestCreateDefaultName(t *testing.T) {
 			"/var/some/command arg1 arg2",
 			"",
 			11,
-			false,
 			"",
 			nil,
 			map[string]bool{},
 			"/var/some/command arg1 arg2"},
 
 		{"auto discover name is created",
-			"cronitor d3x0c1 cronitor discover /discover/test",
+			"cronitor d3x0c1 cronitor discover --auto /discover/test",
 			"",
 			11,
-			true,
 			"localhost",
 			nil,
 			map[string]bool{},
@@ -145,47 +140,126 @@ func TestCreateDefaultName(t *testing.T) {
 			"cd /var/some/deeply/nested/custom/app/directory/containing/command ; FOO=BAR run-command-here arg1 arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
-			"[localhost] cd /var/some/deeply/...directory/containing/command ; FOO=BAR run-command-here arg1 arg2"},
+			"[localhost] cd /var/some/deeply/...; FOO=BAR run-command-here arg1 arg2 L11"},
 
 		{"exclusion text is applied before truncation",
 			"cd /var/some/deeply/nested/custom/app/directory/containing/command ; FOO=BAR run-command-here arg1 arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			[]string{"/var/some/deeply/nested/"},
 			map[string]bool{},
-			"[localhost] cd custom/app/directory/containing/command ; FOO=BAR run-command-here arg1 arg2"},
+			"[localhost] cd custom/app/direct...; FOO=BAR run-command-here arg1 arg2 L11"},
 
 		{"long command with name conflict",
 			"cd /var/some/deeply/nested/custom/app/directory/containing/command ; FOO=BAR run-command-here arg1 arg2",
 			"",
 			11,
-			false,
 			"localhost",
 			nil,
 			allNameCandidates,
-			"[localhost] cd /var/some/deeply/...ctory/containing/command ; FOO=BAR run-command-here arg1 arg2 L11"},
+			"[localhost] cd /var/some/deeply/...; FOO=BAR run-command-here arg1 arg2 L11"},
 
 		{"long command with runAs",
 			"cd /var/some/deeply/nested/custom/app/directory/containing/command ; FOO=BAR run-command-here arg1 arg2",
 			"rando",
 			11,
-			false,
 			"localhost",
 			nil,
 			map[string]bool{},
-			"[localhost] rando cd /var/some/deeply/...ory/containing/command ; FOO=BAR run-command-here arg1 arg2"},
+			"[localhost] rando cd /var/some/deeply/...BAR run-command-here arg1 arg2 L11"},
 	}
 
 	for _, table := range tables {
-		defaultName := createDefaultName(table.command, table.runAs, table.lineNumber, table.isAutoDiscoverCommand, table.hostname, table.excludeFromName, table.allNameCandidates)
+		line := &lib.Line{CommandToRun: table.command, RunAs: table.runAs, LineNumber: table.lineNumber}
+		defaultName := createDefaultName(line, crontab, table.hostname, table.excludeFromName, table.allNameCandidates)
 		if defaultName != table.expected {
 			t.Errorf("Test case '%s' failed, got: %s, expected: %s.", table.caseName, defaultName, table.expected)
 		}
 	}
 }
+
+func TestReadPingApiKeyMap(t *testing.T) {
+	file, err := ioutil.TempFile("", "ping-api-key-map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# billing jobs\n/var/app/billing/run.sh   --full * 4f3e9a\n*backup* 9b1c2d\n")
+	file.Close()
+
+	mappings, err := readPingApiKeyMap(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pingApiKeyMap = mappings
+	defer func() { pingApiKeyMap = nil }()
+
+	tables := []struct {
+		command  string
+		expected string
+	}{
+		{"/var/app/billing/run.sh --full --since yesterday", "4f3e9a"},
+		{"/var/app/billing/run.sh --quick", ""},
+		{"/usr/bin/backup.sh", "9b1c2d"},
+	}
+
+	for _, table := range tables {
+		if key := pingApiKeyForCommand(table.command); key != table.expected {
+			t.Errorf("pingApiKeyForCommand(%s) was %s, expected %s", table.command, key, table.expected)
+		}
+	}
+}
+
+func TestReadPingApiKeyMapRejectsLineWithoutKey(t *testing.T) {
+	file, err := ioutil.TempFile("", "ping-api-key-map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("*backup*\n")
+	file.Close()
+
+	if _, err := readPingApiKeyMap(file.Name()); err == nil {
+		t.Error("Expected an entry without a key to be rejected")
+	}
+}
+
+func TestWriteManifestMatchesSchema(t *testing.T) {
+	file, err := ioutil.TempFile("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	monitors := []*lib.Monitor{
+		{
+			DefaultName: "[localhost] /var/some/command",
+			Key:         "4f3e9a",
+			Rules:       []lib.Rule{createRule("0 2 * * *")},
+			Tags:        createTags(),
+			Type:        "heartbeat",
+			Timezone:    "UTC",
+			Assertions:  []string{"metric.duration < 5 min"},
+		},
+		{DefaultName: "[localhost] /var/other/command", Key: "9b1c2d", Type: "heartbeat"},
+	}
+
+	if err := writeManifest(file.Name(), monitors); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := lib.ValidateManifest(contents); len(errs) > 0 {
+		t.Errorf("Expected the manifest to match the schema, got %v", errs)
+	}
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	checkPass = "Pass"
+	checkWarn = "Warn"
+	checkFail = "Fail"
+)
+
+type DoctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check this host's configuration and connectivity to Cronitor",
+	Long: `
+Run a series of diagnostic checks and print the results:
+  - Config file location and permissions
+  - API key and ping API key
+  - DNS resolution and TLS handshake for the ping and API hosts
+  - Clock skew compared with the ping host
+  - Timezone detection
+  - Write access to the debug log and the exec output directory
+
+Exits with code 1 if any check fails. Warnings do not change the exit code.
+
+Example:
+  $ cronitor doctor
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pingHost := pingApiHostForAttempt(1)
+		apiHost := getCronitorApi().Url()
+
+		var checks []DoctorCheck
+		checks = append(checks, checkConfigFile())
+		checks = append(checks, checkKeys())
+		checks = append(checks, checkHost("Ping host", pingHost)...)
+		checks = append(checks, checkHost("API host", apiHost)...)
+		checks = append(checks, checkClockSkew(pingHost))
+		checks = append(checks, checkTimezone())
+		checks = append(checks, checkLogFile())
+		checks = append(checks, checkTempDirectory())
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Check", "Result", "Detail"})
+		table.SetAutoWrapText(false)
+		table.SetHeaderAlignment(3)
+
+		failed := 0
+		for _, check := range checks {
+			if check.Status == checkFail {
+				failed++
+			}
+			table.Append([]string{check.Name, check.Status, check.Detail})
+		}
+
+		table.Render()
+
+		if failed > 0 {
+			fatal(fmt.Sprintf("%d of %d checks failed", failed, len(checks)), exitFailure)
+		}
+	},
+}
+
+func checkConfigFile() DoctorCheck {
+	check := DoctorCheck{Name: "Config file"}
+	configFile := viper.ConfigFileUsed()
+	if len(configFile) == 0 {
+		check.Status = checkWarn
+		check.Detail = "No config file found, searched " + strings.Join(configFileDirectories(), ", ")
+		return check
+	}
+
+	stat, err := os.Stat(configFile)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+
+	if _, err := ioutil.ReadFile(configFile); err != nil {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%s is not readable: %s", configFile, err.Error())
+		return check
+	}
+
+	if stat.Mode().Perm()&0004 != 0 {
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("%s is readable by all users (%s)", configFile, stat.Mode().Perm())
+		return check
+	}
+
+	check.Status = checkPass
+	check.Detail = configFile
+	return check
+}
+
+func checkKeys() DoctorCheck {
+	check := DoctorCheck{Name: "Keys"}
+	hasApiKey := len(viper.GetString(varApiKey)) > 0
+	hasPingApiKey := len(viper.GetString(varPingApiKey)) > 0
+
+	switch {
+	case hasApiKey && hasPingApiKey:
+		check.Status, check.Detail = checkPass, "API key and ping API key are set"
+	case hasApiKey:
+		check.Status, check.Detail = checkPass, "API key is set and will be used for pings"
+	case hasPingApiKey:
+		check.Status, check.Detail = checkWarn, "Only a ping API key is set, commands that call the API will not work"
+	default:
+		check.Status, check.Detail = checkFail, "No API key or ping API key is set, see 'cronitor configure'"
+	}
+
+	return check
+}
+
+func checkHost(name string, hostUrl string) []DoctorCheck {
+	dnsCheck := DoctorCheck{Name: name + " DNS"}
+	parsed, err := url.Parse(hostUrl)
+	if err != nil || len(parsed.Hostname()) == 0 {
+		dnsCheck.Status, dnsCheck.Detail = checkFail, "Invalid URL "+hostUrl
+		return []DoctorCheck{dnsCheck}
+	}
+
+	addresses, err := net.LookupHost(parsed.Hostname())
+	if err != nil {
+		dnsCheck.Status, dnsCheck.Detail = checkFail, err.Error()
+		return []DoctorCheck{dnsCheck}
+	}
+	dnsCheck.Status, dnsCheck.Detail = checkPass, fmt.Sprintf("%s resolves to %s", parsed.Hostname(), strings.Join(addresses, ", "))
+
+	if parsed.Scheme != "https" {
+		return []DoctorCheck{dnsCheck}
+	}
+
+	tlsCheck := DoctorCheck{Name: name + " TLS"}
+	port := parsed.Port()
+	if len(port) == 0 {
+		port = "443"
+	}
+
+	tlsConfig := &tls.Config{}
+	if lib.Transport.TLSClientConfig != nil {
+		tlsConfig = lib.Transport.TLSClientConfig.Clone()
+	}
+	tlsConfig.ServerName = parsed.Hostname()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", net.JoinHostPort(parsed.Hostname(), port), tlsConfig)
+	if err != nil {
+		tlsCheck.Status, tlsCheck.Detail = checkFail, err.Error()
+	} else {
+		conn.Close()
+		tlsCheck.Status, tlsCheck.Detail = checkPass, fmt.Sprintf("Handshake with %s succeeded", parsed.Host)
+	}
+
+	return []DoctorCheck{dnsCheck, tlsCheck}
+}
+
+func checkClockSkew(hostUrl string) DoctorCheck {
+	check := DoctorCheck{Name: "Clock skew"}
+	skew, err := measureClockSkew(hostUrl)
+	if err != nil {
+		check.Status, check.Detail = checkWarn, "Could not be measured: "+err.Error()
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("Local clock differs from %s by %s", hostUrl, skew)
+	if skew > 30*time.Second || skew < -30*time.Second {
+		check.Status = checkWarn
+	} else {
+		check.Status = checkPass
+	}
+
+	return check
+}
+
+func checkTimezone() DoctorCheck {
+	check := DoctorCheck{Name: "Timezone"}
+	timezone := strings.TrimSpace(effectiveTimezoneLocationName().Name)
+	if len(timezone) == 0 {
+		check.Status, check.Detail = checkWarn, "Could not be detected, set TZ or CRON_TZ"
+		return check
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil {
+		check.Status, check.Detail = checkWarn, fmt.Sprintf("Detected %s but it is not a known location: %s", timezone, err.Error())
+		if !embeddedTzdata {
+			check.Detail += ". If this host has no time zone database, install tzdata or use a build with -tags tzdata"
+		}
+		return check
+	}
+
+	check.Status, check.Detail = checkPass, timezone
+	return check
+}
+
+func checkLogFile() DoctorCheck {
+	check := DoctorCheck{Name: "Debug log"}
+	debugLog := viper.GetString(varLog)
+	if len(debugLog) == 0 {
+		check.Status, check.Detail = checkPass, "Disabled"
+		return check
+	}
+
+	f, err := os.OpenFile(debugLog, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	f.Close()
+
+	check.Status, check.Detail = checkPass, debugLog+" is writable"
+	return check
+}
+
+func checkTempDirectory() DoctorCheck {
+	check := DoctorCheck{Name: "Exec output directory"}
+	path := filepath.Join(os.TempDir(), "cronitor")
+	os.MkdirAll(path, os.ModePerm)
+
+	f, err := ioutil.TempFile(path, "doctor-*")
+	if err != nil {
+		check.Status, check.Detail = checkWarn, "Job output cannot be captured: "+err.Error()
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	check.Status, check.Detail = checkPass, path+" is writable"
+	return check
+}
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+}
@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/fatih/color"
 	"github.com/kballard/go-shellquote"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -13,16 +18,85 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 var monitorCode string
 var commandParts []string
+var failOnPingError bool
+var onSuccessHook string
+var onFailureHook string
+var strictHooks bool
+var commandFile string
+var scriptStdin bool
+var warnExitCodes []int
+var otelEndpoint string
+var maxOutputRate int64
+var maxOutputTotal int64
+var capPassthrough bool
+var otelSpan *execSpan
+var runPingMessage string
+var runPingNotes []string
+var runPingMetrics map[string]int
+var startDeadline string
+var resultJsonLine bool
+var resultMetrics []string
+var resultMessageField string
+var cronSchedule string
+var skipIfNoStdin bool
+var noStdin bool
+var peekedStdin io.Reader
+var cleanEnv bool
+var captureStream string
+var envPassthrough []string
+var jobEnvFiles []string
+var jobEnv []string
+var execMessage string
+var reportGit bool
+var gitRevision string
+var reportCwd bool
+var reportArgs bool
+var runCwd string
+var runArgs string
+var failIfEmptyOutput bool
+var failIfEmptyOutputExit int
+var failOnStderr bool
+var failOnStderrPattern string
+var failOnStderrRegex *regexp.Regexp
+var failOnStderrExit int
+var logSample string
+var logSampleEvery int
+var logMaxLines int
+var onMissingMonitor string
+var pidFile string
+var pingOnChange bool
+var heartbeatInterval time.Duration
+var progressInterval time.Duration
+var messageFilter string
+var messageFilterTimeout time.Duration
+var dumpPingFile string
+var notifyLocalHook string
+var captureFile string
+var maxConcurrentExec int
+var maxConcurrentWait time.Duration
+var captureFileMaxSize int64
+var detach bool
+var codeFromScript bool
+var checksumCommand bool
+var expectedChecksum string
+
+// detachedEnvVar is set in the environment of the background process started by --detach
+const detachedEnvVar = "CRONITOR_DETACHED"
+
 var execCmd = &cobra.Command{
 	Use:   "exec",
 	Short: "Execute a command with monitoring",
@@ -37,7 +111,18 @@ Example:
 
 Example with no command output send to Cronitor:
   By default, stdout and stderr messages are sent to Cronitor when your job completes. To prevent any output from being sent to cronitor, use the --no-stdout flag:
-  $ cronitor exec --no-stdout d3x0c1 /path/to/command.sh --command-param argument1 argument2`,
+  $ cronitor exec --no-stdout d3x0c1 /path/to/command.sh --command-param argument1 argument2
+
+Example running a local command after your job finishes:
+  $ cronitor exec --on-success "rm -rf /tmp/job-scratch" --on-failure "/usr/local/bin/page-oncall" d3x0c1 /path/to/command.sh
+
+Example adding context to the captured output:
+  $ cronitor exec --message "Nightly backup of db-1" d3x0c1 /path/to/backup.sh
+
+Example that exits nonzero if Cronitor could not be notified:
+  $ cronitor exec --fail-on-ping-error d3x0c1 /path/to/command.sh
+
+Every option is described with more examples in https://github.com/cronitorio/cronitor-cli/blob/master/docs/exec.md`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		// We need to use raw os.Args so we can pass the wrapped command through unparsed
 		var foundExec, foundCode bool
@@ -52,6 +137,7 @@ Example with no command output send to Cronitor:
 			allFlags["-"+flag.Shorthand] = true
 		})
 
+		skipFlagValue := false
 		for _, arg := range os.Args {
 			arg = strings.TrimSpace(arg)
 			// Treat anything that comes after the monitor code as the command to execute
@@ -62,7 +148,18 @@ Example with no command output send to Cronitor:
 
 			// After finding "exec" we are looking for a monitor code
 			if foundExec && !foundCode {
+				if skipFlagValue {
+					skipFlagValue = false
+					continue
+				}
+
 				if _, is_flag := allFlags[arg]; is_flag {
+					skipFlagValue = isFlagWithValue(cmd, arg)
+					continue
+				}
+
+				// Flags with an inline value, e.g. --on-success=cleanup.sh
+				if strings.HasPrefix(arg, "-") {
 					continue
 				}
 
@@ -70,7 +167,12 @@ Example with no command output send to Cronitor:
 					continue
 				}
 
-				monitorCode = arg
+				// With --code-from-script there is no monitor code argument, the command starts here
+				if codeFromScript {
+					commandParts = append(commandParts, arg)
+				} else {
+					monitorCode = arg
+				}
 				foundCode = true
 			}
 
@@ -87,66 +189,517 @@ Example with no command output send to Cronitor:
 			commandParts = commandParts[1:]
 		}
 
-		if len(monitorCode) < 1 || len(commandParts) < 1 {
+		if captureStream != "both" && captureStream != "stdout" && captureStream != "stderr" {
+			return errors.New("invalid argument supplied to 'capture'. Expecting 'both', 'stdout' or 'stderr'")
+		}
+
+		if failIfEmptyOutputExit != 0 && !failIfEmptyOutput {
+			return errors.New("--fail-if-empty-output-exit can only be used with --fail-if-empty-output")
+		}
+
+		if failIfEmptyOutputExit < 0 || failIfEmptyOutputExit > 255 {
+			return errors.New("invalid argument supplied to 'fail-if-empty-output-exit'. Expecting an exit code from 1 to 255")
+		}
+
+		if len(failOnStderrPattern) > 0 {
+			regex, err := regexp.Compile(failOnStderrPattern)
+			if err != nil {
+				return fmt.Errorf("invalid argument supplied to 'fail-on-stderr-pattern': %s", err.Error())
+			}
+			failOnStderrRegex = regex
+		}
+
+		if failOnStderrExit != 0 && !failOnStderr && failOnStderrRegex == nil {
+			return errors.New("--fail-on-stderr-exit can only be used with --fail-on-stderr or --fail-on-stderr-pattern")
+		}
+
+		if failOnStderrExit < 0 || failOnStderrExit > 255 {
+			return errors.New("invalid argument supplied to 'fail-on-stderr-exit'. Expecting an exit code from 1 to 255")
+		}
+
+		if len(logSample) > 0 {
+			every, err := parseLogSample(logSample)
+			if err != nil {
+				return err
+			}
+			logSampleEvery = every
+		}
+
+		if logMaxLines < 0 {
+			return errors.New("invalid argument supplied to 'log-max-lines'. Expecting a positive number")
+		}
+
+		if len(envPassthrough) > 0 && !cleanEnv {
+			return errors.New("--env-passthrough can only be used with --clean-env")
+		}
+
+		// A command of - reads the script from stdin, like --script-stdin
+		if len(commandParts) == 1 && commandParts[0] == "-" {
+			scriptStdin = true
+			commandParts = nil
+		}
+
+		if scriptStdin && len(commandParts) > 0 {
+			return errors.New("A command cannot be used with --script-stdin, pass the script on stdin instead")
+		}
+
+		if scriptStdin && len(commandFile) > 0 {
+			return errors.New("--script-stdin cannot be used with --command-file")
+		}
+
+		if noStdin && (scriptStdin || skipIfNoStdin) {
+			return errors.New("--no-stdin cannot be used with --script-stdin or --skip-if-no-stdin, which read stdin")
+		}
+
+		if detach && (scriptStdin || skipIfNoStdin) {
+			return errors.New("--detach cannot be used with --script-stdin or --skip-if-no-stdin, a detached command has no stdin")
+		}
+
+		if scriptStdin && skipIfNoStdin {
+			return errors.New("--script-stdin cannot be used with --skip-if-no-stdin, the script is read from stdin")
+		}
+
+		if len(commandFile) > 0 && len(commandParts) > 0 {
+			return errors.New("A command cannot be used with --command-file, put the command in the file instead")
+		}
+
+		if len(expectedChecksum) > 0 {
+			expectedChecksum = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(expectedChecksum), "sha256:"))
+			if !sha256HexRegex.MatchString(expectedChecksum) {
+				return errors.New("invalid argument supplied to 'expected-checksum'. Expecting a SHA-256 hash of 64 hex characters")
+			}
+		}
+
+		if codeFromScript {
+			if scriptStdin {
+				return errors.New("--code-from-script cannot be used with --script-stdin, use --command-file instead")
+			}
+
+			script := commandFile
+			if len(script) == 0 && len(commandParts) > 0 {
+				script = firstCommandWord(commandParts[0])
+			}
+
+			if len(script) == 0 {
+				return errors.New("A command is required with --code-from-script e.g. cronitor exec --code-from-script -- /path/to/job.sh")
+			}
+
+			code, err := readScriptMonitorCode(script)
+			if err != nil {
+				return err
+			}
+			monitorCode = code
+		}
+
+		if len(monitorCode) < 1 || (len(commandParts) < 1 && len(commandFile) < 1 && !scriptStdin) {
 			return errors.New("A unique monitor key and cli command are required e.g. cronitor exec d3x0c1 /path/to/command.sh")
 		}
 
-		return nil
+		if err := requirePingAuthentication(); err != nil {
+			return err
+		}
+
+		if err := requireAllowedCode(monitorCode); err != nil {
+			return err
+		}
+
+		if (len(resultMetrics) > 0 || len(resultMessageField) > 0) && !resultJsonLine {
+			return errors.New("--result-metric and --result-message can only be used with --result-json-line")
+		}
+
+		if _, err := parseResultMetrics(resultMetrics); err != nil {
+			return err
+		}
+
+		if len(startDeadline) > 0 {
+			if _, _, err := parseStartDeadline(startDeadline); err != nil {
+				return err
+			}
+		}
+
+		if len(cronSchedule) > 0 {
+			if len(startDeadline) == 0 {
+				return errors.New("--schedule can only be used with --start-deadline")
+			}
+
+			if _, err := lib.ParseCronSchedule(cronSchedule); err != nil {
+				return err
+			}
+		}
+
+		if maxOutputRate < 0 || maxOutputTotal < 0 {
+			return errors.New("invalid argument supplied to 'max-output-rate' or 'max-output-total'. Expecting a positive number of bytes")
+		}
+
+		if capPassthrough && maxOutputRate == 0 && maxOutputTotal == 0 {
+			return errors.New("--cap-passthrough can only be used with --max-output-rate or --max-output-total")
+		}
+
+		if len(otelEndpoint) > 0 && !strings.HasPrefix(otelEndpoint, "http://") && !strings.HasPrefix(otelEndpoint, "https://") {
+			return errors.New("invalid argument supplied to 'otel-endpoint'. Expecting an http:// or https:// URL")
+		}
+
+		for _, code := range warnExitCodes {
+			if code < 1 || code > 255 {
+				return errors.New("invalid argument supplied to 'warn-exit-codes'. Expecting exit codes from 1 to 255")
+			}
+		}
+
+		if maxConcurrentExec < 0 {
+			return errors.New("invalid argument supplied to 'max-concurrent-exec'. Expecting a positive number")
+		}
+
+		if cmd.Flags().Changed("max-concurrent-wait") && maxConcurrentExec == 0 {
+			return errors.New("--max-concurrent-wait can only be used with --max-concurrent-exec")
+		}
+
+		if cmd.Flags().Changed("heartbeat-interval") && !pingOnChange {
+			return errors.New("--heartbeat-interval can only be used with --ping-on-change")
+		}
+
+		if heartbeatInterval <= 0 {
+			return errors.New("invalid argument supplied to 'heartbeat-interval'. Expecting a positive duration, e.g. 1h")
+		}
+
+		if messageFilterTimeout <= 0 {
+			return errors.New("invalid argument supplied to 'message-filter-timeout'. Expecting a positive duration, e.g. 10s")
+		}
+
+		if cmd.Flags().Changed("message-filter-timeout") && len(messageFilter) == 0 {
+			return errors.New("--message-filter-timeout can only be used with --message-filter")
+		}
+
+		if progressInterval < 0 {
+			return errors.New("invalid argument supplied to 'progress-interval'. Expecting a positive duration, e.g. 15m")
+		}
+
+		if progressInterval > 0 && pingOnChange {
+			return errors.New("--progress-interval cannot be used with --ping-on-change, which does not send run pings")
+		}
+
+		if len(onMissingMonitor) > 0 {
+			if onMissingMonitor != "create" && onMissingMonitor != "fail" {
+				return errors.New("invalid argument supplied to 'on-missing-monitor'. Expecting 'create' or 'fail'")
+			}
+
+			if err := requireApiKey(cmd); err != nil {
+				return err
+			}
+		}
+
+		return applyMonitorRules(cmd, monitorCode)
 	},
 
 	Run: func(cmd *cobra.Command, args []string) {
+		if detach {
+			if len(os.Getenv(detachedEnvVar)) == 0 {
+				pid, err := startDetached()
+				if err != nil {
+					failBeforeRun(fmt.Sprintf("Cannot start detached exec: %s", err.Error()), exitInternal)
+				}
+
+				log(fmt.Sprintf("Started detached exec as PID %d", pid))
+				exit(exitSuccess)
+			}
+
+			// Running in the background, the command itself should not inherit the marker
+			os.Unsetenv(detachedEnvVar)
+		}
+
+		wrapperStart := time.Now()
+		if len(onMissingMonitor) > 0 {
+			ensureMonitorExists()
+		}
+
+		if len(startDeadline) > 0 {
+			if note, delay := lateStartNote(wrapperStart); len(note) > 0 {
+				log(note)
+				runPingNotes = append(runPingNotes, note)
+				runPingMetrics = map[string]int{"start_delay": int(delay.Seconds())}
+			}
+		}
+
 		var subcommand string
-		if len(commandParts) == 1 {
+		var checksumTarget, checksum string
+		if reportGit {
+			if dir, err := os.Getwd(); err == nil {
+				gitRevision = readGitRevision(dir)
+			}
+		}
+
+		if reportCwd {
+			runCwd = resolvedWorkingDirectory()
+		}
+
+		if skipIfNoStdin {
+			if hasInput, input := peekStdin(); hasInput {
+				peekedStdin = input
+			} else {
+				log("Skipping command: --skip-if-no-stdin is set and there is no input on stdin")
+				exit(exitSuccess)
+			}
+		}
+
+		for _, file := range jobEnvFiles {
+			env, err := readJobEnvFile(file)
+			if err != nil {
+				failBeforeRun(fmt.Sprintf("Cannot read job env file: %s", err.Error()), exitNoInput)
+			}
+			jobEnv = append(jobEnv, env...)
+		}
+
+		if len(commandFile) > 0 {
+			script, err := ioutil.ReadFile(commandFile)
+			if err != nil {
+				failBeforeRun(fmt.Sprintf("Cannot read command file: %s", err.Error()), exitNoInput)
+			}
+			subcommand = string(script)
+			checksumTarget, checksum = commandFile, fmt.Sprintf("%x", sha256.Sum256(script))
+			runPingMessage = fmt.Sprintf("%s (sha256:%s)", commandFile, checksum[:12])
+		} else if scriptStdin {
+			script, err := ioutil.ReadAll(os.Stdin)
+			if err == nil && len(strings.TrimSpace(string(script))) == 0 {
+				err = errors.New("stdin is empty")
+			}
+			if err != nil {
+				failBeforeRun(fmt.Sprintf("Cannot read script from stdin: %s", err.Error()), exitNoInput)
+			}
+			subcommand = string(script)
+			checksumTarget, checksum = "script from stdin", fmt.Sprintf("%x", sha256.Sum256(script))
+			runPingMessage = fmt.Sprintf("script from stdin (sha256:%s)", checksum[:12])
+		} else if len(commandParts) == 1 {
 			subcommand = commandParts[0]
 		} else {
 			subcommand = shellquote.Join(commandParts...)
 		}
-		os.Exit(RunCommand(subcommand, true, true))
+
+		if reportArgs && len(commandParts) > 0 {
+			runArgs = redactCommandLine(commandArguments(commandParts))
+		}
+
+		if checksumCommand || len(expectedChecksum) > 0 {
+			var err error
+			if len(checksum) == 0 {
+				checksumTarget, checksum, err = commandChecksum(subcommand)
+			}
+
+			var failure string
+			exitCode := exitFailure
+			if err != nil && len(expectedChecksum) > 0 {
+				failure = fmt.Sprintf("Cannot verify the command checksum: %s", err.Error())
+				exitCode = exitNoInput
+			} else if err != nil {
+				note := fmt.Sprintf("Command checksum unavailable: %s", err.Error())
+				log(note)
+				runPingNotes = append(runPingNotes, note)
+			} else if len(expectedChecksum) > 0 && checksum != expectedChecksum {
+				failure = fmt.Sprintf("Command checksum mismatch: %s has sha256:%s, expected sha256:%s", checksumTarget, checksum, expectedChecksum)
+			} else {
+				note := fmt.Sprintf("Command checksum: sha256:%s %s", checksum, checksumTarget)
+				log(note)
+				runPingNotes = append(runPingNotes, note)
+			}
+
+			if len(failure) > 0 {
+				failBeforeRun(failure, exitCode)
+			}
+		}
+
+		if maxConcurrentExec > 0 {
+			slot, waited, err := acquireExecSlot(execSlotDirectory(), maxConcurrentExec, maxConcurrentWait)
+			if err != nil {
+				color.New(color.FgHiYellow).Fprintln(os.Stderr, fmt.Sprintf("WARNING: --max-concurrent-exec is not enforced: %s", err.Error()))
+				log(fmt.Sprintf("Cannot use --max-concurrent-exec slots: %s", err.Error()))
+			} else if slot == nil {
+				var wg sync.WaitGroup
+				message := fmt.Sprintf("Skipped: the limit of %d commands running at a time on this host was reached (--max-concurrent-exec)", maxConcurrentExec)
+				log(message)
+				wg.Add(1)
+				sendPing("tick", monitorCode, message, "", makeStamp(), nil, nil, nil, &wg)
+				fatal(message, exitTempFail)
+			} else {
+				if waited >= time.Second {
+					note := fmt.Sprintf("Delayed %s by --max-concurrent-exec", waited.Round(time.Second))
+					log(note)
+					runPingNotes = append(runPingNotes, note)
+				}
+				code := RunCommand(subcommand, true, true)
+				slot.release()
+				exit(code)
+			}
+		}
+
+		exit(RunCommand(subcommand, true, true))
 	},
 }
 
+// failBeforeRun reports an error that stops exec before the command is run: it is logged, sent as a fail ping and
+// written to stderr, then exec exits with code
+func failBeforeRun(message string, code int) {
+	var wg sync.WaitGroup
+	log(message)
+	wg.Add(1)
+	sendPing("fail", monitorCode, message, "", makeStamp(), nil, nil, nil, &wg)
+	fatal(message, code)
+}
+
 func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) int {
 	var monitoringWaitGroup sync.WaitGroup
+	var pingErrorMutex sync.Mutex
+	pingFailed := false
+	recordPingError := func(err error) {
+		if err != nil {
+			pingErrorMutex.Lock()
+			pingFailed = true
+			pingErrorMutex.Unlock()
+		}
+	}
 
 	startTime := makeStamp()
 	series := formatStamp(startTime)
+	if withMonitoring && len(otelEndpoint) > 0 {
+		span := newExecSpan()
+		otelSpan = &span
+	}
+	if sharedSeries := viper.GetString(varSeries); len(sharedSeries) > 0 {
+		series = sharedSeries
+	}
 
-	if withMonitoring {
+	// With --ping-on-change the run ping is skipped, so a run is only reported when its outcome differs from the last
+	// reported run or --heartbeat-interval has passed
+	if withMonitoring && !pingOnChange {
 		monitoringWaitGroup.Add(1)
-		go sendPing("run", monitorCode, subcommand, series, startTime, nil, nil, nil, &monitoringWaitGroup)
+		go func() {
+			message := subcommand
+			if len(runPingMessage) > 0 {
+				message = runPingMessage
+			}
+			if len(runPingNotes) > 0 {
+				message = strings.Join(runPingNotes, "\n") + "\n" + message
+			}
+			recordPingError(sendPing("run", monitorCode, message, series, startTime, nil, nil, runPingMetrics, &monitoringWaitGroup))
+		}()
+	}
+
+	stopProgressPings := func() {}
+	if withMonitoring && progressInterval > 0 {
+		stopProgressPings = startProgressPings(series, startTime, &monitoringWaitGroup)
 	}
 
 	log(fmt.Sprintf("Running subcommand: %s", subcommand))
 
 	execCmd := makeSubcommandExec(subcommand)
-	if withEnvironment {
+	if cleanEnv {
+		execCmd.Env = makeAllowlistedEnv(envPassthrough)
+	} else if withEnvironment {
 		execCmd.Env = os.Environ()
 	} else {
 		execCmd.Env = makeCronLikeEnv()
 	}
+	execCmd.Env = append(execCmd.Env, jobEnv...)
 	execCmd.Env = append(execCmd.Env, "CRONITOR_EXEC=1")
+	if otelSpan != nil {
+		execCmd.Env = append(execCmd.Env, "TRACEPARENT="+otelSpan.Traceparent())
+	}
 
 	// Handle stdin to the subcommand
-	execCmdStdin, _ := execCmd.StdinPipe()
-	defer execCmdStdin.Close()
-	if stdinStat, err := os.Stdin.Stat(); err == nil && stdinStat.Size() > 0 {
-		execStdIn, _ := ioutil.ReadAll(os.Stdin)
-		execCmdStdin.Write(execStdIn)
+	if peekedStdin != nil {
+		// The byte read by --skip-if-no-stdin to check for input is passed on ahead of the rest of stdin
+		execCmd.Stdin = peekedStdin
+	} else if scriptStdin {
+		// stdin was the script itself, so the command reads an empty stdin
+		execCmd.Stdin = bytes.NewReader(nil)
+	} else if noStdin {
+		// A nil Stdin is connected to the null device
+		execCmd.Stdin = nil
+	} else {
+		// The command reads the same stdin as exec, whether it is a pipe, a file or a terminal
+		execCmd.Stdin = os.Stdin
 	}
 
 	// Proxy and copy the command's stdout if the filesystem is available
 	tempFile, err := getTempFile()
 	if err == nil {
 		defer tempFile.Close()
-		execCmd.Stdout = io.MultiWriter(os.Stdout, tempFile)
 	} else {
 		log(err.Error())
+	}
+
+	// The captured stream is copied from a pipe owned by exec rather than by exec.Cmd, so that waiting for a command
+	// that was killed does not also wait for processes it started that still hold the pipe open
+	var capturedOutput *outputPipe
+	var capturedStream io.Writer
+	switch captureStream {
+	case "stdout":
+		capturedStream = captureWriter(os.Stdout, tempFile)
+		execCmd.Stdout = capturedStream
+		execCmd.Stderr = os.Stderr
+	case "stderr":
+		capturedStream = captureWriter(os.Stderr, tempFile)
 		execCmd.Stdout = os.Stdout
+		execCmd.Stderr = capturedStream
+	default:
+		// Combine stdout and stderr from the command into a single buffer which we'll stream as stdout
+		// Alternatively we could pass stderr from the subcommand but I've chosen to only use it for CronitorCLI errors at the moment
+		capturedStream = captureWriter(os.Stdout, tempFile)
+		execCmd.Stdout = capturedStream
+		execCmd.Stderr = capturedStream
+	}
+
+	// The full captured stream is also written to --capture-file
+	if len(captureFile) > 0 {
+		path := expandCaptureFilePath(captureFile, monitorCode, time.Now())
+		if writer, err := openCaptureFile(path, captureFileMaxSize); err == nil {
+			defer writer.Close()
+			withFile := io.MultiWriter(capturedStream, writer)
+			if execCmd.Stdout == capturedStream {
+				execCmd.Stdout = withFile
+			}
+			if execCmd.Stderr == capturedStream {
+				execCmd.Stderr = withFile
+			}
+			capturedStream = withFile
+		} else {
+			log(fmt.Sprintf("Cannot write output to capture file: %s", err.Error()))
+		}
+	}
+
+	// --fail-on-stderr watches stderr through a pipe of its own. When stdout and stderr are captured together, both pipes
+	// write to the captured stream, so writes to it are serialized.
+	var stderrWatch *stderrWatcher
+	var stderrOutput *outputPipe
+	if failOnStderr || failOnStderrRegex != nil {
+		if execCmd.Stderr == capturedStream && (tempFile != nil || len(captureFile) > 0) {
+			capturedStream = &syncWriter{writer: capturedStream}
+			execCmd.Stdout = capturedStream
+			execCmd.Stderr = capturedStream
+		}
+
+		stderrWatch = &stderrWatcher{output: execCmd.Stderr, pattern: failOnStderrRegex}
+		if pipe, err := newOutputPipe(stderrWatch); err == nil {
+			stderrOutput = pipe
+			execCmd.Stderr = pipe.writer
+		} else {
+			log(fmt.Sprintf("Cannot create stderr pipe, --fail-on-stderr is not enforced: %s", err.Error()))
+			stderrWatch = nil
+		}
+	}
+
+	if tempFile != nil || len(captureFile) > 0 {
+		if pipe, err := newOutputPipe(capturedStream); err == nil {
+			capturedOutput = pipe
+			if execCmd.Stdout == capturedStream {
+				execCmd.Stdout = pipe.writer
+			}
+			if execCmd.Stderr == capturedStream {
+				execCmd.Stderr = pipe.writer
+			}
+		} else {
+			log(fmt.Sprintf("Cannot create output pipe: %s", err.Error()))
+		}
 	}
 
-	// Combine stdout and stderr from the command into a single buffer which we'll stream as stdout
-	// Alternatively we could pass stderr from the subcommand but I've chosen to only use it for CronitorCLI errors at the moment
-	execCmd.Stderr = execCmd.Stdout
+	var terminationRelayed int32
 
 	// Invoke subcommand and send a message when it's done
 	waitCh := make(chan error, 16)
@@ -156,10 +709,47 @@ func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) in
 		// Brief pause to allow gochannel selects
 		time.Sleep(20 * time.Millisecond)
 
-		if err := execCmd.Start(); err != nil {
+		err := execCmd.Start()
+		if capturedOutput != nil {
+			capturedOutput.writer.Close()
+		}
+		if stderrOutput != nil {
+			stderrOutput.writer.Close()
+		}
+
+		if err != nil {
+			if capturedOutput != nil {
+				capturedOutput.reader.Close()
+			}
+			if stderrOutput != nil {
+				stderrOutput.reader.Close()
+			}
 			waitCh <- err
 		} else {
-			waitCh <- execCmd.Wait()
+			if len(pidFile) > 0 {
+				writePidFile(pidFile, execCmd.Process.Pid)
+			}
+			err := execCmd.Wait()
+			if len(pidFile) > 0 {
+				removePidFile(pidFile, execCmd.Process.Pid)
+			}
+			killed := wasKilled(err) || atomic.LoadInt32(&terminationRelayed) == 1
+			// stderr is written to the captured stream, so it is finished first
+			if stderrOutput != nil {
+				if killed {
+					stderrOutput.drain(outputDrainTimeout)
+				} else {
+					stderrOutput.wait()
+				}
+			}
+			if capturedOutput != nil {
+				if killed {
+					capturedOutput.drain(outputDrainTimeout)
+				} else {
+					capturedOutput.wait()
+				}
+			}
+			waitCh <- err
 		}
 	}()
 
@@ -170,15 +760,22 @@ func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) in
 	for {
 		select {
 		case sig := <-sigChan:
+			if isTerminationSignal(sig) {
+				atomic.StoreInt32(&terminationRelayed, 1)
+			}
 			if execCmd.Process != nil {
 				if err := execCmd.Process.Signal(sig); err != nil {
 					// Ignoring because the only time I've seen an err is when child process has already exited after kill was sent to pgroup
 				}
 			}
 		case err := <-waitCh:
+			stopProgressPings()
 
 			// Send output to Cronitor and clean up after the temp file
 			outputForPing := gatherOutput(tempFile, true)
+			if len(messageFilter) > 0 && len(outputForPing) > 0 {
+				outputForPing = filterMessage(messageFilter, outputForPing, messageFilterTimeout)
+			}
 			var metrics map[string]int = nil
 			if tempFile != nil {
 				logLengthForPing, err2 := getFileSize(tempFile)
@@ -189,6 +786,25 @@ func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) in
 				}
 			}
 
+			if resultJsonLine {
+				if tempFile == nil {
+					log("Cannot read --result-json-line: output is not being captured")
+				} else if result, message, err := parseJsonResult(readLastLine(tempFile, resultLineMaxBytes)); err != nil {
+					log(fmt.Sprintf("Ignoring --result-json-line: %s", err.Error()))
+					color.New(color.FgHiYellow).Fprintln(os.Stderr, fmt.Sprintf("WARNING: Ignoring --result-json-line: %s", err.Error()))
+				} else {
+					if metrics == nil {
+						metrics = map[string]int{}
+					}
+					for name, value := range result {
+						metrics[name] = value
+					}
+					if len(message) > 0 {
+						execMessage = strings.TrimSpace(execMessage + " " + message)
+					}
+				}
+			}
+
 			defer func() {
 				if tempFile != nil {
 					tempFile.Close()
@@ -199,37 +815,154 @@ func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) in
 			endTime := makeStamp()
 			duration := endTime - startTime
 			exitCode := 0
+			if err != nil {
+				exitCode = commandExitCode(err)
+			}
+			_, exited := err.(*exec.ExitError)
+			warned := exited && isWarnExitCode(exitCode)
+
+			// The temp file only receives the stream selected with --capture
+			emptyOutput := false
+			if err == nil && failIfEmptyOutput {
+				if metrics == nil {
+					log("Cannot check for empty output: output is not being captured")
+				} else {
+					emptyOutput = metrics["length"] == 0
+				}
+			}
+
+			// Anything on stderr fails a run that would otherwise complete, or complete with a warning
+			stderrFailure, stderrDetail := "", ""
+			if stderrWatch != nil && (err == nil || warned) {
+				stderrFailure, stderrDetail = stderrWatch.failure()
+			}
+
+			outcome := "complete"
+			if len(stderrFailure) > 0 {
+				outcome = "fail"
+			} else if warned {
+				outcome = "warn"
+			} else if err != nil || emptyOutput {
+				outcome = "fail"
+			}
 
-			if err == nil {
-				if withMonitoring {
+			reportOutcome := withMonitoring
+			if withMonitoring && pingOnChange {
+				reportOutcome = outcomeNeedsPing(monitorCode, outcome, time.Now())
+			}
+
+			failPingUndelivered := false
+			failMessage := ""
+			sendFailPing := func(message string) {
+				if err := sendPing("fail", monitorCode, message, series, endTime, &duration, &exitCode, metrics, &monitoringWaitGroup); err != nil {
+					recordPingError(err)
+					pingErrorMutex.Lock()
+					failPingUndelivered = true
+					pingErrorMutex.Unlock()
+				}
+			}
+
+			if warned && len(stderrFailure) == 0 {
+				message := joinMessage(strings.TrimSpace(fmt.Sprintf("[%s, warning] %s", err.Error(), execMessage)), string(outputForPing), messageBodyLength(effectiveMaxMessageBytes()))
+				log(message)
+				if metrics == nil {
+					metrics = map[string]int{}
+				}
+				metrics["warning_count"] = 1
+
+				if reportOutcome {
 					monitoringWaitGroup.Add(1)
-					go sendPing("complete", monitorCode, string(outputForPing), series, endTime, &duration, &exitCode, metrics, &monitoringWaitGroup)
+					go func() {
+						recordPingError(sendPing("complete", monitorCode, message, series, endTime, &duration, &exitCode, metrics, &monitoringWaitGroup))
+					}()
 					monitoringWaitGroup.Add(1)
 					go shipLogData(tempFile, series, &monitoringWaitGroup)
 				}
-			} else {
-				message := strings.TrimSpace(fmt.Sprintf("[%s] %s", err.Error(), outputForPing))
+			} else if len(stderrFailure) > 0 {
+				message := joinMessage(strings.TrimSpace(stderrFailure+" "+execMessage), stderrDetail, messageBodyLength(effectiveMaxMessageBytes()))
+				failMessage = message
+				log(message)
+				if failOnStderrExit > 0 {
+					exitCode = failOnStderrExit
+				}
 
-				// This works on both Posix and Windows (syscall.WaitStatus is cross platform).
-				// Cribbed from aws-vault.
-				if exiterr, ok := err.(*exec.ExitError); ok {
+				if reportOutcome {
+					monitoringWaitGroup.Add(1)
+					go sendFailPing(message)
+					monitoringWaitGroup.Add(1)
+					go shipLogData(tempFile, series, &monitoringWaitGroup)
+				}
+			} else if err == nil && !emptyOutput {
+				if reportOutcome {
+					monitoringWaitGroup.Add(1)
+					go func() {
+						recordPingError(sendPing("complete", monitorCode, joinMessage(execMessage, string(outputForPing), messageBodyLength(effectiveMaxMessageBytes())), series, endTime, &duration, &exitCode, metrics, &monitoringWaitGroup))
+					}()
+					monitoringWaitGroup.Add(1)
+					go shipLogData(tempFile, series, &monitoringWaitGroup)
+				}
+			} else if err == nil {
+				message := joinMessage(execMessage, emptyOutputMessage(), messageBodyLength(effectiveMaxMessageBytes()))
+				failMessage = message
+				log(message)
+				if failIfEmptyOutputExit > 0 {
+					exitCode = failIfEmptyOutputExit
+				}
 
-					if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-						exitCode = status.ExitStatus()
-					} else {
-						exitCode = 1
-					}
+				if reportOutcome {
+					monitoringWaitGroup.Add(1)
+					go sendFailPing(message)
+				}
+			} else {
+				message := strings.TrimSpace(fmt.Sprintf("[%s] %s", err.Error(), outputForPing))
+				if len(execMessage) > 0 {
+					message = joinMessage(fmt.Sprintf("[%s] %s", err.Error(), execMessage), string(outputForPing), messageBodyLength(effectiveMaxMessageBytes()))
 				}
+				failMessage = message
 
-				if withMonitoring {
+				if reportOutcome {
 					monitoringWaitGroup.Add(1)
-					go sendPing("fail", monitorCode, message, series, endTime, &duration, &exitCode, metrics, &monitoringWaitGroup)
+					go sendFailPing(message)
 					monitoringWaitGroup.Add(1)
 					go shipLogData(tempFile, series, &monitoringWaitGroup)
 				}
 			}
 
 			monitoringWaitGroup.Wait()
+
+			if otelSpan != nil {
+				body := otlpTraceRequest(*otelSpan, monitorCode, stampTime(startTime), stampTime(endTime), exitCode, outcome == "fail")
+				if err := exportSpan(otelEndpoint, body); err != nil {
+					log(fmt.Sprintf("Cannot export span to %s: %s", otelEndpoint, err.Error()))
+				}
+			}
+
+			if failPingUndelivered && len(notifyLocalHook) > 0 {
+				log("The fail ping could not be delivered, running the --notify-local hook")
+				runHook(notifyLocalHook, exitCode, "CRONITOR_MESSAGE="+failMessage)
+			}
+
+			if reportOutcome && pingOnChange && !pingFailed {
+				saveOutcomeState(monitorCode, outcome, time.Now())
+			}
+
+			hook := onSuccessHook
+			if exitCode != 0 || err != nil || emptyOutput || len(stderrFailure) > 0 {
+				hook = onFailureHook
+			}
+
+			if len(hook) > 0 {
+				if hookErr := runHook(hook, exitCode); hookErr != nil && strictHooks && exitCode == 0 {
+					log("Exiting with code 1: the hook failed and --strict-hooks is set")
+					return exitFailure
+				}
+			}
+
+			if failOnPingError && pingFailed && exitCode == 0 {
+				log(fmt.Sprintf("Exiting with code %d: a ping could not be delivered", exitUnavailable))
+				return exitUnavailable
+			}
+
 			return exitCode
 		}
 	}
@@ -239,18 +972,1258 @@ func RunCommand(subcommand string, withEnvironment bool, withMonitoring bool) in
 func init() {
 	RootCmd.AddCommand(execCmd)
 	execCmd.Flags().BoolVar(&noStdoutPassthru, "no-stdout", noStdoutPassthru, "Do not send cron job output to Cronitor when your job completes")
+	execCmd.Flags().StringVar(&onSuccessHook, "on-success", onSuccessHook, "Command to run after the job succeeds and the complete ping is sent")
+	execCmd.Flags().StringVar(&onFailureHook, "on-failure", onFailureHook, "Command to run after the job fails and the fail ping is sent")
+	execCmd.Flags().StringVar(&notifyLocalHook, "notify-local", notifyLocalHook, "Command to run when the fail ping cannot be delivered, e.g. to notify someone on this host")
+	execCmd.Flags().BoolVar(&strictHooks, "strict-hooks", strictHooks, "Exit with code 1 if an --on-success hook fails")
+	execCmd.Flags().BoolVar(&noStdin, "no-stdin", noStdin, "Run the command with stdin connected to /dev/null instead of the stdin of exec")
+	execCmd.Flags().BoolVar(&skipIfNoStdin, "skip-if-no-stdin", skipIfNoStdin, "Do not run the command or send any pings when there is no input on stdin")
+	execCmd.Flags().StringVar(&captureStream, "capture", "both", "Output stream to send with the complete or fail ping: both, stdout or stderr")
+	execCmd.Flags().StringVar(&captureFile, "capture-file", captureFile, "Also write the full captured output to this file. %code and %timestamp are replaced with the monitor key and start time")
+	execCmd.Flags().Int64Var(&captureFileMaxSize, "capture-file-max-size", captureFileMaxSize, "Maximum size of the --capture-file in bytes. A full file is moved to <file>.1 and a new one is started")
+	execCmd.Flags().BoolVar(&resultJsonLine, "result-json-line", resultJsonLine, "Read metrics from a JSON object on the last line of the captured output")
+	execCmd.Flags().StringArrayVar(&resultMetrics, "result-metric", resultMetrics, "With --result-json-line, send a numeric field as a metric, e.g. processed=count. Can be repeated. Default: count=count and error_count=error_count")
+	execCmd.Flags().StringVar(&resultMessageField, "result-message", resultMessageField, "With --result-json-line, send this string field with the complete or fail ping, after --message")
+	execCmd.Flags().StringVar(&startDeadline, "start-deadline", startDeadline, "Report a late start in the run ping when exec starts after this time of day, e.g. 02:15, or this long after the scheduled time, e.g. 10m")
+	execCmd.Flags().StringVar(&cronSchedule, "schedule", cronSchedule, "Cron expression the job is scheduled with, used by --start-deadline. Read from the monitor when not set")
+	execCmd.Flags().IntVar(&maxConcurrentExec, "max-concurrent-exec", maxConcurrentExec, "Run at most this many commands at a time across every cronitor exec on this host that uses the same config directory")
+	execCmd.Flags().DurationVar(&maxConcurrentWait, "max-concurrent-wait", maxConcurrentWait, "How long to wait for a --max-concurrent-exec slot before skipping the command, e.g. 10m. By default the command is skipped immediately")
+	execCmd.Flags().BoolVar(&cleanEnv, "clean-env", cleanEnv, "Run the command with an empty environment, plus any variables named in --env-passthrough")
+	execCmd.Flags().StringSliceVar(&envPassthrough, "env-passthrough", envPassthrough, "Comma-separated names of environment variables to pass to the command when --clean-env is used")
+	execCmd.Flags().StringArrayVar(&jobEnvFiles, "job-env-file", jobEnvFiles, "Set the KEY=VALUE variables in this file in the environment of the command. Can be repeated, later files take precedence")
+	execCmd.Flags().BoolVar(&failOnStderr, "fail-on-stderr", failOnStderr, "Send a fail ping when the command writes anything to stderr, even if it exits 0")
+	execCmd.Flags().StringVar(&failOnStderrPattern, "fail-on-stderr-pattern", failOnStderrPattern, "Like --fail-on-stderr, but only fail when a line written to stderr matches this regular expression, e.g. 'ERROR|FATAL'")
+	execCmd.Flags().IntVar(&failOnStderrExit, "fail-on-stderr-exit", failOnStderrExit, "Exit with this code when --fail-on-stderr or --fail-on-stderr-pattern fails the job, instead of the command's exit code")
+	execCmd.Flags().StringVar(&execMessage, "message", execMessage, "Text to send before the captured output with the complete or fail ping")
+	execCmd.Flags().BoolVar(&failIfEmptyOutput, "fail-if-empty-output", failIfEmptyOutput, "Send a fail ping when the command exits 0 without writing to the captured stream")
+	execCmd.Flags().IntVar(&failIfEmptyOutputExit, "fail-if-empty-output-exit", failIfEmptyOutputExit, "Exit with this code when --fail-if-empty-output fails the job, instead of the command's exit code")
+	execCmd.Flags().StringVar(&logSample, "log-sample", logSample, "Upload only 1 of every N output lines to Cronitor logs, e.g. 1/10. The last 100 lines are always uploaded")
+	execCmd.Flags().IntVar(&logMaxLines, "log-max-lines", logMaxLines, "Upload at most this many of the most recent output lines to Cronitor logs")
+	execCmd.Flags().StringVar(&onMissingMonitor, "on-missing-monitor", onMissingMonitor, "Check that the monitor exists before running the command, and 'create' it or 'fail' when it does not")
+	execCmd.Flags().BoolVar(&pingOnChange, "ping-on-change", pingOnChange, "Only send a complete or fail ping when the outcome differs from the last reported run, or --heartbeat-interval has passed")
+	execCmd.Flags().DurationVar(&progressInterval, "progress-interval", progressInterval, "Send a run ping with the elapsed time every interval while the command runs, e.g. 15m")
+	execCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", time.Hour, "With --ping-on-change, report an unchanged outcome again after this long")
+	execCmd.Flags().StringVar(&dumpPingFile, "dump-ping", dumpPingFile, "Append a JSON record of every ping sent by this run to this file")
+	execCmd.Flags().BoolVar(&checksumCommand, "checksum-command", checksumCommand, "Send the SHA-256 hash of the program or script the command runs with the run ping")
+	execCmd.Flags().StringVar(&expectedChecksum, "expected-checksum", expectedChecksum, "Send a fail ping instead of running the command when the SHA-256 hash of its program or script is different")
+	execCmd.Flags().BoolVar(&codeFromScript, "code-from-script", codeFromScript, "Read the monitor code from a \"# cronitor: <code>\" comment in the first lines of the script instead of the command line")
+	execCmd.Flags().BoolVar(&detach, "detach", detach, "Run the command and send its pings from a background process, and exit 0 immediately")
+	execCmd.Flags().StringVar(&pidFile, "pidfile", pidFile, "Write the PID of the command to this file while it runs")
+	execCmd.Flags().BoolVar(&reportGit, "report-git", reportGit, "Append the git commit checked out in the working directory to ping messages")
+	execCmd.Flags().BoolVar(&reportCwd, "report-cwd", reportCwd, "Append the working directory of the command, with symlinks resolved, to ping messages")
+	execCmd.Flags().StringVar(&messageFilter, "message-filter", messageFilter, "Pipe the captured output through this command and send what it prints instead")
+	execCmd.Flags().DurationVar(&messageFilterTimeout, "message-filter-timeout", 10*time.Second, "Send the unfiltered output when --message-filter takes longer than this")
+	execCmd.Flags().BoolVar(&reportArgs, "report-args", reportArgs, "Append the arguments of the command, with secrets redacted, to ping messages")
+	execCmd.Flags().Int64Var(&maxOutputRate, "max-output-rate", maxOutputRate, "Stop capturing output for Cronitor when the command writes more than this many bytes in one second")
+	execCmd.Flags().Int64Var(&maxOutputTotal, "max-output-total", maxOutputTotal, "Stop capturing output for Cronitor after this many bytes")
+	execCmd.Flags().BoolVar(&capPassthrough, "cap-passthrough", capPassthrough, "Also stop passing output through to stdout or stderr when a --max-output-rate or --max-output-total limit is reached")
+	execCmd.Flags().StringVar(&otelEndpoint, "otel-endpoint", otelEndpoint, "Export a span for the run to this OpenTelemetry OTLP/HTTP collector, e.g. http://localhost:4318")
+	execCmd.Flags().IntSliceVar(&warnExitCodes, "warn-exit-codes", warnExitCodes, "Comma-separated nonzero exit codes to report as a complete ping with a warning instead of a fail ping")
+	execCmd.Flags().StringVar(&commandFile, "command-file", commandFile, "Read the command to run from this file instead of the command line")
+	execCmd.Flags().BoolVar(&scriptStdin, "script-stdin", scriptStdin, "Read the script to run from stdin instead of the command line. The same as a command of -")
+	execCmd.Flags().BoolVar(&failOnPingError, "fail-on-ping-error", failOnPingError, "Exit with code 69 if a ping could not be delivered and the command was otherwise successful")
 }
 
-func makeCronLikeEnv() []string {
-	env := []string{"SHELL=/bin/sh"}
-	if homeValue, hasHome := os.LookupEnv("HOME"); hasHome {
-		env = append(env, "HOME="+homeValue)
+// firstCommandWord returns the program a command runs, the first word of the command line
+func firstCommandWord(command string) string {
+	if words, err := shellquote.Split(command); err == nil && len(words) > 0 {
+		return words[0]
 	}
+	return ""
+}
 
-	return env
+// commandPath returns the path of a program, looked up in PATH when it has no directory
+func commandPath(program string) string {
+	if !strings.ContainsRune(program, os.PathSeparator) {
+		if found, err := exec.LookPath(program); err == nil {
+			return found
+		}
+	}
+	return program
 }
 
-func makeSubcommandExec(subcommand string) *exec.Cmd {
+var sha256HexRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var interpreterRegex = regexp.MustCompile(`^(sh|bash|dash|zsh|ksh|python|pypy|ruby|perl|php|node|nodejs|Rscript|lua)[0-9.]*$`)
+var shellInterpreterRegex = regexp.MustCompile(`^(sh|bash|dash|zsh|ksh)$`)
+
+// commandScript returns the file a command runs: the script when the program is an interpreter like python3 or bash,
+// otherwise the program itself. An interpreter is found after /usr/bin/env and its VAR=value arguments.
+func commandScript(command string) (string, error) {
+	words, err := shellquote.Split(command)
+	if err != nil || len(words) == 0 {
+		return "", errors.New("the command could not be parsed")
+	}
+
+	if filepath.Base(words[0]) == "env" {
+		i := 1
+		for i < len(words) && (strings.HasPrefix(words[i], "-") || strings.Contains(words[i], "=")) {
+			i++
+		}
+		if i == len(words) {
+			return "", errors.New("env does not run a program")
+		}
+		words = words[i:]
+	}
+
+	interpreter := filepath.Base(words[0])
+	if !interpreterRegex.MatchString(interpreter) {
+		return words[0], nil
+	}
+
+	// The script is the first argument that is a file. Flags and their values are skipped, but inline code like
+	// bash -c or a module like python3 -m has no script to hash.
+	for _, arg := range words[1:] {
+		if isInlineCodeFlag(interpreter, arg) {
+			return "", fmt.Errorf("%s runs inline code or a module, not a script file", words[0])
+		}
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if stat, err := os.Stat(arg); err == nil && stat.Mode().IsRegular() {
+			return arg, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s does not run a script file", words[0])
+}
+
+// isInlineCodeFlag reports whether arg makes interpreter run code from the command line instead of a script, e.g. the
+// -c of bash -lc or the -m of python3 -m
+func isInlineCodeFlag(interpreter string, arg string) bool {
+	if shellInterpreterRegex.MatchString(interpreter) {
+		return len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg, 'c')
+	}
+
+	switch arg {
+	case "-c", "-m", "-e", "-E", "-r", "-p", "--eval", "--print":
+		return true
+	}
+	return false
+}
+
+// commandChecksum returns the path and SHA-256 hash of the file a command runs, see commandScript. An error is
+// returned when it is not a file, e.g. a shell builtin, or cannot be read.
+func commandChecksum(command string) (string, string, error) {
+	program, err := commandScript(command)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := commandPath(program)
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return "", "", fmt.Errorf("%s is not a file, it may be a shell builtin", program)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", err
+	}
+
+	return path, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+var scriptMonitorCodeRegex = regexp.MustCompile(`^\s*#\s*cronitor:\s*(\S{1,128})\s*$`)
+
+// scriptMarkerLines is how many lines at the start of a script are searched by --code-from-script
+const scriptMarkerLines = 20
+
+// readScriptMonitorCode returns the monitor code of a "# cronitor: <code>" comment in the first lines of a script
+func readScriptMonitorCode(path string) (string, error) {
+	path = commandPath(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("--code-from-script cannot read the script: %s", err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 0; i < scriptMarkerLines && scanner.Scan(); i++ {
+		if matches := scriptMonitorCodeRegex.FindStringSubmatch(scanner.Text()); matches != nil {
+			return matches[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("--code-from-script found no \"# cronitor: <code>\" comment in the first %d lines of %s", scriptMarkerLines, path)
+}
+
+// startDetached starts this exec again in the background with the same arguments and returns its PID. It is not
+// waited for, and it outlives this process.
+func startDetached() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer devNull.Close()
+
+	detached := exec.Command(executable, os.Args[1:]...)
+	detached.Env = append(os.Environ(), detachedEnvVar+"=1")
+	detached.Stdin = devNull
+	detached.Stdout = devNull
+	detached.Stderr = devNull
+	detached.SysProcAttr = detachedProcAttr()
+	if err := detached.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := detached.Process.Pid
+	detached.Process.Release()
+	return pid, nil
+}
+
+// writePidFile writes pid to path, replacing a file left behind by a run that is no longer running. The file is
+// written to a temp file first and renamed so readers never see a partial PID.
+func writePidFile(path string, pid int) {
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		if previous, err := strconv.Atoi(strings.TrimSpace(string(contents))); err == nil && processExists(previous) {
+			log(fmt.Sprintf("PID file %s belongs to process %d which is still running, replacing it", path, previous))
+		} else {
+			log(fmt.Sprintf("Replacing stale PID file %s", path))
+		}
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), ".cronitor-pid-*")
+	if err == nil {
+		_, err = tempFile.WriteString(fmt.Sprintf("%d\n", pid))
+		tempFile.Close()
+		if err == nil {
+			os.Chmod(tempFile.Name(), 0644)
+			err = os.Rename(tempFile.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tempFile.Name())
+		}
+	}
+
+	if err != nil {
+		log(fmt.Sprintf("Cannot write PID file %s: %s", path, err.Error()))
+	}
+}
+
+// removePidFile removes path if it still contains pid, so a newer run's file is left alone
+func removePidFile(path string, pid int) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	if strings.TrimSpace(string(contents)) == strconv.Itoa(pid) {
+		os.Remove(path)
+	}
+}
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// On Windows, FindProcess fails when there is no such process
+	if runtime.GOOS == "windows" {
+		return true
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// resultLineMaxBytes is the longest last line read by --result-json-line
+const resultLineMaxBytes = 65536
+
+// parseResultMetrics parses --result-metric field=metric pairs into a map from JSON field to metric name
+func parseResultMetrics(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return map[string]string{"count": "count", "error_count": "error_count"}, nil
+	}
+
+	mapping := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid --result-metric '%s', expected field=metric", value)
+		}
+		if strings.TrimSpace(parts[1]) == "length" {
+			return nil, fmt.Errorf("invalid --result-metric '%s', the length metric is set by CronitorCLI", value)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return mapping, nil
+}
+
+// parseJsonResult reads the metrics and message configured with --result-metric and --result-message from a JSON
+// object. Fields that are missing or have the wrong type are skipped.
+func parseJsonResult(line string) (map[string]int, string, error) {
+	result := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &result); err != nil {
+		return nil, "", fmt.Errorf("the last line of output is not a JSON object: %s", err.Error())
+	}
+
+	mapping, _ := parseResultMetrics(resultMetrics)
+	metrics := map[string]int{}
+	for field, metric := range mapping {
+		if value, ok := result[field].(float64); ok {
+			metrics[metric] = int(value)
+		} else if _, exists := result[field]; exists {
+			log(fmt.Sprintf("Skipping --result-json-line field %s: it is not a number", field))
+		}
+	}
+
+	message := ""
+	if len(resultMessageField) > 0 {
+		if value, ok := result[resultMessageField].(string); ok {
+			message = value
+		}
+	}
+
+	return metrics, message, nil
+}
+
+// readLastLine returns the last non-empty line of file, reading at most max bytes from its end
+func readLastLine(file *os.File, max int64) string {
+	size, err := getFileSize(file)
+	if err != nil {
+		return ""
+	}
+
+	offset := size - max
+	if offset < 0 {
+		offset = 0
+	}
+
+	contents := make([]byte, size-offset)
+	if _, err := file.ReadAt(contents, offset); err != nil && err != io.EOF {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimRight(string(contents), "\r\n \t"), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// parseStartDeadline parses --start-deadline as a time of day, HH:MM, or as a duration after the scheduled time.
+// It returns the hour and minute of a time of day, or -1 and the duration.
+func parseStartDeadline(value string) (int, time.Duration, error) {
+	if clock, err := time.Parse("15:04", value); err == nil {
+		return clock.Hour()*60 + clock.Minute(), 0, nil
+	}
+
+	if offset, err := time.ParseDuration(value); err == nil && offset >= 0 {
+		return -1, offset, nil
+	}
+
+	return 0, 0, errors.New("invalid argument supplied to 'start-deadline'. Expecting a time of day, e.g. 02:15, or a duration after the scheduled time, e.g. 10m")
+}
+
+// lateStartNote returns a note for the run ping and how late the job started when started is after --start-deadline,
+// or an empty string. The delay is measured from the deadline for a time of day, and from the scheduled time otherwise.
+// When the scheduled time cannot be found the deadline is not checked.
+func lateStartNote(started time.Time) (string, time.Duration) {
+	minuteOfDay, offset, _ := parseStartDeadline(startDeadline)
+	if minuteOfDay >= 0 {
+		deadline := time.Date(started.Year(), started.Month(), started.Day(), minuteOfDay/60, minuteOfDay%60, 0, 0, started.Location())
+		if late := started.Sub(deadline); late > 0 {
+			return fmt.Sprintf("Late start: started %s after the %s deadline", late.Round(time.Second), deadline.Format("15:04")), late
+		}
+		return "", 0
+	}
+
+	scheduled, err := scheduledStart(started)
+	if err != nil {
+		log(fmt.Sprintf("Cannot check --start-deadline: %s", err.Error()))
+		return "", 0
+	}
+
+	if late := started.Sub(scheduled); late > offset {
+		return fmt.Sprintf("Late start: started %s after the scheduled time %s, the deadline is %s", late.Round(time.Second), scheduled.Format("15:04"), offset), late
+	}
+
+	return "", 0
+}
+
+// scheduledStart returns the last time the job was scheduled to start at or before started, using --schedule in the
+// local time zone or the schedule and timezone of the monitor
+func scheduledStart(started time.Time) (time.Time, error) {
+	expression, timezone := cronSchedule, ""
+	if len(expression) == 0 {
+		if len(viper.GetString(varApiKey)) == 0 {
+			return time.Time{}, errors.New("an API key is required to read the schedule of the monitor, or use --schedule")
+		}
+
+		var err error
+		if expression, timezone, err = getCronitorApi().GetMonitorSchedule(monitorCode); err != nil {
+			return time.Time{}, err
+		}
+
+		if len(expression) == 0 {
+			return time.Time{}, fmt.Errorf("monitor %s does not have a cron schedule, use --schedule", monitorCode)
+		}
+	}
+
+	schedule, err := lib.ParseCronSchedule(expression)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if location, err := time.LoadLocation(timezone); err == nil && len(timezone) > 0 {
+		started = started.In(location)
+	}
+
+	scheduled, found := schedule.Previous(started)
+	if !found {
+		return time.Time{}, fmt.Errorf("'%s' was not scheduled in the last year", expression)
+	}
+
+	return scheduled, nil
+}
+
+// execSlot is a --max-concurrent-exec slot held by this process
+type execSlot struct {
+	path string
+	file *os.File
+}
+
+// errExecSlotTaken is returned by lockExecSlot when another process holds the slot
+var errExecSlotTaken = errors.New("slot is taken")
+
+func execSlotDirectory() string {
+	return filepath.Join(stateDirectory(), "exec-slots")
+}
+
+// acquireExecSlot waits up to wait for a free slot. It returns a nil slot when every slot is still taken after wait.
+func acquireExecSlot(dir string, limit int, wait time.Duration) (*execSlot, time.Duration, error) {
+	start := time.Now()
+	for {
+		slot, err := tryAcquireExecSlot(dir, limit)
+		if slot != nil || err != nil {
+			return slot, time.Since(start), err
+		}
+
+		if time.Since(start) >= wait {
+			return nil, time.Since(start), nil
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// tryAcquireExecSlot claims the first free slot file, slot-1 to slot-<limit>, by taking an exclusive lock on it that is
+// held until the slot is released. The operating system drops the lock when the process holding it exits, so a slot
+// held by an exec that was killed is free again without anyone reclaiming it.
+func tryAcquireExecSlot(dir string, limit int) (*execSlot, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	for i := 1; i <= limit; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("slot-%d", i))
+		file, err := lockExecSlot(path)
+		if err == errExecSlotTaken {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// The PID is only written for whoever inspects the slot files, the lock is what holds the slot
+		file.Truncate(0)
+		file.WriteString(fmt.Sprintf("%d\n", os.Getpid()))
+		return &execSlot{path: path, file: file}, nil
+	}
+
+	return nil, nil
+}
+
+// release unlocks the slot. The file is left in place: removing it could let another exec lock the removed file while a
+// third creates and locks a new one at the same path, and both would think they hold the slot.
+func (s *execSlot) release() {
+	s.file.Truncate(0)
+	s.file.Close()
+}
+
+// OutcomeState is the last outcome reported for a monitor by exec --ping-on-change
+type OutcomeState struct {
+	Monitor    string    `json:"monitor"`
+	Outcome    string    `json:"outcome"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+var unsafeFileNameRegex = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func outcomeStateFile(code string) string {
+	return filepath.Join(stateDirectory(), "outcome-"+unsafeFileNameRegex.ReplaceAllString(code, "_")+".json")
+}
+
+// outcomeNeedsPing reports whether outcome should be pinged: on the first run, when it differs from the last reported
+// outcome, or when the last report is older than --heartbeat-interval
+func outcomeNeedsPing(code string, outcome string, now time.Time) bool {
+	contents, err := ioutil.ReadFile(outcomeStateFile(code))
+	if err != nil {
+		log(fmt.Sprintf("Sending %s ping: no outcome has been reported by --ping-on-change yet", outcome))
+		return true
+	}
+
+	var state OutcomeState
+	if err := json.Unmarshal(contents, &state); err != nil {
+		log(fmt.Sprintf("Sending %s ping: cannot read %s: %s", outcome, outcomeStateFile(code), err.Error()))
+		return true
+	}
+
+	if state.Outcome != outcome {
+		log(fmt.Sprintf("Sending %s ping: the last reported outcome was %s", outcome, state.Outcome))
+		return true
+	}
+
+	if now.Sub(state.ReportedAt) >= heartbeatInterval {
+		log(fmt.Sprintf("Sending %s ping: the outcome was last reported at %s", outcome, state.ReportedAt.Format(time.RFC3339)))
+		return true
+	}
+
+	log(fmt.Sprintf("Skipping %s ping: the outcome has not changed since %s", outcome, state.ReportedAt.Format(time.RFC3339)))
+	return false
+}
+
+// saveOutcomeState records a reported outcome. It is only called once the ping was delivered so an undelivered
+// change is reported again by the next run.
+func saveOutcomeState(code string, outcome string, now time.Time) {
+	contents, _ := json.Marshal(OutcomeState{Monitor: code, Outcome: outcome, ReportedAt: now})
+	err := os.MkdirAll(stateDirectory(), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(outcomeStateFile(code), contents, 0600)
+	}
+
+	if err != nil {
+		log(fmt.Sprintf("Cannot save the reported outcome, the next run will ping: %s", err.Error()))
+	}
+}
+
+// ensureMonitorExists implements --on-missing-monitor. It exits when the monitor is missing and cannot be created.
+func ensureMonitorExists() {
+	api := getCronitorApi()
+	exists, err := api.MonitorExists(monitorCode)
+	if err != nil {
+		fatal(fmt.Sprintf("Cannot check whether monitor %s exists: %s", monitorCode, err.Error()), exitUnavailable)
+	}
+
+	if exists {
+		return
+	}
+
+	if onMissingMonitor == "fail" {
+		fatal(fmt.Sprintf("Monitor %s does not exist and --on-missing-monitor is 'fail', the command was not run", monitorCode), exitUsage)
+	}
+
+	command := commandFile
+	if scriptStdin {
+		command = "script from stdin"
+	} else if len(command) == 0 {
+		command = strings.Join(commandParts, " ")
+	}
+
+	monitor := &lib.Monitor{
+		DefaultName: truncateString(fmt.Sprintf("[%s] %s", effectiveHostname(), command), maxNameLen),
+		Key:         monitorCode,
+		Type:        "heartbeat",
+		Rules:       []lib.Rule{},
+		Tags:        append([]string{"cron-job"}, ruleTags...),
+		Timezone:    strings.TrimSpace(effectiveTimezoneLocationName().Name),
+		Note:        "Created by cronitor exec --on-missing-monitor",
+	}
+
+	log(fmt.Sprintf("Monitor %s does not exist, creating it", monitorCode))
+	if _, err := api.PutMonitors(map[string]*lib.Monitor{monitorCode: monitor}); err != nil {
+		fatal(fmt.Sprintf("Cannot create monitor %s: %s", monitorCode, err.Error()), exitUnavailable)
+	}
+}
+
+// IsExecFlagWithValue reports whether arg is a flag accepted by exec that consumes the following argument as its value
+func IsExecFlagWithValue(arg string) bool {
+	return isFlagWithValue(execCmd, arg)
+}
+
+func isFlagWithValue(command *cobra.Command, arg string) bool {
+	if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+		return false
+	}
+
+	var commandFlag *flag.Flag
+	for _, flags := range []*flag.FlagSet{command.Flags(), command.InheritedFlags()} {
+		if strings.HasPrefix(arg, "--") {
+			commandFlag = flags.Lookup(arg[2:])
+		} else if len(arg) == 2 {
+			commandFlag = flags.ShorthandLookup(arg[1:])
+		}
+
+		if commandFlag != nil {
+			break
+		}
+	}
+
+	return commandFlag != nil && len(commandFlag.NoOptDefVal) == 0
+}
+
+func runHook(hook string, exitCode int, extraEnv ...string) error {
+	log(fmt.Sprintf("Running hook: %s", hook))
+
+	hookCmd := makeSubcommandExec(hook)
+	hookCmd.Env = append(os.Environ(),
+		fmt.Sprintf("CRONITOR_EXIT_CODE=%d", exitCode),
+		fmt.Sprintf("CRONITOR_MONITOR_CODE=%s", monitorCode),
+	)
+	hookCmd.Env = append(hookCmd.Env, extraEnv...)
+
+	output, err := hookCmd.CombinedOutput()
+	if len(output) > 0 {
+		log(strings.TrimSpace(string(output)))
+	}
+
+	if err != nil {
+		log(fmt.Sprintf("Hook failed: %s", err.Error()))
+	}
+
+	return err
+}
+
+// peekStdin reports whether stdin has any data. Terminals and other character devices such as /dev/null are treated as
+// empty. Reading from a pipe blocks until data arrives or the writer closes it. Only the first byte is read, so the
+// command can start while the rest is still being written, and the returned reader gives that byte back first.
+func peekStdin() (bool, io.Reader) {
+	stdinStat, err := os.Stdin.Stat()
+	if err != nil || stdinStat.Mode()&os.ModeCharDevice != 0 {
+		return false, nil
+	}
+
+	if stdinStat.Mode().IsRegular() && stdinStat.Size() == 0 {
+		return false, nil
+	}
+
+	peeked := make([]byte, 1)
+	if _, err := io.ReadFull(os.Stdin, peeked); err != nil {
+		if err != io.EOF {
+			log(fmt.Sprintf("Cannot read stdin: %s", err.Error()))
+		}
+		return false, nil
+	}
+
+	return true, io.MultiReader(bytes.NewReader(peeked), os.Stdin)
+}
+
+// makeAllowlistedEnv returns the variables from the current environment that are named in allowlist
+func makeAllowlistedEnv(allowlist []string) []string {
+	env := []string{}
+	for _, name := range allowlist {
+		if value, ok := os.LookupEnv(strings.TrimSpace(name)); ok {
+			env = append(env, strings.TrimSpace(name)+"="+value)
+		}
+	}
+
+	return env
+}
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// readJobEnvFile reads the KEY=VALUE lines of a --job-env-file. Matching single or double quotes around a value are
+// removed, and nothing is expanded.
+func readJobEnvFile(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for lineNumber, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !envNameRegex.MatchString(name) {
+			return nil, errors.New(fmt.Sprintf("invalid line at %s L%d, expecting KEY=VALUE", path, lineNumber+1))
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, name+"="+value)
+	}
+
+	return env, nil
+}
+
+// startProgressPings sends a run ping every --progress-interval until the returned function is called. The function
+// returns once no more progress pings can be started, so the complete or fail ping is always sent after them.
+func startProgressPings(series string, startTime float64, group *sync.WaitGroup) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				elapsed := time.Duration((makeStamp() - startTime) * float64(time.Second)).Round(time.Second)
+				message := fmt.Sprintf("Still running after %s", elapsed)
+				metrics := map[string]int{"elapsed": int(elapsed.Seconds())}
+				group.Add(1)
+				go sendPing("run", monitorCode, message, series, makeStamp(), nil, nil, metrics, group)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// stderrTailBytes is how much of the end of stderr is kept for the --fail-on-stderr message
+const stderrTailBytes = 4096
+
+// stderrMatchedLines is how many of the last lines that match --fail-on-stderr-pattern are kept for the message
+const stderrMatchedLines = 20
+
+// stderrWatcher passes stderr through to output and keeps what --fail-on-stderr reports: the end of stderr, or with
+// --fail-on-stderr-pattern, the lines that match
+type stderrWatcher struct {
+	output  io.Writer
+	pattern *regexp.Regexp
+	written int64
+	tail    []byte
+	partial []byte
+	matched []string
+	matches int
+}
+
+func (w *stderrWatcher) Write(b []byte) (int, error) {
+	w.written += int64(len(b))
+	w.tail = append(w.tail, b...)
+	if len(w.tail) > stderrTailBytes {
+		w.tail = w.tail[len(w.tail)-stderrTailBytes:]
+	}
+
+	if w.pattern != nil {
+		w.partial = append(w.partial, b...)
+		for {
+			end := bytes.IndexByte(w.partial, '\n')
+			if end < 0 {
+				break
+			}
+			w.matchLine(string(w.partial[:end]))
+			w.partial = w.partial[end+1:]
+		}
+
+		// A very long line is matched in pieces rather than buffered whole
+		if len(w.partial) > stderrTailBytes {
+			w.matchLine(string(w.partial))
+			w.partial = nil
+		}
+	}
+
+	return w.output.Write(b)
+}
+
+func (w *stderrWatcher) matchLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if !w.pattern.MatchString(line) {
+		return
+	}
+
+	w.matches++
+	w.matched = append(w.matched, line)
+	if len(w.matched) > stderrMatchedLines {
+		w.matched = w.matched[1:]
+	}
+}
+
+// failure returns why the run fails, and the stderr output to send with it, or empty strings when it does not fail
+func (w *stderrWatcher) failure() (string, string) {
+	if w.pattern != nil {
+		if len(w.partial) > 0 {
+			w.matchLine(string(w.partial))
+			w.partial = nil
+		}
+
+		if w.matches == 0 {
+			return "", ""
+		}
+
+		lines := "lines"
+		if w.matches == 1 {
+			lines = "line"
+		}
+		return fmt.Sprintf("[--fail-on-stderr-pattern] The command wrote %d %s to stderr matching %s", w.matches, lines, w.pattern.String()), strings.Join(w.matched, "\n")
+	}
+
+	if w.written == 0 {
+		return "", ""
+	}
+
+	return fmt.Sprintf("[--fail-on-stderr] The command wrote %d bytes to stderr", w.written), string(w.tail)
+}
+
+// syncWriter serializes writes from the stdout and stderr pipes to the same writer
+type syncWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (w *syncWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(b)
+}
+
+func emptyOutputMessage() string {
+	switch captureStream {
+	case "stdout", "stderr":
+		return fmt.Sprintf("[--fail-if-empty-output] The command exited 0 but wrote nothing to %s", captureStream)
+	default:
+		return "[--fail-if-empty-output] The command exited 0 but wrote nothing to stdout or stderr"
+	}
+}
+
+// filterMessage pipes message through the --message-filter command and returns what it writes to stdout. The
+// message is returned unchanged when the filter cannot be started, exits nonzero or runs longer than timeout.
+func filterMessage(filter string, message []byte, timeout time.Duration) []byte {
+	log(fmt.Sprintf("Running message filter: %s", filter))
+
+	var stdout, stderr bytes.Buffer
+	filterCmd := makeSubcommandExec(filter)
+	filterCmd.Env = append(os.Environ(), fmt.Sprintf("CRONITOR_MONITOR_CODE=%s", monitorCode))
+	filterCmd.Stdin = bytes.NewReader(message)
+	filterCmd.Stdout = &stdout
+	filterCmd.Stderr = &stderr
+
+	if err := filterCmd.Start(); err != nil {
+		log(fmt.Sprintf("Message filter failed, sending the unfiltered message: %s", err.Error()))
+		return message
+	}
+
+	// Wait in the background so a filter that hangs, or leaves a child holding its stdout, cannot hold up the pings
+	done := make(chan error, 1)
+	go func() {
+		done <- filterCmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if stderr.Len() > 0 {
+			log(strings.TrimSpace(stderr.String()))
+		}
+		if err != nil {
+			log(fmt.Sprintf("Message filter failed, sending the unfiltered message: %s", err.Error()))
+			return message
+		}
+	case <-time.After(timeout):
+		filterCmd.Process.Kill()
+		log(fmt.Sprintf("Message filter did not finish within %s, sending the unfiltered message", timeout))
+		return message
+	}
+
+	return stdout.Bytes()
+}
+
+// joinMessage combines a static message and captured output, separated by a newline, in at most maxLength bytes.
+// The static message is kept whole when it fits and the captured output is cut from the front to fill what is left,
+// because the end of the output is usually more informative.
+func joinMessage(static string, captured string, maxLength int) string {
+	if len(static) == 0 {
+		return captured
+	}
+
+	captured = strings.TrimSpace(captured)
+
+	if len(static) >= maxLength {
+		return truncateMessage(static, maxLength)
+	}
+
+	budget := maxLength - len(static) - 1
+	if len(captured) == 0 || budget <= 0 {
+		return static
+	}
+
+	if len(captured) > budget {
+		start := len(captured) - budget
+		for start < len(captured) && !utf8.RuneStart(captured[start]) {
+			start++
+		}
+		captured = strings.TrimSpace(captured[start:])
+	}
+
+	return static + "\n" + captured
+}
+
+// resolvedWorkingDirectory returns the working directory with symlinks resolved, so a job run from a deploy symlink
+// like /srv/app/current reports the release it actually ran in. An empty string is returned when it cannot be read.
+func resolvedWorkingDirectory() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return dir
+}
+
+// commandArguments returns the arguments after the program in commandParts. A single part is a shell command and is
+// split into words the way a shell would, falling back to whitespace when it cannot be parsed.
+func commandArguments(commandParts []string) []string {
+	if len(commandParts) > 1 {
+		return commandParts[1:]
+	}
+
+	words, err := shellquote.Split(commandParts[0])
+	if err != nil {
+		words = strings.Fields(commandParts[0])
+	}
+	if len(words) < 2 {
+		return nil
+	}
+	return words[1:]
+}
+
+// readGitRevision returns the short commit hash checked out in dir or its closest parent repository. An empty string
+// is returned when dir is not in a git repository or HEAD cannot be resolved.
+func readGitRevision(dir string) string {
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		if stat, err := os.Stat(gitDir); err == nil {
+			if !stat.IsDir() {
+				// Worktrees and submodules have a .git file that points to the real git directory
+				contents, err := ioutil.ReadFile(gitDir)
+				if err != nil || !strings.HasPrefix(string(contents), "gitdir:") {
+					return ""
+				}
+				gitDir = strings.TrimSpace(strings.TrimPrefix(string(contents), "gitdir:"))
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+			}
+
+			return shortGitHash(readGitHead(gitDir))
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func readGitHead(gitDir string) string {
+	head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+
+	ref := strings.TrimSpace(string(head))
+	if !strings.HasPrefix(ref, "ref:") {
+		// Detached HEAD
+		return ref
+	}
+	ref = strings.TrimSpace(strings.TrimPrefix(ref, "ref:"))
+
+	// A worktree keeps its HEAD but shares refs with the main repository
+	refDirs := []string{gitDir}
+	if commonDir, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		path := strings.TrimSpace(string(commonDir))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(gitDir, path)
+		}
+		refDirs = append(refDirs, path)
+	}
+
+	for _, refDir := range refDirs {
+		if hash, err := ioutil.ReadFile(filepath.Join(refDir, filepath.FromSlash(ref))); err == nil {
+			return strings.TrimSpace(string(hash))
+		}
+
+		if packedRefs, err := ioutil.ReadFile(filepath.Join(refDir, "packed-refs")); err == nil {
+			for _, line := range strings.Split(string(packedRefs), "\n") {
+				if fields := strings.Fields(line); len(fields) == 2 && fields[1] == ref {
+					return fields[0]
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+var gitHashRegex = regexp.MustCompile(`^[0-9a-f]{40,64}$`)
+
+func shortGitHash(hash string) string {
+	if !gitHashRegex.MatchString(hash) {
+		return ""
+	}
+
+	return hash[:7]
+}
+
+// expandCaptureFilePath replaces %code and %timestamp in a --capture-file path
+func expandCaptureFilePath(path string, code string, start time.Time) string {
+	return strings.NewReplacer(
+		"%code", unsafeFileNameRegex.ReplaceAllString(code, "_"),
+		"%timestamp", start.Format("20060102T150405"),
+	).Replace(path)
+}
+
+// captureFileWriter writes captured output to --capture-file. When maxSize is set, a file that would grow beyond it is
+// moved to <path>.1, replacing an earlier one, and a new file is started.
+type captureFileWriter struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	failed  bool
+}
+
+func openCaptureFile(path string, maxSize int64) (*captureFileWriter, error) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &captureFileWriter{path: path, maxSize: maxSize, file: file}, nil
+}
+
+// Write never returns an error so a full disk cannot interrupt the output of the command
+func (w *captureFileWriter) Write(b []byte) (int, error) {
+	if w.failed {
+		return len(b), nil
+	}
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(b)) > w.maxSize {
+		w.rotate()
+	}
+
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		log(fmt.Sprintf("Stopped writing to capture file: %s", err.Error()))
+		w.failed = true
+	}
+
+	return len(b), nil
+}
+
+func (w *captureFileWriter) rotate() {
+	w.file.Close()
+	os.Rename(w.path, w.path+".1")
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		log(fmt.Sprintf("Stopped writing to capture file: %s", err.Error()))
+		w.failed = true
+		return
+	}
+
+	w.file = file
+	w.size = 0
+}
+
+func (w *captureFileWriter) Close() error {
+	return w.file.Close()
+}
+
+// outputDrainTimeout is how long output already written by a killed command is read before exec stops reading
+const outputDrainTimeout = 2 * time.Second
+
+// outputPipe copies what a command writes to the pipe to a writer. Unlike an io.Writer given to exec.Cmd, waiting for
+// the command does not wait for the copy to finish.
+type outputPipe struct {
+	reader  *os.File
+	writer  *os.File
+	output  io.Writer
+	done    chan struct{}
+	mutex   sync.Mutex
+	stopped bool
+}
+
+func newOutputPipe(output io.Writer) (*outputPipe, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := &outputPipe{reader: reader, writer: writer, output: output, done: make(chan struct{})}
+	go func() {
+		defer close(pipe.done)
+		io.Copy(pipe, reader)
+	}()
+
+	return pipe, nil
+}
+
+func (p *outputPipe) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stopped {
+		return 0, io.ErrClosedPipe
+	}
+
+	return p.output.Write(b)
+}
+
+// wait returns once every process holding the pipe has closed it
+func (p *outputPipe) wait() {
+	<-p.done
+	p.reader.Close()
+}
+
+// drain copies the output that is already in the pipe, then stops after timeout if a process started by the command
+// still holds the pipe open. Nothing is written to the output after drain returns.
+func (p *outputPipe) drain(timeout time.Duration) {
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		log("Stopped reading output: the pipe is still open in a process started by the command")
+	}
+
+	p.mutex.Lock()
+	p.stopped = true
+	p.mutex.Unlock()
+	p.reader.Close()
+}
+
+// commandExitCode returns the exit code for an error returned by running the command
+func commandExitCode(err error) int {
+	// This works on both Posix and Windows (syscall.WaitStatus is cross platform).
+	// Cribbed from aws-vault.
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+		return 1
+	}
+
+	// The command could not be started
+	return exitInternal
+}
+
+// isWarnExitCode reports whether code is one of the --warn-exit-codes
+func isWarnExitCode(code int) bool {
+	for _, warnCode := range warnExitCodes {
+		if code == warnCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wasKilled reports whether the command was terminated by a signal
+func wasKilled(err error) bool {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.Signaled()
+		}
+	}
+
+	return false
+}
+
+func isTerminationSignal(sig os.Signal) bool {
+	switch sig {
+	case os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT:
+		return true
+	}
+
+	return false
+}
+
+// captureWriter passes output through to stream and copies it to the temp file when one is available
+func captureWriter(stream *os.File, tempFile *os.File) io.Writer {
+	if tempFile == nil {
+		return stream
+	}
+
+	if maxOutputRate > 0 || maxOutputTotal > 0 {
+		return &outputLimiter{passthrough: stream, capture: tempFile, maxRate: maxOutputRate, maxTotal: maxOutputTotal}
+	}
+
+	return io.MultiWriter(stream, tempFile)
+}
+
+// outputLimiter stops copying output to the temp file, which is sent with pings and uploaded to Cronitor logs, once
+// --max-output-total bytes have been captured or more than --max-output-rate bytes are written within one second
+type outputLimiter struct {
+	passthrough io.Writer
+	capture     io.Writer
+	maxRate     int64
+	maxTotal    int64
+	total       int64
+	windowStart time.Time
+	windowBytes int64
+	capped      bool
+}
+
+func (l *outputLimiter) Write(b []byte) (int, error) {
+	if !l.capped {
+		now := time.Now()
+		if now.Sub(l.windowStart) >= time.Second {
+			l.windowStart = now
+			l.windowBytes = 0
+		}
+
+		reason := ""
+		if l.maxTotal > 0 && l.total+int64(len(b)) > l.maxTotal {
+			reason = fmt.Sprintf("--max-output-total of %d bytes was reached", l.maxTotal)
+		} else if l.maxRate > 0 && l.windowBytes+int64(len(b)) > l.maxRate {
+			reason = fmt.Sprintf("output exceeded --max-output-rate of %d bytes per second", l.maxRate)
+		}
+
+		if len(reason) > 0 {
+			l.capped = true
+			log("Output capture stopped: " + reason)
+			fmt.Fprintf(l.capture, "\n[cronitor] Output capture stopped after %d bytes: %s\n", l.total, reason)
+		} else {
+			l.total += int64(len(b))
+			l.windowBytes += int64(len(b))
+			l.capture.Write(b)
+		}
+	}
+
+	if !l.capped || !capPassthrough {
+		if _, err := l.passthrough.Write(b); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func makeCronLikeEnv() []string {
+	env := []string{"SHELL=/bin/sh"}
+	if homeValue, hasHome := os.LookupEnv("HOME"); hasHome {
+		env = append(env, "HOME="+homeValue)
+	}
+
+	return env
+}
+
+func makeSubcommandExec(subcommand string) *exec.Cmd {
 	var execCmd *exec.Cmd
 	if runtime.GOOS == "windows" {
 		execCmd = exec.Command("powershell.exe", "-Command", subcommand)
@@ -301,7 +2274,7 @@ func getFileSize(tempFile *os.File) (int64, error) {
 
 func gatherOutput(tempFile *os.File, truncateForPingOutput bool) []byte {
 	var outputBytes []byte
-	const outputForPingMaxLen int64 = 1000
+	outputForPingMaxLen := int64(effectiveMaxMessageBytes())
 	const outputForLogUploadMaxLen int64 = 100000000
 	if noStdoutPassthru || tempFile == nil {
 		outputBytes = []byte{}
@@ -324,9 +2297,71 @@ func gatherOutput(tempFile *os.File, truncateForPingOutput bool) []byte {
 		}
 	}
 
+	// When reading from the end of the file we may have started in the middle of a multi-byte character
+	for len(outputBytes) > 0 && !utf8.RuneStart(outputBytes[0]) {
+		outputBytes = outputBytes[1:]
+	}
+
 	return outputBytes
 }
 
+var logSampleRegex = regexp.MustCompile(`^1/([0-9]+)$`)
+
+// parseLogSample parses a --log-sample value like "1/10" and returns 10
+func parseLogSample(value string) (int, error) {
+	matches := logSampleRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return 0, errors.New("invalid argument supplied to 'log-sample'. Expecting 1/N, e.g. 1/10")
+	}
+
+	every, err := strconv.Atoi(matches[1])
+	if err != nil || every < 1 {
+		return 0, errors.New("invalid argument supplied to 'log-sample'. Expecting 1/N where N is at least 1")
+	}
+
+	return every, nil
+}
+
+// logSampleTailLines is the number of lines at the end of the output that --log-sample never drops
+const logSampleTailLines = 100
+
+// sampleLogLines keeps 1 of every sampleEvery lines, except for the last logSampleTailLines lines, and then at most
+// the maxLines most recent lines. Zero disables either limit. When lines are dropped a note is added at the end.
+func sampleLogLines(output string, sampleEvery int, maxLines int) string {
+	if sampleEvery <= 1 && maxLines <= 0 {
+		return output
+	}
+
+	lines := strings.SplitAfter(output, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	var kept []string
+	for i, line := range lines {
+		fromEnd := len(lines) - 1 - i
+		if sampleEvery <= 1 || fromEnd < logSampleTailLines || fromEnd%sampleEvery == 0 {
+			kept = append(kept, line)
+		}
+	}
+
+	if maxLines > 0 && len(kept) > maxLines {
+		kept = kept[len(kept)-maxLines:]
+	}
+
+	dropped := len(lines) - len(kept)
+	if dropped == 0 {
+		return output
+	}
+
+	sampled := strings.Join(kept, "")
+	if len(sampled) > 0 && !strings.HasSuffix(sampled, "\n") {
+		sampled += "\n"
+	}
+
+	return sampled + fmt.Sprintf("[cronitor] %d of %d lines were not uploaded because of --log-sample or --log-max-lines\n", dropped, len(lines))
+}
+
 func isStaleFile(file os.FileInfo) bool {
 	var timeLimit = 3 * 24 * time.Hour
 
@@ -338,8 +2373,15 @@ func isStaleFile(file os.FileInfo) bool {
 }
 
 func shipLogData(tempFile *os.File, series string, wg *sync.WaitGroup) {
-	outputForLogs := gatherOutput(tempFile, false)
-	_, err := lib.SendLogData(viper.GetString(varApiKey), monitorCode, series, string(outputForLogs))
+	// Shipping logs is an authenticated API call. Hosts configured with only a ping API key skip it.
+	if len(viper.GetString(varApiKey)) == 0 {
+		log("Skipping log upload: no API key is configured")
+		wg.Done()
+		return
+	}
+
+	outputForLogs := sampleLogLines(string(gatherOutput(tempFile, false)), logSampleEvery, logMaxLines)
+	_, err := getCronitorApi().SendLogData(monitorCode, series, outputForLogs)
 	if err != nil {
 		log(fmt.Sprintf("%v", err))
 	}
@@ -0,0 +1,722 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+func TestJoinMessage(t *testing.T) {
+	tables := []struct {
+		static   string
+		captured string
+		length   int
+		expected string
+	}{
+		{"", "job output\n", 100, "job output\n"},
+		{"Nightly backup", "", 100, "Nightly backup"},
+		{"Nightly backup", "  \n", 100, "Nightly backup"},
+		{"Nightly backup", "job output\n", 100, "Nightly backup\njob output"},
+		{"Nightly backup", "line 1\nline 2\nline 3", 22, "Nightly backup\nline 3"},
+		{"Nightly backup", "job output", 15, "Nightly backup"},
+		{"Nightly backup", "job output", 14, "Nightly backup"},
+		{"Nightly backup of db-1", "job output", 20, "Nightl…(truncated)"},
+	}
+
+	for _, table := range tables {
+		actual := joinMessage(table.static, table.captured, table.length)
+		if actual != table.expected {
+			t.Errorf("joinMessage(%q, %q, %d) was %q, expected %q", table.static, table.captured, table.length, actual, table.expected)
+		}
+
+		if table.length >= len(table.static) && len(actual) > table.length {
+			t.Errorf("joinMessage(%q, %q, %d) is %d bytes long", table.static, table.captured, table.length, len(actual))
+		}
+	}
+}
+
+func TestJoinMessageDoesNotSplitRunes(t *testing.T) {
+	actual := joinMessage("Backup", strings.Repeat("é", 20), 12)
+	if !utf8.ValidString(actual) || !strings.HasPrefix(actual, "Backup\n") || len(actual) > 12 {
+		t.Errorf("Unexpected message: %q", actual)
+	}
+}
+
+func TestJoinMessageLeavesRoomForPrefixAndSuffix(t *testing.T) {
+	viper.Set(varMessagePrefix, "[team-a] ")
+	viper.Set(varMessageSuffix, " (end)")
+	defer viper.Set(varMessagePrefix, "")
+	defer viper.Set(varMessageSuffix, "")
+
+	message := joinMessage("Nightly backup", strings.Repeat("x", 100), messageBodyLength(50))
+	actual := formatMessage(message, 50)
+	expected := "[team-a] Nightly backup\n" + strings.Repeat("x", 20) + " (end)"
+	if actual != expected {
+		t.Errorf("Unexpected message: %q, expected %q", actual, expected)
+	}
+}
+
+func TestReadGitRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := "4f2a9c1d8e7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+	gitDir := filepath.Join(dir, ".git")
+	subDir := filepath.Join(dir, "bin")
+	os.MkdirAll(filepath.Join(gitDir, "refs", "heads"), 0755)
+	os.MkdirAll(subDir, 0755)
+
+	if actual := readGitRevision(subDir); actual != "" {
+		t.Errorf("Expected no revision without a HEAD, got %s", actual)
+	}
+
+	ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644)
+	ioutil.WriteFile(filepath.Join(gitDir, "packed-refs"), []byte("# pack-refs with: peeled\n"+hash+" refs/heads/main\n"), 0644)
+	if actual := readGitRevision(subDir); actual != "4f2a9c1" {
+		t.Errorf("Expected the packed ref to be resolved, got %s", actual)
+	}
+
+	ioutil.WriteFile(filepath.Join(gitDir, "refs", "heads", "main"), []byte("0123456789abcdef0123456789abcdef01234567\n"), 0644)
+	if actual := readGitRevision(dir); actual != "0123456" {
+		t.Errorf("Expected the loose ref to be resolved, got %s", actual)
+	}
+
+	ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte(hash+"\n"), 0644)
+	if actual := readGitRevision(dir); actual != "4f2a9c1" {
+		t.Errorf("Expected a detached HEAD to be resolved, got %s", actual)
+	}
+
+	ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("not a hash\n"), 0644)
+	if actual := readGitRevision(dir); actual != "" {
+		t.Errorf("Expected an invalid HEAD to be ignored, got %s", actual)
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	tests := []struct {
+		parts    []string
+		expected []string
+	}{
+		{[]string{"bin/export", "--since", "yesterday"}, []string{"--since", "yesterday"}},
+		{[]string{"bin/export --name 'nightly run' DB_PASSWORD=x"}, []string{"--name", "nightly run", "DB_PASSWORD=x"}},
+		{[]string{"bin/export 'unterminated"}, []string{"'unterminated"}},
+		{[]string{"true"}, nil},
+	}
+
+	for _, test := range tests {
+		actual := commandArguments(test.parts)
+		if strings.Join(actual, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("Unexpected arguments of %q: %q, expected %q", test.parts, actual, test.expected)
+		}
+	}
+
+	if actual := redactCommandLine(commandArguments([]string{"bin/export --since yesterday DB_PASSWORD=hunter2"})); actual != "--since yesterday DB_PASSWORD=REDACTED" {
+		t.Errorf("Unexpected redacted arguments: %s", actual)
+	}
+}
+
+func TestResolvedWorkingDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-cwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	release := filepath.Join(dir, "releases", "42")
+	link := filepath.Join(dir, "current")
+	os.MkdirAll(release, 0755)
+	if err := os.Symlink(release, link); err != nil {
+		t.Skip("Symlinks are not supported: " + err.Error())
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(link)
+
+	expected, _ := filepath.EvalSymlinks(release)
+	if actual := resolvedWorkingDirectory(); actual != expected {
+		t.Errorf("Unexpected working directory: %s, expected %s", actual, expected)
+	}
+}
+
+func TestFilterMessage(t *testing.T) {
+	message := []byte("line one\nERROR: disk full\nline three\n")
+
+	if actual := string(filterMessage("grep ERROR", message, 5*time.Second)); actual != "ERROR: disk full\n" {
+		t.Errorf("Unexpected filtered message: %q", actual)
+	}
+
+	if actual := string(filterMessage("cat; exit 3", message, 5*time.Second)); actual != string(message) {
+		t.Errorf("Expected the unfiltered message when the filter fails, got %q", actual)
+	}
+
+	start := time.Now()
+	if actual := string(filterMessage("sleep 5", message, 100*time.Millisecond)); actual != string(message) {
+		t.Errorf("Expected the unfiltered message when the filter times out, got %q", actual)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the filter to be abandoned at its timeout, waited %s", elapsed)
+	}
+}
+
+func TestParseLogSample(t *testing.T) {
+	if every, err := parseLogSample("1/10"); err != nil || every != 10 {
+		t.Errorf("Unexpected result: %d, %v", every, err)
+	}
+
+	for _, value := range []string{"10", "2/10", "1/0", "1/", "1/x"} {
+		if _, err := parseLogSample(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestSampleLogLines(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 300; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	output := strings.Join(lines, "")
+
+	if actual := sampleLogLines(output, 0, 0); actual != output {
+		t.Errorf("Output was changed without any limits")
+	}
+
+	if actual := sampleLogLines(output, 0, 500); actual != output {
+		t.Errorf("Output was changed when it was under the line limit")
+	}
+
+	sampled := sampleLogLines(output, 10, 0)
+	if !strings.HasSuffix(sampled, "line 300\n[cronitor] 180 of 300 lines were not uploaded because of --log-sample or --log-max-lines\n") {
+		t.Errorf("Unexpected end of sampled output: %q", sampled[len(sampled)-120:])
+	}
+	if !strings.Contains(sampled, "line 201\n") || strings.Contains(sampled, "line 199\n") || !strings.Contains(sampled, "line 190\n") {
+		t.Errorf("The tail was not kept in full or the rest was not sampled")
+	}
+
+	limited := sampleLogLines(output, 0, 2)
+	if limited != "line 299\nline 300\n[cronitor] 298 of 300 lines were not uploaded because of --log-sample or --log-max-lines\n" {
+		t.Errorf("Unexpected limited output: %q", limited)
+	}
+
+	if actual := sampleLogLines("a\nb\nc", 0, 1); actual != "c\n[cronitor] 2 of 3 lines were not uploaded because of --log-sample or --log-max-lines\n" {
+		t.Errorf("Unexpected output without a trailing newline: %q", actual)
+	}
+}
+
+func TestRemovePidFileOnlyRemovesItsOwnPid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.pid")
+	writePidFile(path, 1234)
+	if contents, err := ioutil.ReadFile(path); err != nil || string(contents) != "1234\n" {
+		t.Fatalf("Unexpected PID file contents: %q, %v", contents, err)
+	}
+
+	removePidFile(path, 5678)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("A PID file written by another run was removed")
+	}
+
+	removePidFile(path, 1234)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("The PID file was not removed")
+	}
+}
+
+func TestOutcomeNeedsPing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.SetConfigFile(filepath.Join(dir, "cronitor.json"))
+	defer viper.SetConfigFile("")
+	heartbeatInterval = time.Hour
+	now := time.Now()
+
+	if !outcomeNeedsPing("d3x0c1", "complete", now) {
+		t.Errorf("Expected the first run to ping")
+	}
+
+	saveOutcomeState("d3x0c1", "complete", now)
+	if outcomeNeedsPing("d3x0c1", "complete", now.Add(time.Minute)) {
+		t.Errorf("Expected an unchanged outcome not to ping")
+	}
+
+	if !outcomeNeedsPing("d3x0c1", "fail", now.Add(time.Minute)) {
+		t.Errorf("Expected a changed outcome to ping")
+	}
+
+	if !outcomeNeedsPing("d3x0c1", "complete", now.Add(time.Hour)) {
+		t.Errorf("Expected an unchanged outcome to ping after the heartbeat interval")
+	}
+
+	if !outcomeNeedsPing("other/monitor", "complete", now) {
+		t.Errorf("Expected the outcome to be recorded per monitor")
+	}
+}
+
+func TestOutputPipeDrainStopsWhenThePipeIsHeldOpen(t *testing.T) {
+	var output strings.Builder
+	pipe, err := newOutputPipe(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer stays open, like a process left running by a killed command
+	pipe.writer.Write([]byte("before-hang\n"))
+	defer pipe.writer.Close()
+
+	start := time.Now()
+	pipe.drain(200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("drain took %s", elapsed)
+	}
+
+	if output.String() != "before-hang\n" {
+		t.Errorf("Unexpected output: %q", output.String())
+	}
+
+	if _, err := pipe.Write([]byte("after")); err == nil || output.String() != "before-hang\n" {
+		t.Errorf("Output was written after drain returned")
+	}
+}
+
+func TestOutputPipeWaitCopiesEverything(t *testing.T) {
+	var output strings.Builder
+	pipe, err := newOutputPipe(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe.writer.Write([]byte("line 1\nline 2\n"))
+	pipe.writer.Close()
+	pipe.wait()
+
+	if output.String() != "line 1\nline 2\n" {
+		t.Errorf("Unexpected output: %q", output.String())
+	}
+}
+
+func TestExpandCaptureFilePath(t *testing.T) {
+	start := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	actual := expandCaptureFilePath("/var/log/%code-%timestamp.log", "team/backup job", start)
+	if actual != "/var/log/team_backup_job-20240501T020000.log" {
+		t.Errorf("Unexpected path: %s", actual)
+	}
+}
+
+func TestCaptureFileWriterRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "job.log")
+	writer, err := openCaptureFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		writer.Write([]byte(line))
+	}
+	writer.Close()
+
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "line 3\n" {
+		t.Errorf("Unexpected current file: %q", contents)
+	}
+
+	if contents, _ := ioutil.ReadFile(path + ".1"); string(contents) != "line 2\n" {
+		t.Errorf("Unexpected rotated file: %q", contents)
+	}
+}
+
+func TestTryAcquireExecSlot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, _ := tryAcquireExecSlot(dir, 2)
+	second, _ := tryAcquireExecSlot(dir, 2)
+	if first == nil || second == nil || first.path == second.path {
+		t.Fatalf("Expected two different slots, got %v and %v", first, second)
+	}
+
+	if third, _ := tryAcquireExecSlot(dir, 2); third != nil {
+		t.Errorf("Expected no slot when every slot is taken, got %s", third.path)
+	}
+
+	first.release()
+	if third, _ := tryAcquireExecSlot(dir, 2); third == nil || third.path != first.path {
+		t.Errorf("Expected the released slot to be claimed again")
+	}
+}
+
+func TestTryAcquireExecSlotReclaimsStaleSlots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A slot file left by an exec that was killed is not locked by anyone
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip("Cannot run true: " + err.Error())
+	}
+	ioutil.WriteFile(filepath.Join(dir, "slot-1"), []byte(fmt.Sprintf("%d\n", exited.Process.Pid)), 0600)
+
+	if slot, _ := tryAcquireExecSlot(dir, 1); slot == nil {
+		t.Errorf("Expected the stale slot to be claimed")
+	}
+}
+
+func TestTryAcquireExecSlotClaimsStaleSlotOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "slot-1"), []byte("999999\n"), 0600)
+
+	var claimed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if slot, _ := tryAcquireExecSlot(dir, 1); slot != nil {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if claimed != 1 {
+		t.Errorf("Expected the stale slot to be claimed exactly once, claimed %d times", claimed)
+	}
+}
+
+func TestLateStartNote(t *testing.T) {
+	defer func() { startDeadline, cronSchedule = "", "" }()
+	started := time.Date(2024, 5, 1, 2, 7, 30, 0, time.Local)
+
+	startDeadline, cronSchedule = "5m", "0 2 * * *"
+	if note, delay := lateStartNote(started); note != "Late start: started 7m30s after the scheduled time 02:00, the deadline is 5m0s" || delay != 450*time.Second {
+		t.Errorf("Unexpected note: %q, delay %s", note, delay)
+	}
+
+	startDeadline = "10m"
+	if note, _ := lateStartNote(started); note != "" {
+		t.Errorf("Expected no note within the deadline, got %q", note)
+	}
+
+	startDeadline, cronSchedule = "02:05", ""
+	if note, delay := lateStartNote(started); note != "Late start: started 2m30s after the 02:05 deadline" || delay != 150*time.Second {
+		t.Errorf("Unexpected note: %q, delay %s", note, delay)
+	}
+
+	if _, _, err := parseStartDeadline("soon"); err == nil {
+		t.Errorf("Expected an error for an invalid deadline")
+	}
+}
+
+func TestParseJsonResult(t *testing.T) {
+	defer func() { resultMetrics, resultMessageField = nil, "" }()
+
+	metrics, message, err := parseJsonResult(`{"count": 1200, "error_count": 3, "status": "ok"}`)
+	if err != nil || metrics["count"] != 1200 || metrics["error_count"] != 3 || len(metrics) != 2 || message != "" {
+		t.Errorf("Unexpected result with the default fields: %v, %q, %v", metrics, message, err)
+	}
+
+	resultMetrics = []string{"processed=count", "errors=error_count"}
+	resultMessageField = "status"
+	metrics, message, err = parseJsonResult(`{"processed": 1200, "errors": "three", "status": "ok"}`)
+	if err != nil || metrics["count"] != 1200 || len(metrics) != 1 || message != "ok" {
+		t.Errorf("Unexpected result with mapped fields: %v, %q, %v", metrics, message, err)
+	}
+
+	if _, _, err := parseJsonResult("done"); err == nil {
+		t.Errorf("Expected an error for a line that is not JSON")
+	}
+
+	if _, err := parseResultMetrics([]string{"size=length"}); err == nil {
+		t.Errorf("Expected an error for the length metric")
+	}
+}
+
+func TestReadLastLine(t *testing.T) {
+	file, err := ioutil.TempFile("", "cronitor-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	file.WriteString("starting\n{\"count\": 1}\n\n")
+	if actual := readLastLine(file, 1000); actual != `{"count": 1}` {
+		t.Errorf("Unexpected last line: %q", actual)
+	}
+
+	if actual := readLastLine(file, 8); actual != `t": 1}` {
+		t.Errorf("Unexpected last line when limited: %q", actual)
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	warnExitCodes = []int{3}
+	defer func() { warnExitCodes = nil }()
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code := commandExitCode(err); code != 3 || !isWarnExitCode(code) {
+		t.Errorf("Expected exit code 3 to be a warning, got %d", code)
+	}
+
+	err = exec.Command("sh", "-c", "exit 4").Run()
+	if code := commandExitCode(err); code != 4 || isWarnExitCode(code) {
+		t.Errorf("Expected exit code 4 not to be a warning, got %d", code)
+	}
+
+	if code := commandExitCode(exec.Command("/nonexistent/command").Run()); code != exitInternal {
+		t.Errorf("Expected a command that cannot start to exit with %d, got %d", exitInternal, code)
+	}
+}
+
+func TestOutputLimiter(t *testing.T) {
+	defer func() { capPassthrough = false }()
+
+	var passthrough, capture strings.Builder
+	limiter := &outputLimiter{passthrough: &passthrough, capture: &capture, maxTotal: 10}
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		limiter.Write([]byte(line))
+	}
+
+	if passthrough.String() != "line 1\nline 2\nline 3\n" {
+		t.Errorf("Output was not passed through: %q", passthrough.String())
+	}
+	if capture.String() != "line 1\n\n[cronitor] Output capture stopped after 7 bytes: --max-output-total of 10 bytes was reached\n" {
+		t.Errorf("Unexpected captured output: %q", capture.String())
+	}
+
+	capPassthrough = true
+	passthrough.Reset()
+	capture.Reset()
+	limiter = &outputLimiter{passthrough: &passthrough, capture: &capture, maxRate: 10}
+	limiter.Write([]byte("line 1\n"))
+	limiter.Write([]byte("line 2\n"))
+	if passthrough.String() != "line 1\n" || !strings.Contains(capture.String(), "--max-output-rate of 10 bytes per second") {
+		t.Errorf("Unexpected output with --cap-passthrough: %q, %q", passthrough.String(), capture.String())
+	}
+}
+
+func TestReadJobEnvFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "cronitor-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("# database\nDB_HOST=db-1\nexport DB_PASSWORD=\"p=ss word\"\n\nGREETING='$HOME'\nEMPTY=\n")
+	file.Close()
+
+	expected := []string{"DB_HOST=db-1", "DB_PASSWORD=p=ss word", "GREETING=$HOME", "EMPTY="}
+	if actual, err := readJobEnvFile(file.Name()); err != nil || strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected env %q, %v", actual, err)
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("DB_HOST=db-1\nnot a variable\n"), 0600)
+	if _, err := readJobEnvFile(file.Name()); err == nil || !strings.Contains(err.Error(), "L2") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+}
+
+func TestProgressPings(t *testing.T) {
+	var mutex sync.Mutex
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		messages = append(messages, r.URL.Query().Get("msg")+" "+r.URL.Query().Get("metric"))
+	}))
+	defer server.Close()
+
+	viper.Set(varPingHost, server.URL)
+	defer viper.Set(varPingHost, "")
+	monitorCode = "d3x0c1"
+	progressInterval = 100 * time.Millisecond
+	defer func() { progressInterval = 0 }()
+
+	var wg sync.WaitGroup
+	stop := startProgressPings("1", makeStamp()-60, &wg)
+	time.Sleep(250 * time.Millisecond)
+	stop()
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(messages) != 2 || messages[0] != "Still running after 1m0s elapsed:60" {
+		t.Errorf("Expected 2 progress pings, got %q", messages)
+	}
+}
+
+func TestReadScriptMonitorCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		script string
+		code   string
+	}{
+		{"#!/bin/sh\n# cronitor: d3x0c1\necho hi\n", "d3x0c1"},
+		{"#!/bin/sh\n#   Cronitor:nightly-backup  \n", ""},
+		{"#!/bin/sh\n#cronitor:nightly-backup\n", "nightly-backup"},
+		{"#!/bin/sh\necho '# cronitor: d3x0c1'\n", ""},
+		{"#!/bin/sh\n" + strings.Repeat("\n", 20) + "# cronitor: d3x0c1\n", ""},
+	}
+
+	for i, table := range tables {
+		path := filepath.Join(dir, fmt.Sprintf("job-%d.sh", i))
+		ioutil.WriteFile(path, []byte(table.script), 0755)
+
+		code, err := readScriptMonitorCode(path)
+		if code != table.code {
+			t.Errorf("Expected code '%s' from %q, got '%s'", table.code, table.script, code)
+		}
+		if len(table.code) == 0 && err == nil {
+			t.Errorf("Expected an error for %q", table.script)
+		}
+	}
+
+	if _, err := readScriptMonitorCode(filepath.Join(dir, "missing.sh")); err == nil {
+		t.Error("Expected an error for a missing script")
+	}
+}
+
+func TestStderrWatcher(t *testing.T) {
+	var output strings.Builder
+	watcher := &stderrWatcher{output: &output}
+	if failure, _ := watcher.failure(); len(failure) > 0 {
+		t.Errorf("Expected no failure before anything is written, got %s", failure)
+	}
+
+	watcher.Write([]byte("something went wrong\n"))
+	if failure, detail := watcher.failure(); !strings.Contains(failure, "wrote 21 bytes to stderr") || detail != "something went wrong\n" {
+		t.Errorf("Unexpected failure %q with %q", failure, detail)
+	}
+	if output.String() != "something went wrong\n" {
+		t.Errorf("Expected stderr to be passed through, got %q", output.String())
+	}
+
+	watcher = &stderrWatcher{output: ioutil.Discard, pattern: regexp.MustCompile(`ERROR|FATAL`)}
+	watcher.Write([]byte("warning: disk 80% full\nERR"))
+	watcher.Write([]byte("OR: cannot connect\r\nretrying\nFATAL: gave up"))
+	failure, detail := watcher.failure()
+	if !strings.Contains(failure, "wrote 2 lines to stderr matching ERROR|FATAL") {
+		t.Errorf("Unexpected failure %q", failure)
+	}
+	if detail != "ERROR: cannot connect\nFATAL: gave up" {
+		t.Errorf("Unexpected matching lines %q", detail)
+	}
+
+	watcher = &stderrWatcher{output: ioutil.Discard, pattern: regexp.MustCompile(`ERROR`)}
+	watcher.Write([]byte("warning: disk 80% full\n"))
+	if failure, _ := watcher.failure(); len(failure) > 0 {
+		t.Errorf("Expected no failure without a matching line, got %s", failure)
+	}
+}
+
+func TestCommandChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.sh")
+	ioutil.WriteFile(path, []byte("test"), 0755)
+
+	target, checksum, err := commandChecksum(path + " --full 'two words'")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if target != path || checksum != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Errorf("Unexpected checksum %s of %s", checksum, target)
+	}
+
+	for _, command := range []string{"bash " + path, "python3 -W ignore " + path + " --full", "/usr/bin/env LANG=C node " + path} {
+		if target, checksum, err := commandChecksum(command); err != nil || target != path || checksum != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+			t.Errorf("Expected the script run by %q to be hashed, got %s of %s, %v", command, checksum, target, err)
+		}
+	}
+
+	for _, command := range []string{"cd /tmp", dir, filepath.Join(dir, "missing.sh"), "'unterminated", "bash -lc 'echo " + path + "'", "python3 -m http.server", "python3 " + filepath.Join(dir, "missing.py")} {
+		if _, _, err := commandChecksum(command); err == nil {
+			t.Errorf("Expected an error for %s", command)
+		}
+	}
+}
+
+func TestPeekStdinReturnsEveryByte(t *testing.T) {
+	originalStdin := os.Stdin
+	defer func() { os.Stdin = originalStdin }()
+
+	tables := []struct {
+		input    string
+		hasInput bool
+	}{
+		{"", false},
+		{"x", true},
+		{"first line\nsecond line\n", true},
+	}
+
+	for _, table := range tables {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.WriteString(table.input)
+		writer.Close()
+		os.Stdin = reader
+
+		hasInput, input := peekStdin()
+		if hasInput != table.hasInput {
+			t.Errorf("peekStdin() with input %q reported %t", table.input, hasInput)
+		}
+		if hasInput {
+			if all, _ := ioutil.ReadAll(input); string(all) != table.input {
+				t.Errorf("peekStdin() returned %q, expected %q", all, table.input)
+			}
+		}
+		reader.Close()
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockExecSlot opens path and takes an exclusive flock on it without waiting. errExecSlotTaken is returned when another
+// process holds the lock.
+func lockExecSlot(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errExecSlotTaken
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/spf13/cobra"
+)
+
+var importCsvFile string
+var importCrontabFile string
+var importDryRun bool
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Create or update monitors from a CSV file or a crontab file",
+	Long: `
+Create or update monitors in bulk from schedules kept outside of a crontab. Nothing on this host is changed: no crontab is
+read or written except the file given, and no Cronitor integration is added to it.
+
+Example importing a CSV file:
+  $ cronitor import --csv monitors.csv
+      > The first row names the columns. name and schedule are required, code and tags are optional:
+        name,schedule,code,tags
+        Nightly backup,0 2 * * *,nightly-backup,backup;db
+        Hourly report,@hourly,,reports
+      > Schedules are 5 field cron expressions or macros like @daily. Tags are separated by ;
+      > A row with a code updates the monitor with that key, or creates it. A row without a code is matched by name, so
+        importing the same file again updates the monitors it created instead of adding new ones.
+
+Example importing a crontab file:
+  $ cronitor import --crontab /srv/deploy/crontab
+      > Each cron job becomes a monitor, named and keyed the same way as 'cronitor discover' would on this host, so a later
+        discover --import of the installed crontab updates these monitors instead of creating new ones
+
+Example previewing an import:
+  $ cronitor import --csv monitors.csv --dry-run
+      > Prints the monitors that would be created or updated without contacting Cronitor
+
+Monitors are created with the timezone of this host. A file that cannot be read or has an invalid row exits with code 66
+before anything is sent.
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(importCsvFile) > 0 == (len(importCrontabFile) > 0) {
+			return errors.New("either --csv or --crontab is required")
+		}
+
+		if !importDryRun {
+			if err := requireApiKey(cmd); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		timezone = effectiveTimezoneLocationName()
+
+		var monitors []*lib.Monitor
+		var err error
+		if len(importCsvFile) > 0 {
+			monitors, err = readImportCsv(importCsvFile)
+		} else {
+			monitors, err = readImportCrontab(importCrontabFile)
+		}
+		if err != nil {
+			fatal(err.Error(), exitNoInput)
+		}
+
+		if len(monitors) == 0 {
+			printWarningText("No monitors were found to import", false)
+			return
+		}
+
+		if importDryRun {
+			printImportSummary(monitors)
+			printDoneText(fmt.Sprintf("Dry run: %d monitors would be created or updated. Nothing was sent to Cronitor.", len(monitors)), false)
+			return
+		}
+
+		byKey := map[string]*lib.Monitor{}
+		for _, monitor := range monitors {
+			byKey[monitor.Key] = monitor
+		}
+
+		if _, err := getCronitorApi().PutMonitors(byKey); err != nil {
+			fatal(err.Error(), exitUnavailable)
+		}
+
+		printImportSummary(monitors)
+		printDoneText(fmt.Sprintf("Imported %d monitors", len(monitors)), false)
+	},
+}
+
+// readImportCsv reads monitors from a CSV file with a header row naming the name, schedule, code and tags columns
+func readImportCsv(path string) ([]*lib.Monitor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("the file %s could not be read: %s", path, err.Error())
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("the file %s has no header row: %s", path, err.Error())
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "schedule"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the file %s has no %s column, expecting a header row of name,schedule,code,tags", path, required)
+		}
+	}
+
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var monitors []*lib.Monitor
+	rowsByKey := map[string]int{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV in %s: %s", path, err.Error())
+		}
+
+		name, schedule, code := column(record, "name"), column(record, "schedule"), column(record, "code")
+		if len(name) == 0 && len(schedule) == 0 && len(code) == 0 {
+			continue
+		}
+
+		if len(name) == 0 {
+			return nil, fmt.Errorf("invalid row at %s L%d: a name is required", path, row)
+		}
+
+		if _, err := lib.ParseCronSchedule(schedule); err != nil {
+			return nil, fmt.Errorf("invalid row at %s L%d: %s", path, row, err.Error())
+		}
+
+		key := code
+		if len(key) == 0 {
+			key = fmt.Sprintf("%x", sha1.Sum([]byte("import-"+name)))
+		}
+		if previous, ok := rowsByKey[key]; ok {
+			return nil, fmt.Errorf("invalid row at %s L%d: it is the same monitor as L%d", path, row, previous)
+		}
+		rowsByKey[key] = row
+
+		tags := []string{}
+		for _, tag := range strings.Split(column(record, "tags"), ";") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				tags = append(tags, tag)
+			}
+		}
+
+		monitors = append(monitors, &lib.Monitor{
+			Name:        name,
+			DefaultName: name,
+			Key:         key,
+			Rules:       []lib.Rule{createRule(schedule)},
+			Tags:        tags,
+			Type:        "heartbeat",
+			Code:        code,
+			Timezone:    timezone.Name,
+			Note:        fmt.Sprintf("Imported from %s L%d", path, row),
+		})
+	}
+
+	return monitors, nil
+}
+
+// readImportCrontab reads monitors from a crontab file with the parser used by discover
+func readImportCrontab(path string) ([]*lib.Monitor, error) {
+	var username string
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	crontab := lib.CrontabFactory(username, path)
+	if !crontab.Exists() {
+		return nil, fmt.Errorf("the file %s could not be read", path)
+	}
+
+	if err, _ := crontab.Parse(true); err != nil {
+		return nil, fmt.Errorf("the file %s could not be read: %s", path, err.Error())
+	}
+
+	if crontab.TimezoneLocationName != nil {
+		timezone = *crontab.TimezoneLocationName
+	}
+
+	var monitors []*lib.Monitor
+	allNameCandidates := map[string]bool{}
+	for _, line := range crontab.Lines {
+		if !line.IsMonitorable() || line.IsAutoDiscoverCommand() {
+			continue
+		}
+
+		monitors = append(monitors, &lib.Monitor{
+			DefaultName: createDefaultName(line, crontab, effectiveHostname(), excludeFromName, allNameCandidates),
+			Key:         line.Key(crontab.CanonicalName()),
+			Rules:       []lib.Rule{createRule(line.CronExpression)},
+			Tags:        createTags(),
+			Type:        "heartbeat",
+			Code:        line.Code,
+			Timezone:    timezone.Name,
+			Note:        createNote(line, crontab),
+		})
+	}
+
+	return monitors, nil
+}
+
+func printImportSummary(monitors []*lib.Monitor) {
+	for _, monitor := range monitors {
+		name := monitor.Name
+		if len(name) == 0 {
+			name = monitor.DefaultName
+		}
+
+		code := monitor.Code
+		if len(code) == 0 {
+			code = "new"
+		}
+
+		fmt.Println(fmt.Sprintf("    %s  %s  (%s)", monitor.Rules[0].Value, name, code))
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&importCsvFile, "csv", importCsvFile, "CSV file with name, schedule, code and tags columns")
+	importCmd.Flags().StringVar(&importCrontabFile, "crontab", importCrontabFile, "Crontab file to import without changing it")
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", importDryRun, "Print the monitors that would be imported without contacting Cronitor")
+	importCmd.Flags().StringArrayVarP(&excludeFromName, "exclude-from-name", "e", excludeFromName, "With --crontab, substring to exclude from auto-generated monitor names")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeImportCsv(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "cronitor-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "monitors.csv")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadImportCsv(t *testing.T) {
+	path := writeImportCsv(t, "Schedule,Name,Tags,Code\n"+
+		"0 2 * * *,Nightly backup, backup ; db ,nightly-backup\n"+
+		"\n"+
+		"@hourly,Hourly report,,\n")
+
+	monitors, err := readImportCsv(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(monitors) != 2 {
+		t.Fatalf("expected 2 monitors, got %d", len(monitors))
+	}
+
+	backup := monitors[0]
+	if backup.Name != "Nightly backup" || backup.Key != "nightly-backup" || backup.Code != "nightly-backup" {
+		t.Errorf("unexpected monitor %+v", backup)
+	}
+	if string(backup.Rules[0].Value) != "0 2 * * *" {
+		t.Errorf("expected schedule '0 2 * * *', got '%s'", backup.Rules[0].Value)
+	}
+	if strings.Join(backup.Tags, ",") != "backup,db" {
+		t.Errorf("expected tags backup,db, got %v", backup.Tags)
+	}
+
+	report := monitors[1]
+	if len(report.Code) != 0 || len(report.Key) != 40 {
+		t.Errorf("expected a generated key and no code, got key '%s' code '%s'", report.Key, report.Code)
+	}
+
+	again, _ := readImportCsv(path)
+	if again[1].Key != report.Key {
+		t.Errorf("expected the generated key to be stable, got '%s' and '%s'", report.Key, again[1].Key)
+	}
+}
+
+func TestReadImportCsvRejectsInvalidRows(t *testing.T) {
+	tests := map[string]string{
+		"no schedule column": "name,code\nBackup,backup\n",
+		"missing name":       "name,schedule\n,@daily\n",
+		"invalid schedule":   "name,schedule\nBackup,every day\n",
+		"duplicate code":     "name,schedule,code\nBackup,@daily,backup\nBackup 2,@hourly,backup\n",
+		"duplicate name":     "name,schedule\nBackup,@daily\nBackup,@hourly\n",
+	}
+
+	for name, contents := range tests {
+		if _, err := readImportCsv(writeImportCsv(t, contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
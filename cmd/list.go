@@ -7,8 +7,23 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 	"os/user"
+	"text/template"
 )
 
+// ListJob is the data given to --template for each cron job
+type ListJob struct {
+	Schedule string
+	Command  string
+	Code     string
+	RunAs    string
+	Crontab  string
+	Line     int
+}
+
+var listOutput string
+var listTemplate string
+var listOutputTemplate *template.Template
+
 var listCmd = &cobra.Command{
 	Use:   "list <optional path>",
 	Short: "Search for and list all cron jobs",
@@ -21,8 +36,18 @@ Example:
 
   $ cronitor list /path/to/crontab
       > Instead of the user crontab, list the jobs in a provided a crontab file (or directory of crontabs)
+
+  $ cronitor list --output=template --template '{{.Crontab}}:{{.Line}} {{.Schedule}} {{.Command}}'
+      > Print each cron job with a Go template instead of a table, one line per job. The fields are .Schedule, .Command,
+        .Code (the monitor code of an existing integration), .RunAs (the user of a system crontab job), .Crontab and
+        .Line. Use {{"\t"}} for a tab. A template that cannot be parsed, or uses a field that does not exist, exits
+        with code 64.
 	`,
 	Args: func(cmd *cobra.Command, args []string) error {
+		var err error
+		if listOutputTemplate, err = parseOutputTemplate(listOutput, listTemplate); err != nil {
+			return err
+		}
 
 		return nil
 	},
@@ -55,6 +80,26 @@ Example:
 			return
 		}
 
+		if listOutputTemplate != nil {
+			for _, crontab := range crontabs {
+				for _, line := range crontab.Lines {
+					if len(line.CommandToRun) == 0 {
+						continue
+					}
+
+					printOutputTemplate(listOutputTemplate, ListJob{
+						Schedule: line.CronExpression,
+						Command:  line.CommandToRun,
+						Code:     line.Code,
+						RunAs:    line.RunAs,
+						Crontab:  crontab.DisplayName(),
+						Line:     line.LineNumber,
+					})
+				}
+			}
+			return
+		}
+
 		fmt.Println()
 		for _, crontab := range crontabs {
 			if len(crontab.Lines) == 0 {
@@ -86,4 +131,6 @@ Example:
 
 func init() {
 	RootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&listOutput, "output", "table", "Output format, table or template")
+	listCmd.Flags().StringVar(&listTemplate, "template", listTemplate, "Go template printed for each cron job with --output=template, e.g. '{{.Schedule}} {{.Command}}'")
 }
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var logsLimit int
+var logsSince time.Duration
+var logsFollow bool
+var logsOutput string
+
+type LogEntry struct {
+	Stamp   float64 `json:"stamp"`
+	Series  string  `json:"series"`
+	Message string  `json:"message"`
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <key>",
+	Short: "View job output logs",
+	Long: `
+View the output logs that 'cronitor exec' uploaded for recent runs of a monitor. Log lines are printed oldest first.
+
+Examples:
+  View the most recent log lines:
+  $ cronitor logs d3x0c1
+
+  View up to 500 lines from the last 6 hours:
+  $ cronitor logs d3x0c1 --limit 500 --since 6h
+
+  Keep polling for new log lines until interrupted:
+  $ cronitor logs d3x0c1 --follow
+
+  Print each log line as a JSON object:
+  $ cronitor logs d3x0c1 --output json
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("a unique monitor key is required")
+		}
+
+		if logsOutput != "text" && logsOutput != "json" {
+			return errors.New("invalid argument supplied to 'output'. Expecting 'text' or 'json'")
+		}
+
+		if err := requireApiKey(cmd); err != nil {
+			return err
+		}
+
+		return nil
+	},
+
+	Run: func(cmd *cobra.Command, args []string) {
+		var since float64
+		if logsSince > 0 {
+			since = float64(time.Now().Add(-logsSince).Unix())
+		}
+
+		for {
+			entries, err := fetchLogEntries(args[0], since)
+			if err != nil {
+				if !logsFollow {
+					fatal(err.Error(), exitUnavailable)
+				}
+				log(err.Error())
+			}
+
+			for _, entry := range entries {
+				printLogEntry(entry)
+				if entry.Stamp > since {
+					since = entry.Stamp
+				}
+			}
+
+			if !logsFollow {
+				return
+			}
+
+			time.Sleep(5 * time.Second)
+		}
+	},
+}
+
+func fetchLogEntries(uniqueIdentifier string, since float64) ([]LogEntry, error) {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", logsLimit))
+	if since > 0 {
+		query.Set("since", formatStamp(since))
+	}
+
+	logsUrl := fmt.Sprintf("%s/%s/logs?%s", getCronitorApi().Url(), uniqueIdentifier, query.Encode())
+	response, err := getCronitorApi().GetRawResponse(logsUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Request to %s failed: %s", logsUrl, err)
+	}
+
+	var entries []LogEntry
+	if err := json.Unmarshal(response, &entries); err != nil {
+		return nil, fmt.Errorf("Error from %s: %s", logsUrl, err.Error())
+	}
+
+	// The API returns the most recent lines first, print them in the order they were written
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Stamp < entries[j].Stamp
+	})
+
+	// Since is inclusive, skip lines that were already printed while following
+	var newEntries []LogEntry
+	for _, entry := range entries {
+		if since == 0 || entry.Stamp > since {
+			newEntries = append(newEntries, entry)
+		}
+	}
+
+	return newEntries, nil
+}
+
+func printLogEntry(entry LogEntry) {
+	if logsOutput == "json" {
+		line, _ := json.Marshal(entry)
+		fmt.Println(string(line))
+		return
+	}
+
+	seconds := int64(entry.Stamp)
+	stamp := time.Unix(seconds, int64((entry.Stamp-float64(seconds))*1e9))
+	fmt.Printf("%s  %s  %s\n", stamp.Format(time.RFC3339), entry.Series, entry.Message)
+}
+
+func init() {
+	RootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().IntVar(&logsLimit, "limit", 100, "Maximum number of log lines to fetch")
+	logsCmd.Flags().DurationVar(&logsSince, "since", 0, "Only show log lines newer than this, e.g. 30m or 6h")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Poll for new log lines until interrupted")
+	logsCmd.Flags().StringVar(&logsOutput, "output", "text", "Output format, text or json")
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+)
+
+// execSpan is the span exported by exec --otel-endpoint for a run of the command
+type execSpan struct {
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+}
+
+var traceparentRegex = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// newExecSpan creates the span IDs for a run. When exec is started with a W3C TRACEPARENT in its environment, the
+// span joins that trace as a child of the parent span.
+func newExecSpan() execSpan {
+	span := execSpan{TraceId: randomHex(16), SpanId: randomHex(8)}
+	if matches := traceparentRegex.FindStringSubmatch(strings.TrimSpace(os.Getenv("TRACEPARENT"))); matches != nil {
+		span.TraceId = matches[1]
+		span.ParentSpanId = matches[2]
+	}
+
+	return span
+}
+
+// Traceparent returns the W3C traceparent for the span, passed to the command so its own spans are children of this one
+func (s execSpan) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceId, s.SpanId)
+}
+
+func randomHex(length int) string {
+	b := make([]byte, length)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func stringAttribute(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"stringValue": value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	// OTLP/JSON encodes 64 bit integers as strings
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+// otlpTraceRequest returns the body of an OTLP/HTTP JSON export request with the span for a run of the command
+func otlpTraceRequest(span execSpan, code string, start time.Time, end time.Time, exitCode int, failed bool) map[string]interface{} {
+	status := map[string]interface{}{"code": 1}
+	if failed {
+		status = map[string]interface{}{"code": 2, "message": fmt.Sprintf("exit status %d", exitCode)}
+	}
+
+	otlpSpan := map[string]interface{}{
+		"traceId":           span.TraceId,
+		"spanId":            span.SpanId,
+		"name":              "cronitor exec " + code,
+		"kind":              1,
+		"startTimeUnixNano": strconv.FormatInt(start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+		"attributes": []otlpAttribute{
+			stringAttribute("cronitor.monitor.key", code),
+			stringAttribute("host.name", effectiveHostname()),
+			intAttribute("process.exit.code", int64(exitCode)),
+			intAttribute("cronitor.duration_ms", end.Sub(start).Milliseconds()),
+		},
+		"status": status,
+	}
+	if len(span.ParentSpanId) > 0 {
+		otlpSpan["parentSpanId"] = span.ParentSpanId
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{stringAttribute("service.name", "cronitor")},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "cronitor-cli", "version": Version},
+						"spans": []interface{}{otlpSpan},
+					},
+				},
+			},
+		},
+	}
+}
+
+// exportSpan sends the span to the /v1/traces path of an OTLP/HTTP collector. Headers are read from
+// OTEL_EXPORTER_OTLP_HEADERS, e.g. "api-key=secret,team=ops". Errors are returned for logging only.
+func exportSpan(endpoint string, body map[string]interface{}) error {
+	contents, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(endpoint, "/") + "/v1/traces"
+	request, err := http.NewRequest("POST", url, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", userAgent)
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+			request.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	client := &http.Client{Transport: lib.Transport, Timeout: 5 * time.Second}
+	lib.CountRequest(1)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected %d response from %s", response.StatusCode, url)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewExecSpanJoinsTraceparent(t *testing.T) {
+	os.Setenv("TRACEPARENT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	defer os.Unsetenv("TRACEPARENT")
+
+	span := newExecSpan()
+	if span.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanId != "00f067aa0ba902b7" || len(span.SpanId) != 16 {
+		t.Errorf("Unexpected span: %+v", span)
+	}
+
+	if !strings.HasPrefix(span.Traceparent(), "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanId) {
+		t.Errorf("Unexpected traceparent: %s", span.Traceparent())
+	}
+
+	os.Setenv("TRACEPARENT", "not a traceparent")
+	if span := newExecSpan(); len(span.TraceId) != 32 || len(span.ParentSpanId) != 0 {
+		t.Errorf("Expected a new trace for an invalid traceparent, got %+v", span)
+	}
+}
+
+func TestExportSpan(t *testing.T) {
+	var path, apiKey string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiKey = r.URL.Path, r.Header.Get("api-key")
+		contents, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(contents, &body)
+	}))
+	defer server.Close()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+
+	span := execSpan{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7"}
+	start := time.Unix(1700000000, 0)
+	if err := exportSpan(server.URL+"/", otlpTraceRequest(span, "d3x0c1", start, start.Add(2*time.Second), 3, true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" || apiKey != "secret" {
+		t.Errorf("Unexpected request to %s with api-key %q", path, apiKey)
+	}
+
+	exported := body["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if exported["traceId"] != span.TraceId || exported["endTimeUnixNano"] != "1700000002000000000" || exported["status"].(map[string]interface{})["code"] != float64(2) {
+		t.Errorf("Unexpected span: %v", exported)
+	}
+}
@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"regexp"
 	"sync"
 )
 
@@ -12,6 +16,9 @@ var fail bool
 var tick bool
 var msg string
 var series string
+var pingMetrics map[string]int
+var pingDuration float64
+var exportSeries bool
 
 var pingCmd = &cobra.Command{
 	Use:   "ping <key>",
@@ -33,8 +40,29 @@ Example with a custom message:
 Example when using authenticated ping requests:
   $ cronitor ping d3x0c1 --complete --ping-api-key 9134e94e13a098dbaca57c2df2f2c06f
 
+Example reporting metrics for monitor assertions such as "metric.duration < 60s" or "metric.count > 0":
+  $ cronitor ping d3x0c1 --complete --duration 42.5 --metric count=1200 --metric error_count=0
+
+Example grouping the pings sent by each stage of a script:
+  eval "$(cronitor ping --export-series)"
+  cronitor ping d3x0c1 --run
+  ...
+  cronitor ping d3x0c1 --complete
+
+Retries, clock checks and the other options are described in https://github.com/cronitorio/cronitor-cli/blob/master/docs/ping.md
+
 	`,
 	Args: func(cmd *cobra.Command, args []string) error {
+		if exportSeries {
+			if err := validateExportSeries(); err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				return nil
+			}
+		}
+
 		if len(args) < 1 {
 			return errors.New("a unique monitor key is required")
 		}
@@ -43,15 +71,49 @@ Example when using authenticated ping requests:
 			return errors.New("an endpoint flag is required")
 		}
 
-		return nil
+		if err := requirePingAuthentication(); err != nil {
+			return err
+		}
+
+		if err := requireAllowedCode(args[0]); err != nil {
+			return err
+		}
+
+		for name := range pingMetrics {
+			if name != "count" && name != "error_count" {
+				return fmt.Errorf("unsupported metric '%s', expected count or error_count. Use --duration to report a duration", name)
+			}
+		}
+
+		return applyMonitorRules(cmd, args[0])
 	},
 
 	Run: func(cmd *cobra.Command, args []string) {
 		var wg sync.WaitGroup
 
+		if len(series) == 0 {
+			series = viper.GetString(varSeries)
+		}
+
+		if exportSeries {
+			if len(series) == 0 {
+				series = formatStamp(makeStamp())
+			}
+			fmt.Printf("export CRONITOR_SERIES=%s\n", shellquote.Join(series))
+			if len(args) == 0 {
+				return
+			}
+		}
+
+		var duration *float64
+		if cmd.Flags().Changed("duration") {
+			duration = &pingDuration
+		}
+
 		wg.Add(1)
-		go sendPing(getEndpointFromFlag(), args[0], msg, series, makeStamp(), nil, nil, nil, &wg)
-		wg.Wait()
+		if err := sendPing(getEndpointFromFlag(), args[0], msg, series, makeStamp(), duration, nil, pingMetrics, &wg); err != nil {
+			fatal(err.Error(), exitUnavailable)
+		}
 	},
 }
 
@@ -69,6 +131,23 @@ func getEndpointFromFlag() string {
 	return ""
 }
 
+var exportSeriesRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// validateExportSeries checks the series that --export-series would print, from --series or CRONITOR_SERIES, so only
+// values that need no quoting in a shell or escaping in a ping URL are shared
+func validateExportSeries() error {
+	value := series
+	if len(value) == 0 {
+		value = viper.GetString(varSeries)
+	}
+
+	if len(value) > 0 && !exportSeriesRegex.MatchString(value) {
+		return fmt.Errorf("invalid series %q for --export-series. Expecting up to 128 letters, digits, '.', '_', ':' or '-'", value)
+	}
+
+	return nil
+}
+
 func init() {
 	RootCmd.AddCommand(pingCmd)
 	pingCmd.Flags().BoolVar(&run, "run", false, "Report job is running")
@@ -77,4 +156,7 @@ func init() {
 	pingCmd.Flags().BoolVar(&tick, "tick", false, "Send a heartbeat")
 	pingCmd.Flags().StringVar(&msg, "msg", "", "Optional message to send with ping")
 	pingCmd.Flags().StringVar(&series, "series", "", "Optional unique user-supplied ID to collate related pings")
+	pingCmd.Flags().BoolVar(&exportSeries, "export-series", false, "Print an \"export CRONITOR_SERIES=...\" line for a shell to eval, so later pings share a series. The series may only contain letters, digits, '.', '_', ':' and '-'")
+	pingCmd.Flags().Float64Var(&pingDuration, "duration", 0, "Optional job duration in seconds")
+	pingCmd.Flags().StringToIntVar(&pingMetrics, "metric", nil, "Optional metric to send with ping, e.g. count=100 or error_count=0. Can be repeated")
 }
@@ -1,21 +1,31 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/cronitorio/cronitor-cli/lib"
 	"io/ioutil"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
 	"github.com/getsentry/raven-go"
@@ -26,6 +36,9 @@ import (
 var Version string = "30.3"
 
 var cfgFile string
+var configFormat string
+var noEnvExpand bool
+var strictEnvExpand bool
 var userAgent string
 
 // Flags that are either global or used in multiple commands
@@ -35,8 +48,71 @@ var debugLog string
 var dev bool
 var hostname string
 var pingApiKey string
+var pingHost string
+var apiUrl string
+var noFallbackHost bool
+var noPingTimezone bool
+var messagePrefix string
+var messageSuffix string
+var maxMessageBytes int
+var trace bool
+var http1Only bool
+var insecureSkipVerify bool
+var requireTLS13 bool
+var requestId string
+var pingParams []string
+var headerFlags []string
+var customHeaders http.Header
+var fastFailOffline bool
+var pingApiKeyRequired bool
+var userAgentSuffix string
+var reportMetadata bool
+var cacheTTL time.Duration
+var noCache bool
+var checkClock bool
+var strictClock bool
+var maxClockSkew time.Duration
+var apiBackoffAttempts int
+var apiBackoffBase time.Duration
+var apiBackoffMax time.Duration
+var apiBackoffMaxElapsed time.Duration
+var pageConcurrency int
 var verbose bool
 var noStdoutPassthru bool
+var pingHmacSecret string
+var rulesFile string
+var pingEndpointMap map[string]string
+var socks5Proxy string
+var pingTimeout time.Duration
+var pingRetries int
+var durationPrecision int
+var runPingTimeout, completePingTimeout, failPingTimeout time.Duration
+var runPingRetries, completePingRetries, failPingRetries int
+var allowedCodes []string
+var allowedCodesFile string
+var extraHostTags []string
+
+// pingBackoff is used between ping retries after the first two attempts
+var pingBackoff = lib.Backoff{Base: 4 * time.Second, Max: 10 * time.Second}
+
+// pingRetryAfterMax is the longest Retry-After a rate limited ping waits for before it is retried. A longer wait would
+// hold up the job, so the ping is given up instead.
+const pingRetryAfterMax = time.Minute
+
+// Exit codes used by CronitorCLI itself. The codes above 1 follow sysexits.h. The exec command passes through
+// the exit code of the command it runs, so these only describe exec when the command could not be run.
+const (
+	exitSuccess     = 0
+	exitFailure     = 1   // The command ran but reported a failure, e.g. a failed doctor check
+	exitUsage       = 64  // Invalid flags or arguments
+	exitNoInput     = 66  // An input file could not be read
+	exitUnavailable = 69  // Cronitor could not be reached or returned an error
+	exitInternal    = 70  // An unexpected internal error
+	exitCantCreate  = 73  // An output file could not be written
+	exitTempFail    = 75  // The command was not run this time but can be retried, e.g. when skipped by --max-concurrent-exec
+	exitTimeout     = 124 // A timeout was reached
+	exitInterrupted = 130 // Stopped by the user with Ctrl+C, following the shell convention of 128 + SIGINT
+)
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -44,14 +120,34 @@ var RootCmd = &cobra.Command{
 	Short: shortDescription(Version),
 	Long: shortDescription(Version) + `
 
-Command line tools for Cronitor.io. See https://cronitor.io/docs/using-cronitor-cli for details.`,
+Command line tools for Cronitor.io. See https://cronitor.io/docs/using-cronitor-cli for details.
+
+Exit codes:
+  0     Success
+  1     The command ran but reported a failure, e.g. a failed doctor check
+  64    Invalid flags or arguments
+  66    An input file could not be read
+  69    Cronitor could not be reached or returned an error
+  70    An unexpected internal error
+  73    An output file could not be written
+  75    The command was skipped by exec --max-concurrent-exec
+  124   A timeout was reached
+  130   Stopped by the user with Ctrl+C in an interactive command, e.g. select or shell
+  The exec command exits with the exit code of the command it runs. The codes above are used by exec only when the
+  command could not be run, or when --fail-on-ping-error is set and a ping could not be delivered.
+
+Config files, environment variables, proxies, retries and the other global options are described in
+https://github.com/cronitorio/cronitor-cli/blob/master/docs/configuration.md`,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		logTransferSummary()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fatal(err.Error(), 1)
+		fatal(err.Error(), exitUsage)
 	}
 }
 
@@ -62,6 +158,34 @@ var varLog = "CRONITOR_LOG"
 var varPingApiKey = "CRONITOR_PING_API_KEY"
 var varExcludeText = "CRONITOR_EXCLUDE_TEXT"
 var varConfig = "CRONITOR_CONFIG"
+var varPingHost = "CRONITOR_PING_HOST"
+var varApiUrl = "CRONITOR_API_URL"
+var varMessagePrefix = "CRONITOR_MESSAGE_PREFIX"
+var varMessageSuffix = "CRONITOR_MESSAGE_SUFFIX"
+var varMaxMessageBytes = "CRONITOR_MAX_MESSAGE_BYTES"
+var varUserAgentSuffix = "CRONITOR_USER_AGENT_SUFFIX"
+var varSeries = "CRONITOR_SERIES"
+var varPingApiKeyRequired = "CRONITOR_PING_API_KEY_REQUIRED"
+var varConfigFormat = "CRONITOR_CONFIG_FORMAT"
+var varHeaders = "CRONITOR_HEADERS"
+var varHttp1Only = "CRONITOR_HTTP1_ONLY"
+var varRequireTLS13 = "CRONITOR_REQUIRE_TLS13"
+var varPingHmacSecret = "CRONITOR_PING_HMAC_SECRET"
+var varRulesFile = "CRONITOR_RULES_FILE"
+var varPingEndpointMap = "CRONITOR_PING_ENDPOINT_MAP"
+var varSocks5 = "CRONITOR_SOCKS5"
+var varPingTimeout = "CRONITOR_PING_TIMEOUT"
+var varPingRetries = "CRONITOR_PING_RETRIES"
+var varRunPingTimeout = "CRONITOR_RUN_PING_TIMEOUT"
+var varRunPingRetries = "CRONITOR_RUN_PING_RETRIES"
+var varCompletePingTimeout = "CRONITOR_COMPLETE_PING_TIMEOUT"
+var varCompletePingRetries = "CRONITOR_COMPLETE_PING_RETRIES"
+var varFailPingTimeout = "CRONITOR_FAIL_PING_TIMEOUT"
+var varFailPingRetries = "CRONITOR_FAIL_PING_RETRIES"
+var varDurationPrecision = "CRONITOR_DURATION_PRECISION"
+var varAllowedCodes = "CRONITOR_ALLOWED_CODES"
+var varAllowedCodesFile = "CRONITOR_ALLOWED_CODES_FILE"
+var varExtraHostTags = "CRONITOR_EXTRA_HOST_TAGS"
 
 func init() {
 	userAgent = fmt.Sprintf("CronitorCLI/%s", Version)
@@ -71,12 +195,58 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", cfgFile, "Config file")
+	RootCmd.PersistentFlags().StringVar(&configFormat, "config-format", configFormat, "Format of the --config file, json, yaml or toml, when it cannot be inferred from the file extension")
+	RootCmd.PersistentFlags().BoolVar(&noEnvExpand, "no-env-expand", noEnvExpand, "Use config file values literally instead of expanding $VAR and ${VAR} references to environment variables")
+	RootCmd.PersistentFlags().BoolVar(&strictEnvExpand, "strict-env-expand", strictEnvExpand, "Exit with an error when a config file value references an undefined environment variable")
 	RootCmd.PersistentFlags().StringVar(&environment, "env", environment, "Cronitor Environment")
 	RootCmd.PersistentFlags().StringVarP(&apiKey, "api-key", "k", apiKey, "Cronitor API Key")
 	RootCmd.PersistentFlags().StringVarP(&pingApiKey, "ping-api-key", "p", pingApiKey, "Ping API Key")
 	RootCmd.PersistentFlags().StringVarP(&hostname, "hostname", "n", hostname, "A unique identifier for this host (default: system hostname)")
+	RootCmd.PersistentFlags().StringSliceVar(&extraHostTags, "extra-host-tag", extraHostTags, "Another identity of this host to send with pings alongside --hostname, e.g. node=worker-3. Comma-separated or repeated")
 	RootCmd.PersistentFlags().StringVarP(&debugLog, "log", "l", debugLog, "Write debug logs to supplied file")
-	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", verbose, "Verbose output")
+	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", verbose, "Verbose output, and a summary of the requests made before exiting")
+	RootCmd.PersistentFlags().StringVar(&apiUrl, "api-url", apiUrl, "Send API requests to this base URL instead of https://cronitor.io/v3")
+	RootCmd.PersistentFlags().StringVar(&pingHost, "ping-host", pingHost, "Send pings to this host instead of https://cronitor.link")
+	RootCmd.PersistentFlags().StringVar(&pingHmacSecret, "ping-hmac-secret", pingHmacSecret, "Sign pings sent to a custom --ping-host with an HMAC-SHA256 of the ping URL, sent as the sig parameter")
+	RootCmd.PersistentFlags().StringToStringVar(&pingEndpointMap, "ping-endpoint-map", pingEndpointMap, "Rename ping endpoints in the ping URL for a custom --ping-host, e.g. run=start,complete=ok,fail=err")
+	RootCmd.PersistentFlags().DurationVar(&pingTimeout, "ping-timeout", 10*time.Second, "Timeout of each ping attempt")
+	RootCmd.PersistentFlags().IntVar(&pingRetries, "ping-retries", 5, "Number of times to retry a ping that could not be delivered")
+	RootCmd.PersistentFlags().DurationVar(&runPingTimeout, "run-ping-timeout", 0, "Timeout of each run ping attempt, or 0 to use --ping-timeout")
+	RootCmd.PersistentFlags().IntVar(&runPingRetries, "run-ping-retries", -1, "Number of times to retry a run ping, or -1 to use --ping-retries")
+	RootCmd.PersistentFlags().DurationVar(&completePingTimeout, "complete-ping-timeout", 0, "Timeout of each complete ping attempt, or 0 to use --ping-timeout")
+	RootCmd.PersistentFlags().IntVar(&completePingRetries, "complete-ping-retries", -1, "Number of times to retry a complete ping, or -1 to use --ping-retries")
+	RootCmd.PersistentFlags().DurationVar(&failPingTimeout, "fail-ping-timeout", 0, "Timeout of each fail ping attempt, or 0 to use --ping-timeout")
+	RootCmd.PersistentFlags().IntVar(&failPingRetries, "fail-ping-retries", -1, "Number of times to retry a fail ping, or -1 to use --ping-retries")
+	RootCmd.PersistentFlags().IntVar(&durationPrecision, "duration-precision", 3, "Number of decimal places in the duration sent with a ping, from 0 for whole seconds to 9")
+	RootCmd.PersistentFlags().StringSliceVar(&allowedCodes, "allowed-codes", allowedCodes, "Comma-separated monitor keys, or patterns like backup-*, that exec and ping may send pings for")
+	RootCmd.PersistentFlags().StringVar(&allowedCodesFile, "allowed-codes-file", allowedCodesFile, "File of monitor keys or patterns, one per line, that exec and ping may send pings for")
+	RootCmd.PersistentFlags().StringVar(&rulesFile, "rules-file", rulesFile, "YAML file with per-monitor ping timeouts, retries, env, tags and message prefixes for exec and ping")
+	RootCmd.PersistentFlags().StringVar(&messagePrefix, "message-prefix", messagePrefix, "Text to prepend to every ping message")
+	RootCmd.PersistentFlags().StringVar(&messageSuffix, "message-suffix", messageSuffix, "Text to append to every ping message")
+	RootCmd.PersistentFlags().IntVar(&maxMessageBytes, "max-message-bytes", 1000, "Maximum length of a ping message; longer messages are truncated")
+	RootCmd.PersistentFlags().BoolVar(&http1Only, "http1-only", http1Only, "Disable HTTP/2 and send all requests over HTTP/1.1")
+	RootCmd.PersistentFlags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", insecureSkipVerify, "Do not verify TLS certificates. For testing against internal relays only, never use with the public Cronitor endpoints")
+	RootCmd.PersistentFlags().StringVar(&socks5Proxy, "socks5", socks5Proxy, "Send all requests through this SOCKS5 proxy, host:port or user:password@host:port")
+	RootCmd.PersistentFlags().BoolVar(&requireTLS13, "require-tls13", requireTLS13, "Only connect using TLS 1.3 and refuse to send requests over plain HTTP")
+	RootCmd.PersistentFlags().BoolVar(&trace, "trace", trace, "Send a unique X-Cronitor-Request-Id header with every request and include it in log lines")
+	RootCmd.PersistentFlags().BoolVar(&noPingTimezone, "no-ping-timezone", noPingTimezone, "Do not send the timezone of this host with run pings")
+	RootCmd.PersistentFlags().BoolVar(&noFallbackHost, "no-fallback-host", noFallbackHost, "Send every ping attempt to the primary ping host, never falling back to https://cronitor.io")
+	RootCmd.PersistentFlags().StringVar(&userAgentSuffix, "user-agent-suffix", userAgentSuffix, "Text to append to the User-Agent header, e.g. \"AcmeDeployer/2.1\"")
+	RootCmd.PersistentFlags().IntVar(&apiBackoffAttempts, "api-backoff-attempts", 3, "Number of times to attempt an API request that fails with a network error, 429 or 5xx response")
+	RootCmd.PersistentFlags().DurationVar(&apiBackoffBase, "api-backoff-base", time.Second, "Maximum delay before the first API retry, doubled for each later retry")
+	RootCmd.PersistentFlags().DurationVar(&apiBackoffMax, "api-backoff-max", 30*time.Second, "Maximum delay between API retries")
+	RootCmd.PersistentFlags().DurationVar(&apiBackoffMaxElapsed, "api-backoff-max-elapsed", 2*time.Minute, "Stop retrying an API request after this much time has passed")
+	RootCmd.PersistentFlags().IntVar(&pageConcurrency, "page-concurrency", 3, "Number of monitor list pages to fetch at a time, from 1 to 10")
+	RootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", time.Minute, "How long to reuse a cached monitor list")
+	RootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", noCache, "Always fetch the monitor list from Cronitor instead of using the cache")
+	RootCmd.PersistentFlags().BoolVar(&reportMetadata, "report-metadata", reportMetadata, "Append the process ID, parent process ID and command line, with secrets redacted, to ping messages")
+	RootCmd.PersistentFlags().BoolVar(&checkClock, "check-clock", checkClock, "Compare the local clock with the ping host before sending pings and warn when it is skewed")
+	RootCmd.PersistentFlags().BoolVar(&strictClock, "strict-clock", strictClock, "Like --check-clock, but do not send pings when the local clock is skewed")
+	RootCmd.PersistentFlags().DurationVar(&maxClockSkew, "max-clock-skew", time.Minute, "Clock skew allowed by --check-clock and --strict-clock")
+	RootCmd.PersistentFlags().BoolVar(&pingApiKeyRequired, "ping-api-key-required", pingApiKeyRequired, "Refuse to send unauthenticated pings and exit nonzero when no ping API key or API key is configured")
+	RootCmd.PersistentFlags().BoolVar(&fastFailOffline, "fast-fail-offline", fastFailOffline, "Check that the ping host is reachable before sending a ping and fail immediately instead of retrying when it is not")
+	RootCmd.PersistentFlags().StringArrayVar(&headerFlags, "header", headerFlags, "Add a \"Name: Value\" header to every ping and API request. Can be repeated")
+	RootCmd.PersistentFlags().StringArrayVar(&pingParams, "ping-param", pingParams, "Advanced: append key=value to every ping URL. Can be repeated. Unsafe, values are sent as-is to the ping API")
 
 	RootCmd.PersistentFlags().BoolVar(&dev, "use-dev", dev, "Dev mode")
 	RootCmd.PersistentFlags().MarkHidden("use-dev")
@@ -87,36 +257,154 @@ func init() {
 	viper.BindPFlag(varLog, RootCmd.PersistentFlags().Lookup("log"))
 	viper.BindPFlag(varPingApiKey, RootCmd.PersistentFlags().Lookup("ping-api-key"))
 	viper.BindPFlag(varConfig, RootCmd.PersistentFlags().Lookup("config"))
+	viper.BindPFlag(varConfigFormat, RootCmd.PersistentFlags().Lookup("config-format"))
+	viper.BindPFlag(varPingHost, RootCmd.PersistentFlags().Lookup("ping-host"))
+	viper.BindPFlag(varApiUrl, RootCmd.PersistentFlags().Lookup("api-url"))
+	viper.BindPFlag(varPingHmacSecret, RootCmd.PersistentFlags().Lookup("ping-hmac-secret"))
+	viper.BindPFlag(varPingTimeout, RootCmd.PersistentFlags().Lookup("ping-timeout"))
+	viper.BindPFlag(varPingRetries, RootCmd.PersistentFlags().Lookup("ping-retries"))
+	viper.BindPFlag(varRunPingTimeout, RootCmd.PersistentFlags().Lookup("run-ping-timeout"))
+	viper.BindPFlag(varRunPingRetries, RootCmd.PersistentFlags().Lookup("run-ping-retries"))
+	viper.BindPFlag(varCompletePingTimeout, RootCmd.PersistentFlags().Lookup("complete-ping-timeout"))
+	viper.BindPFlag(varCompletePingRetries, RootCmd.PersistentFlags().Lookup("complete-ping-retries"))
+	viper.BindPFlag(varFailPingTimeout, RootCmd.PersistentFlags().Lookup("fail-ping-timeout"))
+	viper.BindPFlag(varFailPingRetries, RootCmd.PersistentFlags().Lookup("fail-ping-retries"))
+	viper.BindPFlag(varRulesFile, RootCmd.PersistentFlags().Lookup("rules-file"))
+	viper.BindPFlag(varDurationPrecision, RootCmd.PersistentFlags().Lookup("duration-precision"))
+	viper.BindPFlag(varAllowedCodes, RootCmd.PersistentFlags().Lookup("allowed-codes"))
+	viper.BindPFlag(varAllowedCodesFile, RootCmd.PersistentFlags().Lookup("allowed-codes-file"))
+	viper.BindPFlag(varExtraHostTags, RootCmd.PersistentFlags().Lookup("extra-host-tag"))
+	viper.BindPFlag(varPingEndpointMap, RootCmd.PersistentFlags().Lookup("ping-endpoint-map"))
+	viper.BindPFlag(varMessagePrefix, RootCmd.PersistentFlags().Lookup("message-prefix"))
+	viper.BindPFlag(varMessageSuffix, RootCmd.PersistentFlags().Lookup("message-suffix"))
+	viper.BindPFlag(varMaxMessageBytes, RootCmd.PersistentFlags().Lookup("max-message-bytes"))
+	viper.BindPFlag(varUserAgentSuffix, RootCmd.PersistentFlags().Lookup("user-agent-suffix"))
+	viper.BindPFlag(varPingApiKeyRequired, RootCmd.PersistentFlags().Lookup("ping-api-key-required"))
+	viper.BindPFlag(varHttp1Only, RootCmd.PersistentFlags().Lookup("http1-only"))
+	viper.BindPFlag(varRequireTLS13, RootCmd.PersistentFlags().Lookup("require-tls13"))
+	viper.BindPFlag(varSocks5, RootCmd.PersistentFlags().Lookup("socks5"))
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	if trace {
+		requestId = makeRequestId()
+	}
+
+	if insecureSkipVerify {
+		color.New(color.FgHiRed).Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled (--insecure-skip-verify). Never use this in production.")
+		lib.DisableTLSVerification()
+	}
 
 	viper.AutomaticEnv() // read in environment variables that match
 	configFile := viper.GetString(varConfig)
+	format := strings.ToLower(viper.GetString(varConfigFormat))
+
+	if len(format) > 0 {
+		if format != "json" && format != "yaml" && format != "toml" {
+			fatal("invalid argument supplied to 'config-format'. Expecting 'json', 'yaml' or 'toml'", exitUsage)
+		}
+		if len(configFile) == 0 {
+			fatal("--config-format can only be used with --config or CRONITOR_CONFIG", exitUsage)
+		}
+	}
+
+	if noEnvExpand && strictEnvExpand {
+		fatal("--no-env-expand and --strict-env-expand cannot be used together", exitUsage)
+	}
 
 	// If a custom config file is specified by flag or env var, use it. Otherwise use default file.
 	if len(configFile) > 0 {
-		if len(configFile) < 5 || strings.ToLower(configFile[len(configFile)-5:]) != ".json" {
-			fmt.Println("Error: Config file must be a .json file")
+		if len(format) > 0 {
+			viper.SetConfigType(format)
+		} else if len(configFileFormat(configFile)) == 0 {
+			fmt.Println("Error: Config file must be a .json, .toml or .yaml file, or use --config-format to set the format of the file")
 		}
 		viper.SetConfigFile(configFile)
-	} else {
-		viper.AddConfigPath(defaultConfigFileDirectory())
-		viper.SetConfigName("cronitor")
+	} else if found, ignored := findConfigFile(configFileDirectories()); len(found) > 0 {
+		for _, file := range ignored {
+			log(fmt.Sprintf("Ignoring %s because %s takes precedence", file, found))
+		}
+		viper.SetConfigFile(found)
 	}
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		log("Reading config from " + viper.ConfigFileUsed())
+		if !noEnvExpand {
+			expandConfigEnv(format)
+		}
+	} else if _, statErr := os.Stat(configFile); len(format) > 0 && statErr == nil {
+		fatal(fmt.Sprintf("Config file %s could not be parsed as %s: %s", configFile, format, err.Error()), exitUsage)
+	}
+
+	// Network options can be set in the config file, so they are applied once it has been read
+	if viper.GetBool(varHttp1Only) {
+		lib.DisableHTTP2()
+	}
+
+	if proxy := effectiveSocks5Proxy(); len(proxy) > 0 {
+		if err := lib.UseSocks5Proxy(proxy); err != nil {
+			fatal(err.Error(), exitUsage)
+		}
+		log("Sending requests through a SOCKS5 proxy")
+	}
+
+	if viper.GetBool(varRequireTLS13) {
+		if dev {
+			fatal("--use-dev sends requests over plain HTTP and cannot be used with --require-tls13", exitUsage)
+		}
+		lib.RequireTLS13()
+	}
+
+	readSystemdCredential(varApiKey, "api-key", "cronitor-api-key")
+	readSystemdCredential(varPingApiKey, "ping-api-key", "cronitor-ping-api-key")
+
+	if customApiUrl := viper.GetString(varApiUrl); len(customApiUrl) > 0 {
+		normalizedApiUrl, err := lib.NormalizeApiUrl(customApiUrl)
+		if err != nil {
+			fatal(err.Error(), exitUsage)
+		}
+		viper.Set(varApiUrl, normalizedApiUrl)
+	}
+
+	if suffix := sanitizeUserAgentSuffix(viper.GetString(varUserAgentSuffix)); len(suffix) > 0 {
+		userAgent = fmt.Sprintf("CronitorCLI/%s %s", Version, suffix)
+	}
+
+	if _, err := formatPingParams(pingParams); err != nil {
+		fatal(err.Error(), exitUsage)
+	}
+
+	if err := validatePingEndpointMap(viper.GetStringMapString(varPingEndpointMap)); err != nil {
+		fatal(err.Error(), exitUsage)
+	}
+
+	if precision := viper.GetInt(varDurationPrecision); precision < 0 || precision > 9 {
+		fatal(fmt.Sprintf("invalid --duration-precision %d, expecting a number of decimal places from 0 to 9", precision), exitUsage)
+	}
+
+	if pageConcurrency < 1 || pageConcurrency > 10 {
+		fatal(fmt.Sprintf("invalid --page-concurrency %d, expecting a number of pages from 1 to 10", pageConcurrency), exitUsage)
+	}
+
+	if len(viper.GetString(varPingHmacSecret)) > 0 && len(viper.GetString(varPingHost)) == 0 {
+		color.New(color.FgHiYellow).Fprintln(os.Stderr, "WARNING: --ping-hmac-secret is only used with a custom --ping-host. Pings to Cronitor are not signed.")
+	}
+
+	if headers, err := parseHeaders(effectiveHeaderLines()); err != nil {
+		fatal(err.Error(), exitUsage)
+	} else {
+		customHeaders = headers
 	}
 }
 
-func sendPing(endpoint string, uniqueIdentifier string, message string, series string, timestamp float64, duration *float64, exitCode *int, metrics map[string]int, group *sync.WaitGroup) {
+func sendPing(endpoint string, uniqueIdentifier string, message string, series string, timestamp float64, duration *float64, exitCode *int, metrics map[string]int, group *sync.WaitGroup) error {
 	defer group.Done()
 
 	Client := &http.Client{
-		Timeout: time.Second * 10,
+		Transport: lib.Transport,
+		Timeout:   endpointPingTimeout(endpoint),
 	}
 
 	hostname := effectiveHostname()
@@ -129,26 +417,34 @@ func sendPing(endpoint string, uniqueIdentifier string, message string, series s
 	formattedDuration := ""
 	formattedStatusCode := ""
 	formattedMetrics := ""
+	formattedTimezone := ""
+	formattedParams, _ := formatPingParams(pingParams)
 
 	if timestamp > 0 {
 		formattedStamp = fmt.Sprintf("&stamp=%s", formatStamp(timestamp))
 	}
 
+	message = formatMessage(message, effectiveMaxMessageBytes())
 	if len(message) > 0 {
-		message = fmt.Sprintf("&msg=%s", url.QueryEscape(truncateString(message, 1000)))
+		message = fmt.Sprintf("&msg=%s", url.QueryEscape(message))
 	}
 
 	if len(hostname) > 0 {
 		hostname = fmt.Sprintf("&host=%s", url.QueryEscape(truncateString(hostname, 50)))
 	}
 
+	// Other identities of the host, e.g. the node a container runs on, are sent alongside it with the same limit
+	for _, tag := range effectiveExtraHostTags() {
+		hostname += fmt.Sprintf("&host_tag=%s", url.QueryEscape(truncateString(tag, 50)))
+	}
+
 	if len(env) > 0 {
 		env = fmt.Sprintf("&env=%s", url.QueryEscape(truncateString(env, 50)))
 	}
 
 	// By passing duration up, we save the computation on the server side
 	if duration != nil {
-		formattedDuration = fmt.Sprintf("&duration=%s", formatStamp(*duration))
+		formattedDuration = fmt.Sprintf("&duration=%s", formatDuration(*duration))
 	}
 
 	// We aren't using exit code at time of writing, but we have the field available for healthcheck monitors.
@@ -158,7 +454,14 @@ func sendPing(endpoint string, uniqueIdentifier string, message string, series s
 
 	// The `series` data is used to match run events with complete or fail. Useful if multiple instances of a job are running.
 	if len(series) > 0 {
-		series = fmt.Sprintf("&series=%s", series)
+		series = fmt.Sprintf("&series=%s", url.QueryEscape(series))
+	}
+
+	// The host timezone lets Cronitor reconcile schedules when it differs from the monitor timezone
+	if endpoint == "run" && !noPingTimezone {
+		if tz := pingTimezone(); len(tz) > 0 {
+			formattedTimezone = fmt.Sprintf("&tz=%s", url.QueryEscape(tz))
+		}
 	}
 
 	if metrics != nil && len(metrics) > 0 {
@@ -176,53 +479,101 @@ func sendPing(endpoint string, uniqueIdentifier string, message string, series s
 		authenticationKey = apiKey
 	}
 
+	if err := requirePingAuthentication(); err != nil {
+		log("Cannot send ping: " + err.Error())
+		return err
+	}
+
+	if err := requireAllowedCode(uniqueIdentifier); err != nil {
+		log("Cannot send ping: " + err.Error())
+		return err
+	}
+
 	// If we don't have any authentication key we will need to send an unauthenticated ping.
 	// This requires that we have a GUID "monitor code" not a per-user "monitor key"
 	if len(authenticationKey) == 0 {
 		monitorCodeRegex := regexp.MustCompile(`^[A-Za-z0-9]{3,12}$`)
 		if ret := monitorCodeRegex.FindStringSubmatch(uniqueIdentifier); ret == nil {
 			log("Cannot send ping: you must provide a valid API key with this command or save a key using 'cronitor configure'")
-			return
+			return errors.New("cannot send ping: you must provide a valid API key with this command or save a key using 'cronitor configure'")
 		}
 	}
 
-	pingSent := false
-	uri := ""
-	for i := 1; i <= 6; i++ {
-		if dev {
-			pingApiHost = "http://localhost:8000"
-		} else if i > 2 && pingApiHost == "https://cronitor.link" {
-			pingApiHost = "https://cronitor.io"
-		} else {
-			pingApiHost = "https://cronitor.link"
+	if checkClock || strictClock {
+		if err := checkClockSkewOnce(pingApiHostForAttempt(1)); err != nil && strictClock {
+			return fmt.Errorf("ping was not sent: %s", err.Error())
 		}
+	}
 
-		// After 2 failed attempts, take a brief random break before trying again
+	if fastFailOffline {
+		if err := probePingHost(pingApiHostForAttempt(1)); err != nil {
+			log(fmt.Sprintf("Ping host is unreachable, not sending ping: %s", err.Error()))
+			return fmt.Errorf("ping could not be delivered: ping host is unreachable: %s", err.Error())
+		}
+	}
+
+	urlEndpoint := mappedPingEndpoint(endpoint)
+	pingSent := false
+	uri := ""
+	attempts := 0
+	statusCode := 0
+	clientError := false
+	retryAfter := time.Duration(0)
+	var pingErr error
+	for i := 1; i <= endpointPingRetries(endpoint)+1; i++ {
+		attempts = i
+		statusCode = 0
+		pingApiHost = pingApiHostForAttempt(i)
+
+		// After 2 failed attempts, take a brief random break before trying again. A rate limited ping waits at least
+		// as long as the Retry-After of the response.
+		delay := retryAfter
 		if i > 2 {
-			time.Sleep(time.Second * time.Duration(float32(i)*1.5*rand.Float32()))
+			if backoff := pingBackoff.Delay(i - 2); backoff > delay {
+				delay = backoff
+			}
 		}
+		time.Sleep(delay)
+		retryAfter = 0
 
 		if len(authenticationKey) > 0 {
 			// Authenticated pings when available
-			uri = fmt.Sprintf("%s/ping/%s/%s?state=%s&try=%d%s%s%s%s%s%s%s%s", pingApiHost, authenticationKey, uniqueIdentifier, endpoint, i, formattedStamp, message, hostname, formattedDuration, series, formattedStatusCode, formattedMetrics, env)
+			uri = fmt.Sprintf("%s/ping/%s/%s?state=%s&try=%d%s%s%s%s%s%s%s%s%s%s", pingApiHost, authenticationKey, uniqueIdentifier, urlEndpoint, i, formattedStamp, message, hostname, formattedDuration, series, formattedStatusCode, formattedMetrics, formattedTimezone, env, formattedParams)
 		} else {
 			// Fallback to sending an unauthenticated ping
-			uri = fmt.Sprintf("%s/%s/%s?try=%d%s%s%s%s%s%s%s%s", pingApiHost, uniqueIdentifier, endpoint, i, formattedStamp, message, hostname, formattedDuration, series, formattedStatusCode, formattedMetrics, env)
+			uri = fmt.Sprintf("%s/%s/%s?try=%d%s%s%s%s%s%s%s%s%s%s", pingApiHost, uniqueIdentifier, urlEndpoint, i, formattedStamp, message, hostname, formattedDuration, series, formattedStatusCode, formattedMetrics, formattedTimezone, env, formattedParams)
+		}
+
+		// Only a relay set with --ping-host can verify the signature, so pings to Cronitor are never signed
+		if secret := viper.GetString(varPingHmacSecret); len(secret) > 0 && len(viper.GetString(varPingHost)) > 0 && !dev {
+			uri = signPingUrl(uri, secret)
 		}
 
 		log("Sending ping " + uri)
 
 		request, _ := http.NewRequest("GET", uri, nil)
+		lib.AddHeaders(request, customHeaders)
 		request.Header.Add("User-Agent", userAgent)
+		if len(requestId) > 0 {
+			request.Header.Add("X-Cronitor-Request-Id", requestId)
+		}
+		lib.CountRequest(i)
 		response, err := Client.Do(request)
 
 		if err != nil {
 			log(err.Error())
+			pingErr = err
+			// Don't surface the request URL, it contains the authentication key
+			if urlErr, ok := err.(*url.Error); ok {
+				pingErr = urlErr.Err
+			}
 			continue
 		}
 
 		_, err = ioutil.ReadAll(response.Body)
 		response.Body.Close()
+		log(fmt.Sprintf("Received %d ping response over %s", response.StatusCode, response.Proto))
+		statusCode = response.StatusCode
 
 		// Any 2xx is considered a successful response
 		if response.StatusCode >= 200 && response.StatusCode < 300 {
@@ -230,18 +581,308 @@ func sendPing(endpoint string, uniqueIdentifier string, message string, series s
 			break
 		}
 
-		// Backoff on any 4xx request, e.g. 429 Too Many Requests
-		if response.StatusCode >= 400 && response.StatusCode < 500 {
-			pingSent = false
+		pingErr = fmt.Errorf("unexpected %d ping response", response.StatusCode)
+
+		if response.StatusCode == http.StatusTooManyRequests {
+			retryAfter = lib.ParseRetryAfter(response.Header.Get("Retry-After"))
+			if retryAfter == 0 {
+				retryAfter = pingBackoff.Delay(i)
+			} else if retryAfter > pingRetryAfterMax {
+				log(fmt.Sprintf("Not retrying after a 429 ping response with a Retry-After of %s", retryAfter))
+				clientError = true
+				break
+			}
+			continue
+		}
+
+		// Other 4xx responses will not change on retry, e.g. a monitor code that does not exist, so only 408, 429, 5xx
+		// responses and network errors are retried
+		if response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusRequestTimeout {
+			if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+				pingErr = fmt.Errorf("monitor code not found: %s (%d response)", uniqueIdentifier, response.StatusCode)
+			}
+			log(fmt.Sprintf("Not retrying after a %d ping response", response.StatusCode))
+			clientError = true
 			break
 		}
 	}
 
+	if len(dumpPingFile) > 0 {
+		dumpPing(dumpPingFile, newPingRecord(endpoint, uri, authenticationKey, statusCode, attempts, pingSent))
+	}
+
 	if !pingSent {
-		raven.CaptureErrorAndWait(errors.New("Ping failure; retries exhausted: "+uri), nil)
+		if !clientError {
+			raven.CaptureErrorAndWait(errors.New("Ping failure; retries exhausted: "+uri), nil)
+		}
+		if pingErr == nil {
+			pingErr = errors.New("retries exhausted")
+		}
+		return fmt.Errorf("ping could not be delivered: %s", pingErr.Error())
+	}
+
+	return nil
+}
+
+// endpointPingSettings are the settings that override --ping-timeout and --ping-retries for an endpoint
+var endpointPingSettings = map[string]struct{ timeout, retries string }{
+	"run":      {varRunPingTimeout, varRunPingRetries},
+	"complete": {varCompletePingTimeout, varCompletePingRetries},
+	"fail":     {varFailPingTimeout, varFailPingRetries},
+}
+
+// endpointPingTimeout returns the timeout of each attempt of a ping to endpoint, e.g. --fail-ping-timeout for a fail
+// ping when it is above 0, or --ping-timeout
+func endpointPingTimeout(endpoint string) time.Duration {
+	if settings, ok := endpointPingSettings[endpoint]; ok && viper.GetDuration(settings.timeout) > 0 {
+		return viper.GetDuration(settings.timeout)
+	}
+
+	return viper.GetDuration(varPingTimeout)
+}
+
+// endpointPingRetries returns the number of retries of a ping to endpoint, e.g. --fail-ping-retries for a fail ping
+// when it is 0 or more, or --ping-retries
+func endpointPingRetries(endpoint string) int {
+	if settings, ok := endpointPingSettings[endpoint]; ok && viper.GetInt(settings.retries) >= 0 {
+		return viper.GetInt(settings.retries)
+	}
+
+	return viper.GetInt(varPingRetries)
+}
+
+// PingRecord is a ping written to the --dump-ping file
+type PingRecord struct {
+	Timestamp  string            `json:"timestamp"`
+	Endpoint   string            `json:"endpoint"`
+	Url        string            `json:"url"`
+	Params     map[string]string `json:"params"`
+	StatusCode int               `json:"status_code"`
+	Attempts   int               `json:"attempts"`
+	Delivered  bool              `json:"delivered"`
+}
+
+// newPingRecord describes the last attempt of a ping. The key in the URL is masked and StatusCode is 0 when no
+// response was received.
+func newPingRecord(endpoint string, uri string, key string, statusCode int, attempts int, delivered bool) PingRecord {
+	if len(key) > 0 {
+		uri = strings.Replace(uri, "/"+key+"/", "/"+maskKey(key)+"/", 1)
+	}
+
+	params := map[string]string{}
+	if parsed, err := url.Parse(uri); err == nil {
+		for name, values := range parsed.Query() {
+			params[name] = strings.Join(values, ",")
+		}
+	}
+
+	return PingRecord{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		Endpoint:   endpoint,
+		Url:        uri,
+		Params:     params,
+		StatusCode: statusCode,
+		Attempts:   attempts,
+		Delivered:  delivered,
 	}
 }
 
+// maskKey keeps the first 4 characters of a key so it can be identified without being disclosed
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+
+	return key[:4] + "****"
+}
+
+// dumpPing appends record to path as one line of JSON. Each record is written with a single append so records from
+// concurrent processes are not interleaved on a local filesystem.
+func dumpPing(path string, record PingRecord) {
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(record); err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err == nil {
+		_, err = f.Write(line.Bytes())
+		f.Close()
+	}
+
+	if err != nil {
+		log(fmt.Sprintf("Cannot write ping to %s: %s", path, err.Error()))
+	}
+}
+
+// pingApiHostForAttempt returns the host for a given ping attempt. After two failed attempts we alternate
+// between cronitor.link and the cronitor.io fallback, unless a custom host is set or fallback is disabled.
+func pingApiHostForAttempt(attempt int) string {
+	if dev {
+		return "http://localhost:8000"
+	}
+
+	if customHost := viper.GetString(varPingHost); len(customHost) > 0 {
+		return strings.TrimRight(customHost, "/")
+	}
+
+	if attempt > 2 && attempt%2 == 1 && !noFallbackHost {
+		return "https://cronitor.io"
+	}
+
+	return "https://cronitor.link"
+}
+
+var pingHostProbeMutex sync.Mutex
+var pingHostProbeResults = map[string]pingHostProbeResult{}
+
+type pingHostProbeResult struct {
+	err       error
+	checkedAt time.Time
+}
+
+// probePingHost opens a TCP connection to the ping host to check that it is reachable. Results are cached
+// for a short time so concurrent pings, e.g. the run and complete pings from exec, share a single probe.
+func probePingHost(pingApiHost string) error {
+	pingHostProbeMutex.Lock()
+	defer pingHostProbeMutex.Unlock()
+
+	if result, ok := pingHostProbeResults[pingApiHost]; ok && time.Since(result.checkedAt) < 30*time.Second {
+		return result.err
+	}
+
+	parsedHost, err := url.Parse(pingApiHost)
+	if err != nil {
+		return err
+	}
+
+	address := parsedHost.Host
+	if len(parsedHost.Port()) == 0 {
+		if parsedHost.Scheme == "http" {
+			address = net.JoinHostPort(parsedHost.Hostname(), "80")
+		} else {
+			address = net.JoinHostPort(parsedHost.Hostname(), "443")
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+	if err == nil {
+		conn.Close()
+	}
+
+	pingHostProbeResults[pingApiHost] = pingHostProbeResult{err: err, checkedAt: time.Now()}
+	return err
+}
+
+// measureClockSkew compares the local clock with the Date header of a HEAD request to hostUrl. A positive
+// result means the local clock is ahead. The Date header has a resolution of one second.
+func measureClockSkew(hostUrl string) (time.Duration, error) {
+	client := &http.Client{Transport: lib.Transport, Timeout: 10 * time.Second}
+	request, err := http.NewRequest("HEAD", hostUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Add("User-Agent", userAgent)
+
+	sentAt := time.Now()
+	lib.CountRequest(1)
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	receivedAt := time.Now()
+
+	serverTime, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("the response did not include a valid Date header")
+	}
+
+	localTime := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	return localTime.Sub(serverTime).Truncate(time.Second), nil
+}
+
+var clockSkewOnce sync.Once
+var clockSkewErr error
+
+// checkClockSkewOnce measures clock skew against the ping host the first time it is called and returns an error
+// when it exceeds --max-clock-skew. Later calls return the same result without another request.
+func checkClockSkewOnce(pingApiHost string) error {
+	clockSkewOnce.Do(func() {
+		skew, err := measureClockSkew(pingApiHost)
+		if err != nil {
+			log(fmt.Sprintf("Clock skew could not be measured: %s", err.Error()))
+			return
+		}
+
+		log(fmt.Sprintf("Local clock differs from %s by %s", pingApiHost, skew))
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			clockSkewErr = fmt.Errorf("the local clock differs from %s by %s, more than the allowed %s", pingApiHost, skew, maxClockSkew)
+			color.New(color.FgHiYellow).Fprintln(os.Stderr, "WARNING: "+clockSkewErr.Error()+". Durations and schedules reported to Cronitor may be wrong.")
+		}
+	})
+
+	return clockSkewErr
+}
+
+// requireApiKey returns an error for commands that call the Cronitor API when no API key is configured.
+// A ping API key is not enough for these commands.
+func requireApiKey(cmd *cobra.Command) error {
+	if len(viper.GetString(varApiKey)) < 10 {
+		if len(viper.GetString(varPingApiKey)) > 0 {
+			return errors.New(fmt.Sprintf("the '%s' command requires an API key, a ping API key is not sufficient. Provide an API key with this command or save a key using 'cronitor configure'", cmd.Name()))
+		}
+
+		return errors.New("you must provide an API key with this command or save a key using 'cronitor configure'")
+	}
+
+	return nil
+}
+
+// requirePingAuthentication returns an error when --ping-api-key-required is set and pings would be sent without a key
+func requirePingAuthentication() error {
+	if viper.GetBool(varPingApiKeyRequired) && len(viper.GetString(varPingApiKey)) == 0 && len(viper.GetString(varApiKey)) == 0 {
+		return errors.New("a ping API key is required because --ping-api-key-required is set. Provide a key with this command or save a key using 'cronitor configure'")
+	}
+
+	return nil
+}
+
+// requireAllowedCode returns an error when --allowed-codes or --allowed-codes-file is set and code does not match
+// any of the allowed keys or patterns. Without either, every code is allowed.
+func requireAllowedCode(code string) error {
+	var patterns []string
+	for _, value := range viper.GetStringSlice(varAllowedCodes) {
+		patterns = append(patterns, strings.Split(value, ",")...)
+	}
+
+	file := viper.GetString(varAllowedCodesFile)
+	if len(file) > 0 {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("cannot read allowed codes file %s: %s", file, err.Error())
+		}
+
+		for _, line := range strings.Split(string(contents), "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	} else if len(patterns) == 0 {
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), code); matched {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("monitor %s is not allowed on this host. Pings can only be sent for the monitors in --allowed-codes or --allowed-codes-file", code)
+}
+
 func effectiveHostname() string {
 	if len(viper.GetString(varHostname)) > 0 {
 		return viper.GetString(varHostname)
@@ -251,14 +892,32 @@ func effectiveHostname() string {
 	return hostname
 }
 
+// effectiveExtraHostTags returns the --extra-host-tag values, or the CRONITOR_EXTRA_HOST_TAGS list from the config file
+// or environment, with comma-separated values split and blank values removed
+func effectiveExtraHostTags() []string {
+	var tags []string
+	for _, value := range viper.GetStringSlice(varExtraHostTags) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// embeddedTzdata is set when the binary is built with -tags tzdata and time zones can be loaded without a system
+// time zone database
+var embeddedTzdata = false
+
 func effectiveTimezoneLocationName() lib.TimezoneLocationName {
 	// First, check if a TZ or CRON_TZ environemnt variable is set -- Diff var used by diff distros
 	if locale, isSetFlag := os.LookupEnv("TZ"); isSetFlag {
-		return lib.TimezoneLocationName{locale}
+		return lib.TimezoneLocationName{Name: locale}
 	}
 
 	if locale, isSetFlag := os.LookupEnv("CRON_TZ"); isSetFlag {
-		return lib.TimezoneLocationName{locale}
+		return lib.TimezoneLocationName{Name: locale}
 	}
 
 	// Attempt to parse timedatectl (should work on FreeBSD, many linux distros)
@@ -266,7 +925,7 @@ func effectiveTimezoneLocationName() lib.TimezoneLocationName {
 		outputString := strings.Replace(string(output), "Time zone", "Timezone", -1)
 		r := regexp.MustCompile(`(?m:Timezone:\s+(\S+).+$)`)
 		if ret := r.FindStringSubmatch(outputString); ret != nil && len(ret) > 1 {
-			return lib.TimezoneLocationName{ret[1]}
+			return lib.TimezoneLocationName{Name: ret[1]}
 		}
 	}
 
@@ -274,20 +933,56 @@ func effectiveTimezoneLocationName() lib.TimezoneLocationName {
 	if localtimeFile, err := os.Lstat("/etc/localtime"); err == nil && localtimeFile.Mode()&os.ModeSymlink == os.ModeSymlink {
 		if symlink, _ := os.Readlink("/etc/localtime"); len(symlink) > 0 {
 			if strings.Contains(symlink, "UTC") {
-				return lib.TimezoneLocationName{"UTC"}
+				return lib.TimezoneLocationName{Name: "UTC"}
 			}
 
 			symlinkParts := strings.Split(symlink, "/")
-			return lib.TimezoneLocationName{strings.Join(symlinkParts[len(symlinkParts)-2:], "/")}
+			return lib.TimezoneLocationName{Name: strings.Join(symlinkParts[len(symlinkParts)-2:], "/")}
 		}
 	}
 
 	// If we happen to have an /etc/timezone, no guarantee it's used, but read that
 	if locale, err := ioutil.ReadFile("/etc/timezone"); err == nil {
-		return lib.TimezoneLocationName{string(locale)}
+		return lib.TimezoneLocationName{Name: string(locale)}
+	}
+
+	// The Go runtime names the local zone "Local" unless it was loaded by name, so this rarely finds anything
+	if name := time.Now().Location().String(); name != "Local" && len(name) > 0 {
+		return lib.TimezoneLocationName{Name: name}
+	}
+
+	// Last resort when no IANA name is available, e.g. minimal containers without tzdata
+	_, offset := time.Now().Zone()
+	return lib.TimezoneLocationName{Name: timezoneFromOffset(offset)}
+}
+
+var pingTimezoneOnce sync.Once
+var pingTimezoneName string
+
+// pingTimezone returns the host timezone sent with run pings. It is detected once, detection can run timedatectl.
+func pingTimezone() string {
+	pingTimezoneOnce.Do(func() {
+		pingTimezoneName = strings.TrimSpace(effectiveTimezoneLocationName().Name)
+	})
+	return pingTimezoneName
+}
+
+// timezoneFromOffset returns the Etc/GMT zone for a UTC offset in seconds. The sign of Etc/GMT zones is inverted,
+// UTC+2 is Etc/GMT-2. An empty string is returned for offsets that are not whole hours.
+func timezoneFromOffset(offset int) string {
+	if offset%3600 != 0 || offset > 14*3600 || offset < -12*3600 {
+		return ""
 	}
 
-	return lib.TimezoneLocationName{""}
+	hours := offset / 3600
+	switch {
+	case hours == 0:
+		return "Etc/UTC"
+	case hours > 0:
+		return fmt.Sprintf("Etc/GMT-%d", hours)
+	default:
+		return fmt.Sprintf("Etc/GMT+%d", -hours)
+	}
 }
 
 func defaultConfigFileDirectory() string {
@@ -298,11 +993,412 @@ func defaultConfigFileDirectory() string {
 	return "/etc/cronitor"
 }
 
+// configFileExtensions are the config file formats found without --config, in order of precedence
+var configFileExtensions = []string{".json", ".toml", ".yaml", ".yml"}
+
+// configFileFormat returns the format of a config file from its extension, or an empty string when it is not known
+func configFileFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+
+	return ""
+}
+
+// findConfigFile returns the first cronitor.json, cronitor.toml, cronitor.yaml or cronitor.yml found in directories,
+// and any config files in other formats in the same directory that are ignored because of it
+func findConfigFile(directories []string) (string, []string) {
+	for _, directory := range directories {
+		var found []string
+		for _, extension := range configFileExtensions {
+			file := filepath.Join(directory, "cronitor"+extension)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				found = append(found, file)
+			}
+		}
+
+		if len(found) > 0 {
+			return found[0], found[1:]
+		}
+	}
+
+	return "", nil
+}
+
+// configFileDirectories returns the directories searched for a config file, in order of precedence.
+// Root uses the system-wide directory first, other users prefer their own config directories.
+func configFileDirectories() []string {
+	if runtime.GOOS == "windows" {
+		return []string{defaultConfigFileDirectory()}
+	}
+
+	var userDirectories []string
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); len(xdgConfigHome) > 0 {
+		userDirectories = append(userDirectories, filepath.Join(xdgConfigHome, "cronitor"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		userDirectories = append(userDirectories, filepath.Join(home, ".config", "cronitor"))
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		userDirectories = append(userDirectories, filepath.Join(home, ".cronitor"))
+	}
+
+	if os.Geteuid() == 0 {
+		return append([]string{defaultConfigFileDirectory()}, userDirectories...)
+	}
+
+	return append(userDirectories, defaultConfigFileDirectory())
+}
+
+// configEnvTemplate is a config file value that referenced environment variables, before and after expansion
+type configEnvTemplate struct {
+	Raw      string
+	Expanded string
+}
+
+// configEnvTemplates holds the config file values expanded by expandConfigEnv, by lowercase key, so that
+// 'configure' writes back the references instead of the values they expanded to.
+var configEnvTemplates = map[string]configEnvTemplate{}
+
+// expandConfigEnv replaces $VAR and ${VAR} references in the string values of the config file with environment
+// variables. $$ is a literal $. Values set with flags or environment variables are used as-is.
+func expandConfigEnv(format string) {
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(viper.ConfigFileUsed())
+	if len(format) > 0 {
+		fileConfig.SetConfigType(format)
+	}
+	if err := fileConfig.ReadInConfig(); err != nil {
+		return
+	}
+
+	expanded := map[string]interface{}{}
+	var undefined []string
+	for _, key := range fileConfig.AllKeys() {
+		raw, ok := fileConfig.Get(key).(string)
+		if !ok || !strings.Contains(raw, "$") {
+			continue
+		}
+
+		value := expandEnv(raw, func(name string) {
+			undefined = append(undefined, fmt.Sprintf("%s in %s", name, strings.ToUpper(key)))
+		})
+		expanded[key] = value
+		configEnvTemplates[key] = configEnvTemplate{Raw: raw, Expanded: value}
+	}
+
+	if len(undefined) > 0 {
+		message := "Undefined environment variables in the config file were replaced with empty values: " + strings.Join(undefined, ", ")
+		if strictEnvExpand {
+			fatal(message, exitUsage)
+		}
+		log(message)
+		color.New(color.FgHiYellow).Fprintln(os.Stderr, "WARNING: "+message)
+	}
+
+	if len(expanded) > 0 {
+		viper.MergeConfigMap(expanded)
+	}
+}
+
+// expandEnv is os.ExpandEnv with $$ as an escaped $. onUndefined is called for each variable that is not set.
+func expandEnv(value string, onUndefined func(name string)) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		envValue, isSet := os.LookupEnv(name)
+		if !isSet {
+			onUndefined(name)
+		}
+		return envValue
+	})
+}
+
+// systemdCredentialOverrides holds the config file values replaced by systemd credentials so that
+// 'configure' never writes a credential to disk.
+var systemdCredentialOverrides = map[string]string{}
+
+// readSystemdCredential reads a key delivered with systemd's LoadCredential= from $CREDENTIALS_DIRECTORY.
+// The credential takes precedence over the config file but not over a flag or environment variable.
+func readSystemdCredential(key string, flagName string, credentialName string) {
+	credentialsDirectory := os.Getenv("CREDENTIALS_DIRECTORY")
+	if len(credentialsDirectory) == 0 || RootCmd.PersistentFlags().Changed(flagName) {
+		return
+	}
+
+	if _, isSet := os.LookupEnv(key); isSet {
+		return
+	}
+
+	credential, err := ioutil.ReadFile(filepath.Join(credentialsDirectory, credentialName))
+	if err != nil {
+		return
+	}
+
+	if value := strings.TrimSpace(string(credential)); len(value) > 0 {
+		log(fmt.Sprintf("Reading %s from systemd credential %s", key, credentialName))
+		systemdCredentialOverrides[key] = viper.GetString(key)
+		viper.Set(key, value)
+	}
+}
+
+// sanitizeUserAgentSuffix removes characters that are not valid in a header value and collapses whitespace
+func sanitizeUserAgentSuffix(suffix string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, suffix)
+
+	return strings.Join(strings.Fields(sanitized), " ")
+}
+
+// reservedHeaders are set by CronitorCLI itself and cannot be overridden with --header
+var reservedHeaders = map[string]string{
+	"Authorization": "use --api-key or --ping-api-key",
+	"User-Agent":    "use --user-agent-suffix",
+}
+
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// effectiveHeaderLines returns the --header flags, or the CRONITOR_HEADERS list from the config file or environment
+// when no flag is used. In an environment variable, headers are separated by newlines.
+func effectiveHeaderLines() []string {
+	if RootCmd.PersistentFlags().Changed("header") {
+		return headerFlags
+	}
+
+	switch value := viper.Get(varHeaders).(type) {
+	case string:
+		var lines []string
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimRight(line, "\r"); len(strings.TrimSpace(line)) > 0 {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	default:
+		return viper.GetStringSlice(varHeaders)
+	}
+}
+
+// parseHeaders parses "Name: Value" pairs from --header
+func parseHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !headerNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid --header '%s', expected \"Name: Value\"", value)
+		}
+		if strings.ContainsAny(parts[1], "\r\n\x00") {
+			return nil, fmt.Errorf("invalid --header '%s', the value cannot contain line breaks", name)
+		}
+		if hint, ok := reservedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			return nil, fmt.Errorf("invalid --header '%s', %s instead", name, hint)
+		}
+		headers.Add(name, strings.TrimSpace(parts[1]))
+	}
+	return headers, nil
+}
+
+// reservedPingParams are set by CronitorCLI itself and cannot be overridden with --ping-param
+var reservedPingParams = map[string]bool{
+	"auth_key": true, "host": true, "state": true, "try": true, "stamp": true, "msg": true,
+	"series": true, "duration": true, "status_code": true, "metric": true, "env": true, "sig": true,
+	"tz": true, "host_tag": true,
+}
+
+// effectiveSocks5Proxy returns the --socks5 proxy, or a socks5:// proxy from ALL_PROXY. Other ALL_PROXY schemes are
+// ignored; HTTP proxies are read from HTTPS_PROXY and HTTP_PROXY.
+func effectiveSocks5Proxy() string {
+	if proxy := viper.GetString(varSocks5); len(proxy) > 0 {
+		return proxy
+	}
+
+	for _, name := range []string{"ALL_PROXY", "all_proxy"} {
+		if proxy := os.Getenv(name); strings.HasPrefix(proxy, "socks5://") || strings.HasPrefix(proxy, "socks5h://") {
+			return proxy
+		}
+	}
+
+	return ""
+}
+
+// pingEndpoints are the endpoint names sendPing is called with
+var pingEndpoints = map[string]bool{"run": true, "complete": true, "fail": true, "tick": true}
+
+var pingEndpointNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validatePingEndpointMap checks a --ping-endpoint-map. Keys must be endpoint names used by CronitorCLI and values must
+// be safe to use in a URL.
+func validatePingEndpointMap(mapping map[string]string) error {
+	for from, to := range mapping {
+		if !pingEndpoints[from] {
+			return fmt.Errorf("invalid --ping-endpoint-map '%s=%s', expecting one of run, complete, fail or tick before the =", from, to)
+		}
+		if !pingEndpointNameRegex.MatchString(to) {
+			return fmt.Errorf("invalid --ping-endpoint-map '%s=%s', the endpoint name can only contain letters, numbers, '.', '_' and '-'", from, to)
+		}
+	}
+
+	return nil
+}
+
+// mappedPingEndpoint returns the name used in the ping URL for endpoint
+func mappedPingEndpoint(endpoint string) string {
+	if mapped, ok := viper.GetStringMapString(varPingEndpointMap)[endpoint]; ok {
+		return mapped
+	}
+
+	return endpoint
+}
+
+// signPingUrl appends a sig parameter with the hex encoded HMAC-SHA256 of the canonical form of a ping URL: the
+// escaped path, a "?", and the query parameters sorted by name and encoded as application/x-www-form-urlencoded.
+// Repeated parameters keep their order.
+func signPingUrl(uri string, secret string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := parsed.Query()
+	query.Del("sig")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parsed.EscapedPath() + "?" + query.Encode()))
+	return uri + "&sig=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// formatPingParams turns key=value pairs from --ping-param into a query string fragment to append to a ping URL.
+func formatPingParams(params []string) (string, error) {
+	formatted := ""
+	for _, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(key) == 0 {
+			return "", fmt.Errorf("invalid --ping-param '%s', expected key=value", param)
+		}
+		if reservedPingParams[strings.ToLower(key)] {
+			return "", fmt.Errorf("invalid --ping-param '%s', '%s' is set by CronitorCLI and cannot be overridden", param, key)
+		}
+		formatted += fmt.Sprintf("&%s=%s", url.QueryEscape(key), url.QueryEscape(parts[1]))
+	}
+	return formatted, nil
+}
+
+// formatMessage adds the configured prefix and suffix to a ping message. When the result is too long the
+// message body is truncated so the prefix and suffix are always sent intact.
+func formatMessage(message string, maxLength int) string {
+	prefix, suffix := messageAffixes()
+	if len(prefix) == 0 && len(suffix) == 0 {
+		return truncateMessage(message, maxLength)
+	}
+
+	return truncateString(prefix+truncateMessage(message, messageBodyLength(maxLength))+suffix, maxLength)
+}
+
+// messageAffixes returns the text formatMessage adds before and after every ping message
+func messageAffixes() (string, string) {
+	prefix := viper.GetString(varMessagePrefix)
+	suffix := viper.GetString(varMessageSuffix)
+	if len(gitRevision) > 0 {
+		suffix += " [git=" + gitRevision + "]"
+	}
+	if len(runCwd) > 0 {
+		suffix += " [cwd=" + runCwd + "]"
+	}
+	if len(runArgs) > 0 {
+		suffix += " [args=" + truncateString(runArgs, 200) + "]"
+	}
+	if otelSpan != nil {
+		suffix += " [trace=" + otelSpan.TraceId + "]"
+	}
+	if reportMetadata {
+		suffix += runMetadata()
+	}
+
+	return prefix, suffix
+}
+
+// messageBodyLength returns the space left for the message itself in a ping message of maxLength bytes
+func messageBodyLength(maxLength int) int {
+	prefix, suffix := messageAffixes()
+	if bodyLength := maxLength - len(prefix) - len(suffix); bodyLength > 0 {
+		return bodyLength
+	}
+
+	return 0
+}
+
+// runMetadata describes this process for --report-metadata, e.g. " [pid=123 ppid=45 cmd=cronitor exec d3x0c1 backup.sh]"
+func runMetadata() string {
+	return fmt.Sprintf(" [pid=%d ppid=%d cmd=%s]", os.Getpid(), os.Getppid(), truncateString(redactCommandLine(os.Args), 200))
+}
+
+var secretArgumentRegex = regexp.MustCompile(`(?i)^([^=]*(key|token|secret|password|passwd|auth)[^=]*=).+$`)
+
+// redactCommandLine joins args, replacing the values of key flags and of key=value arguments whose name
+// suggests a secret with "REDACTED"
+func redactCommandLine(args []string) string {
+	redacted := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		switch {
+		case redactNext:
+			redacted[i] = "REDACTED"
+			redactNext = false
+		case arg == "-k" || arg == "--api-key" || arg == "-p" || arg == "--ping-api-key":
+			redacted[i] = arg
+			redactNext = true
+		case secretArgumentRegex.MatchString(arg):
+			redacted[i] = secretArgumentRegex.ReplaceAllString(arg, "${1}REDACTED")
+		default:
+			redacted[i] = arg
+		}
+	}
+
+	return strings.Join(redacted, " ")
+}
+
+const truncatedMarker = "…(truncated)"
+
+// truncateMessage is truncateString for user-facing text: when anything is removed a marker is appended,
+// and the result including the marker still fits within length.
+func truncateMessage(s string, length int) string {
+	if len(s) <= length || length < len(truncatedMarker) {
+		return truncateString(s, length)
+	}
+
+	return truncateString(s, length-len(truncatedMarker)) + truncatedMarker
+}
+
+func effectiveMaxMessageBytes() int {
+	if maxBytes := viper.GetInt(varMaxMessageBytes); maxBytes > 0 {
+		return maxBytes
+	}
+
+	return 1000
+}
+
+// truncateString shortens s to at most length bytes without splitting a multi-byte character
 func truncateString(s string, length int) string {
 	if len(s) <= length {
 		return s
 	}
 
+	for length > 0 && !utf8.RuneStart(s[length]) {
+		length--
+	}
+
 	return s[:length]
 }
 
@@ -363,6 +1459,86 @@ func printLn() {
 	fmt.Println()
 }
 
+// cacheDirectory is a "cache" directory next to the config file in use, or in the first config directory searched
+func cacheDirectory() string {
+	if configFile := viper.ConfigFileUsed(); len(configFile) > 0 {
+		return filepath.Join(filepath.Dir(configFile), "cache")
+	}
+
+	return filepath.Join(configFileDirectories()[0], "cache")
+}
+
+// stateDirectory is a "state" directory next to the config file in use, or in the first config directory searched
+func stateDirectory() string {
+	if configFile := viper.ConfigFileUsed(); len(configFile) > 0 {
+		return filepath.Join(filepath.Dir(configFile), "state")
+	}
+
+	return filepath.Join(configFileDirectories()[0], "state")
+}
+
+func effectiveCacheTTL() time.Duration {
+	if noCache {
+		return 0
+	}
+
+	return cacheTTL
+}
+
+// readMonitorCodesFromFile reads one monitor code per line, ignoring blank lines and # comments
+func readMonitorCodesFromFile(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("the file %s could not be read: %s", path, err.Error()))
+	}
+
+	var codes []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if commentPosition := strings.Index(line, "#"); commentPosition >= 0 {
+			line = line[:commentPosition]
+		}
+
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			codes = append(codes, line)
+		}
+	}
+
+	return codes, nil
+}
+
+// parseOutputTemplate validates the --output and --template flags of list and status. It returns nil for table output.
+func parseOutputTemplate(output string, text string) (*template.Template, error) {
+	if output == "table" {
+		if len(text) > 0 {
+			return nil, errors.New("--template can only be used with --output=template")
+		}
+		return nil, nil
+	} else if output != "template" {
+		return nil, errors.New("invalid argument supplied to 'output'. Expecting 'table' or 'template'")
+	}
+
+	if len(text) == 0 {
+		return nil, errors.New("--output=template requires a --template, e.g. --template '{{.Name}}'")
+	}
+
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.New("invalid --template: " + strings.TrimPrefix(err.Error(), "template: "))
+	}
+
+	return tmpl, nil
+}
+
+// printOutputTemplate renders the template for one item on its own line
+func printOutputTemplate(tmpl *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		fatal("invalid --template: "+strings.TrimPrefix(err.Error(), "template: "), exitUsage)
+	}
+
+	fmt.Println(strings.TrimSuffix(buf.String(), "\n"))
+}
+
 func isPathToDirectory(path string) bool {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -373,6 +1549,10 @@ func isPathToDirectory(path string) bool {
 }
 
 func log(msg string) {
+	if len(requestId) > 0 {
+		msg = fmt.Sprintf("[%s] %s", requestId, msg)
+	}
+
 	debugLog := viper.GetString(varLog)
 	if len(debugLog) > 0 {
 		f, _ := os.OpenFile(debugLog, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
@@ -394,17 +1574,53 @@ func fatal(msg string, exitCode int) {
 	}
 
 	fmt.Fprintln(os.Stderr, msg)
+	exit(exitCode)
+}
+
+// exit logs the network summary and exits. Commands that exit early should use it instead of os.Exit.
+func exit(exitCode int) {
+	logTransferSummary()
 	os.Exit(exitCode)
 }
 
+// logTransferSummary logs the requests made and bytes transferred by this invocation, e.g. with --verbose
+func logTransferSummary() {
+	stats := lib.GetTransferStats()
+	if stats.Requests == 0 {
+		return
+	}
+
+	log(fmt.Sprintf("Network summary: %d requests (%d retries), %d bytes sent, %d bytes received",
+		stats.Requests, stats.Retries, stats.BytesSent, stats.BytesReceived))
+}
+
+// makeRequestId returns a random identifier used to correlate everything sent by this invocation
+func makeRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
 func makeStamp() float64 {
 	return float64(time.Now().UnixNano()) / float64(time.Second)
 }
 
+func stampTime(timestamp float64) time.Time {
+	return time.Unix(0, int64(timestamp*float64(time.Second)))
+}
+
 func formatStamp(timestamp float64) string {
 	return strconv.FormatFloat(timestamp, 'f', 3, 64)
 }
 
+// formatDuration formats a duration in seconds with the number of decimal places set by --duration-precision
+func formatDuration(duration float64) string {
+	return strconv.FormatFloat(duration, 'f', viper.GetInt(varDurationPrecision), 64)
+}
+
 func shortDescription(version string) string {
 	return fmt.Sprintf("CronitorCLI version %s", version)
 }
@@ -413,8 +1629,20 @@ func getCronitorApi() *lib.CronitorApi {
 	return &lib.CronitorApi{
 		IsDev:          dev,
 		IsAutoDiscover: isAutoDiscover,
+		ApiUrl:         viper.GetString(varApiUrl),
 		ApiKey:         varApiKey,
 		UserAgent:      userAgent,
-		Logger:         log,
+		RequestId:      requestId,
+		Headers:        customHeaders,
+		Backoff: lib.Backoff{
+			Attempts:   apiBackoffAttempts,
+			Base:       apiBackoffBase,
+			Max:        apiBackoffMax,
+			MaxElapsed: apiBackoffMaxElapsed,
+		},
+		CacheDir:        cacheDirectory(),
+		CacheTTL:        effectiveCacheTTL(),
+		PageConcurrency: pageConcurrency,
+		Logger:          log,
 	}
 }
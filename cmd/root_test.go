@@ -0,0 +1,608 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+func TestPingApiHostForAttemptFallsBack(t *testing.T) {
+	noFallbackHost = false
+
+	expected := []string{
+		"https://cronitor.link",
+		"https://cronitor.link",
+		"https://cronitor.io",
+		"https://cronitor.link",
+		"https://cronitor.io",
+		"https://cronitor.link",
+	}
+
+	for i, host := range expected {
+		if actual := pingApiHostForAttempt(i + 1); actual != host {
+			t.Errorf("Attempt %d used host %s, expected %s", i+1, actual, host)
+		}
+	}
+}
+
+func TestPingApiHostForAttemptWithNoFallbackHost(t *testing.T) {
+	noFallbackHost = true
+	defer func() { noFallbackHost = false }()
+
+	for i := 1; i <= 6; i++ {
+		if actual := pingApiHostForAttempt(i); actual != "https://cronitor.link" {
+			t.Errorf("Attempt %d used host %s, expected https://cronitor.link", i, actual)
+		}
+	}
+}
+
+func TestFormatMessageKeepsPrefixAndSuffix(t *testing.T) {
+	viper.Set(varMessagePrefix, "[team-a] ")
+	viper.Set(varMessageSuffix, " (end)")
+	defer viper.Set(varMessagePrefix, "")
+	defer viper.Set(varMessageSuffix, "")
+
+	if actual := formatMessage("job output", 100); actual != "[team-a] job output (end)" {
+		t.Errorf("Unexpected message: %s", actual)
+	}
+
+	if actual := formatMessage(strings.Repeat("x", 100), 35); actual != "[team-a] xxxxxx…(truncated) (end)" {
+		t.Errorf("Prefix and suffix were not preserved when truncating: %s", actual)
+	}
+}
+
+func TestTruncateStringDoesNotSplitRunes(t *testing.T) {
+	tables := []struct {
+		input    string
+		length   int
+		expected string
+	}{
+		{"abcdef", 10, "abcdef"},
+		{"abcdef", 3, "abc"},
+		{"ab€", 4, "ab"},
+		{"ab€", 5, "ab€"},
+		{"日本語", 7, "日本"},
+		{"日本語", 2, ""},
+	}
+
+	for _, table := range tables {
+		actual := truncateString(table.input, table.length)
+		if actual != table.expected {
+			t.Errorf("truncateString(%q, %d) = %q, expected %q", table.input, table.length, actual, table.expected)
+		}
+
+		if !utf8.ValidString(actual) {
+			t.Errorf("truncateString(%q, %d) produced invalid UTF-8", table.input, table.length)
+		}
+	}
+}
+
+func TestFormatMessageRespectsMaxMessageBytes(t *testing.T) {
+	viper.Set(varMaxMessageBytes, 25)
+	defer viper.Set(varMaxMessageBytes, 1000)
+
+	message := formatMessage(strings.Repeat("é", 20), effectiveMaxMessageBytes())
+	if message != strings.Repeat("é", 5)+truncatedMarker {
+		t.Errorf("Unexpected message: %q", message)
+	}
+}
+
+func TestTruncateMessageAddsMarker(t *testing.T) {
+	tables := []struct {
+		input    string
+		length   int
+		expected string
+	}{
+		{"short message", 100, "short message"},
+		{"0123456789abcdefghij", 20, "0123456789abcdefghij"},
+		{"0123456789abcdefghijk", 20, "012345…(truncated)"},
+		{"🙂🙂🙂🙂🙂🙂", 22, "🙂🙂…(truncated)"},
+		{"🙂🙂🙂🙂🙂🙂", 21, "🙂…(truncated)"},
+		{"漢字漢字漢字漢字", 20, "漢字…(truncated)"},
+		{"漢字漢字漢字漢字", 19, "漢…(truncated)"},
+		{"0123456789abcdefghij", 5, "01234"},
+	}
+
+	for _, table := range tables {
+		actual := truncateMessage(table.input, table.length)
+		if actual != table.expected {
+			t.Errorf("truncateMessage(%q, %d) = %q, expected %q", table.input, table.length, actual, table.expected)
+		}
+
+		if len(actual) > table.length || !utf8.ValidString(actual) {
+			t.Errorf("truncateMessage(%q, %d) produced %q which is too long or invalid UTF-8", table.input, table.length, actual)
+		}
+	}
+}
+
+func TestFormatPingParams(t *testing.T) {
+	formatted, err := formatPingParams([]string{"foo=bar baz", "empty="})
+	if err != nil || formatted != "&foo=bar+baz&empty=" {
+		t.Errorf("Unexpected result: %q, %v", formatted, err)
+	}
+
+	for _, param := range []string{"auth_key=abc", "HOST=abc", "novalue", "=abc"} {
+		if _, err := formatPingParams([]string{param}); err == nil {
+			t.Errorf("Expected an error for %q", param)
+		}
+	}
+}
+
+func TestTimezoneFromOffset(t *testing.T) {
+	tables := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "Etc/UTC"},
+		{2 * 3600, "Etc/GMT-2"},
+		{-5 * 3600, "Etc/GMT+5"},
+		{14 * 3600, "Etc/GMT-14"},
+		{5*3600 + 1800, ""},
+		{-13 * 3600, ""},
+	}
+
+	for _, table := range tables {
+		if actual := timezoneFromOffset(table.offset); actual != table.expected {
+			t.Errorf("timezoneFromOffset(%d) was %q, expected %q", table.offset, actual, table.expected)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders([]string{"X-Corp-App: cronitor", "x-team:  jobs ", "X-Empty:"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if headers.Get("X-Corp-App") != "cronitor" || headers.Get("X-Team") != "jobs" || len(headers["X-Empty"]) != 1 {
+		t.Errorf("Unexpected headers: %v", headers)
+	}
+
+	for _, header := range []string{"X-Corp-App", "Bad Name: value", ": value", "X-Value: a\r\nX-Injected: b", "Authorization: Basic abc", "user-agent: curl"} {
+		if _, err := parseHeaders([]string{header}); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
+
+func TestSanitizeUserAgentSuffix(t *testing.T) {
+	tables := []struct {
+		input    string
+		expected string
+	}{
+		{"AcmeDeployer/2.1", "AcmeDeployer/2.1"},
+		{"  Acme/1   (linux)\n", "Acme/1 (linux)"},
+		{"Acme\r\nX-Injected: 1", "AcmeX-Injected: 1"},
+		{"Acmé/1", "Acm/1"},
+		{"\t\n", ""},
+	}
+
+	for _, table := range tables {
+		if actual := sanitizeUserAgentSuffix(table.input); actual != table.expected {
+			t.Errorf("sanitizeUserAgentSuffix(%q) = %q, expected %q", table.input, actual, table.expected)
+		}
+	}
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-2*time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	skew, err := measureClockSkew(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if skew < 118*time.Second || skew > 122*time.Second {
+		t.Errorf("Expected a skew of about 2m, got %s", skew)
+	}
+}
+
+func TestRedactCommandLine(t *testing.T) {
+	tables := []struct {
+		input    []string
+		expected string
+	}{
+		{[]string{"cronitor", "exec", "d3x0c1", "backup.sh"}, "cronitor exec d3x0c1 backup.sh"},
+		{[]string{"cronitor", "-k", "abc123", "--ping-api-key", "def456", "ping"}, "cronitor -k REDACTED --ping-api-key REDACTED ping"},
+		{[]string{"cronitor", "--api-key=abc123", "exec", "d3x0c1", "run.sh", "DB_PASSWORD=hunter2", "--token=xyz", "user=bob"}, "cronitor --api-key=REDACTED exec d3x0c1 run.sh DB_PASSWORD=REDACTED --token=REDACTED user=bob"},
+	}
+
+	for _, table := range tables {
+		if actual := redactCommandLine(table.input); actual != table.expected {
+			t.Errorf("redactCommandLine(%q) = %q, expected %q", table.input, actual, table.expected)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("CRONITOR_TEST_NODE", "node-7")
+	defer os.Unsetenv("CRONITOR_TEST_NODE")
+
+	tables := []struct {
+		value     string
+		expected  string
+		undefined []string
+	}{
+		{"${CRONITOR_TEST_NODE}", "node-7", nil},
+		{"$CRONITOR_TEST_NODE.example.com", "node-7.example.com", nil},
+		{"costs $$5", "costs $5", nil},
+		{"${CRONITOR_TEST_MISSING}-$CRONITOR_TEST_NODE", "-node-7", []string{"CRONITOR_TEST_MISSING"}},
+	}
+
+	for _, table := range tables {
+		var undefined []string
+		actual := expandEnv(table.value, func(name string) { undefined = append(undefined, name) })
+		if actual != table.expected || strings.Join(undefined, ",") != strings.Join(table.undefined, ",") {
+			t.Errorf("expandEnv(%q) was %q with undefined %v, expected %q with undefined %v", table.value, actual, undefined, table.expected, table.undefined)
+		}
+	}
+}
+
+func TestNewPingRecordMasksKey(t *testing.T) {
+	record := newPingRecord("complete", "https://cronitor.link/ping/4f3e9a8b7c/d3x0c1?state=complete&try=2&metric=length%3A10&metric=count%3A2", "4f3e9a8b7c", 503, 2, false)
+
+	if record.Url != "https://cronitor.link/ping/4f3e****/d3x0c1?state=complete&try=2&metric=length%3A10&metric=count%3A2" {
+		t.Errorf("Unexpected url: %s", record.Url)
+	}
+
+	if record.Params["state"] != "complete" || record.Params["try"] != "2" || record.Params["metric"] != "length:10,count:2" {
+		t.Errorf("Unexpected params: %v", record.Params)
+	}
+
+	if record.StatusCode != 503 || record.Attempts != 2 || record.Delivered {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+}
+
+func TestEffectiveHeaderLines(t *testing.T) {
+	defer viper.Set(varHeaders, nil)
+
+	viper.Set(varHeaders, []interface{}{"X-Relay-Token: 7f3a", "X-Team: ops"})
+	if actual := effectiveHeaderLines(); strings.Join(actual, "|") != "X-Relay-Token: 7f3a|X-Team: ops" {
+		t.Errorf("Unexpected headers from a config file list: %q", actual)
+	}
+
+	viper.Set(varHeaders, "X-Relay-Token: 7f3a\r\n\nX-Team: a, b\n")
+	if actual := effectiveHeaderLines(); strings.Join(actual, "|") != "X-Relay-Token: 7f3a|X-Team: a, b" {
+		t.Errorf("Unexpected headers from an environment variable: %q", actual)
+	}
+}
+
+func TestSignPingUrl(t *testing.T) {
+	uri := "http://relay.internal/ping/abcd1234/d3x0c1?state=complete&try=1&msg=a+b&host=web-1"
+	expected := uri + "&sig=e7ced0cea0e5dddc1475b78686f18ea9f09420258a8aa93f91767c4c3403b34b"
+	if actual := signPingUrl(uri, "s3cret"); actual != expected {
+		t.Errorf("Unexpected signed URL: %s", actual)
+	}
+}
+
+func TestPingEndpointMap(t *testing.T) {
+	viper.Set(varPingEndpointMap, map[string]string{"run": "start", "fail": "err"})
+	defer viper.Set(varPingEndpointMap, map[string]string{})
+
+	if mappedPingEndpoint("run") != "start" || mappedPingEndpoint("complete") != "complete" {
+		t.Errorf("Unexpected mapped endpoints: %s, %s", mappedPingEndpoint("run"), mappedPingEndpoint("complete"))
+	}
+
+	if err := validatePingEndpointMap(map[string]string{"run": "start", "tick": "beat"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	for _, mapping := range []map[string]string{{"start": "run"}, {"run": ""}, {"run": "a/b"}, {"fail": "err?x=1"}} {
+		if err := validatePingEndpointMap(mapping); err == nil {
+			t.Errorf("Expected an error for %v", mapping)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	if actual := formatDuration(3725.6549); actual != "3725.655" {
+		t.Errorf("Expected 3 decimal places by default, got %s", actual)
+	}
+
+	viper.Set(varDurationPrecision, 0)
+	defer viper.Set(varDurationPrecision, 3)
+	if actual := formatDuration(3725.6549); actual != "3726" {
+		t.Errorf("Expected whole seconds, got %s", actual)
+	}
+}
+
+func TestRequireAllowedCode(t *testing.T) {
+	if err := requireAllowedCode("d3x0c1"); err != nil {
+		t.Errorf("Expected every code to be allowed by default, got %v", err)
+	}
+
+	viper.Set(varAllowedCodes, []string{"backup-*,d3x0c1"})
+	defer viper.Set(varAllowedCodes, []string{})
+
+	for _, code := range []string{"d3x0c1", "backup-db"} {
+		if err := requireAllowedCode(code); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", code, err)
+		}
+	}
+
+	if err := requireAllowedCode("billing"); err == nil {
+		t.Errorf("Expected billing not to be allowed")
+	}
+
+	file, err := ioutil.TempFile("", "cronitor-allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# billing team\nbilling\n")
+	file.Close()
+
+	viper.Set(varAllowedCodesFile, file.Name())
+	defer viper.Set(varAllowedCodesFile, "")
+	for _, code := range []string{"billing", "backup-db"} {
+		if err := requireAllowedCode(code); err != nil {
+			t.Errorf("Expected %s to be allowed with the file, got %v", code, err)
+		}
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	first, err := ioutil.TempDir("", "cronitor-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(first)
+	second, err := ioutil.TempDir("", "cronitor-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(second)
+
+	if found, _ := findConfigFile([]string{first, second}); found != "" {
+		t.Errorf("Expected no config file, found %s", found)
+	}
+
+	ioutil.WriteFile(filepath.Join(second, "cronitor.json"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(first, "cronitor.yaml"), []byte(""), 0644)
+	ioutil.WriteFile(filepath.Join(first, "cronitor.toml"), []byte(""), 0644)
+
+	found, ignored := findConfigFile([]string{first, second})
+	if found != filepath.Join(first, "cronitor.toml") {
+		t.Errorf("Expected cronitor.toml in the first directory, found %s", found)
+	}
+	if len(ignored) != 1 || ignored[0] != filepath.Join(first, "cronitor.yaml") {
+		t.Errorf("Expected cronitor.yaml to be ignored, got %q", ignored)
+	}
+}
+
+func TestTomlConfigKeys(t *testing.T) {
+	file, err := ioutil.TempFile("", "cronitor-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("CRONITOR_API_KEY = \"abc\"\nCRONITOR_PING_RETRIES = 2\nCRONITOR_HTTP1_ONLY = true\nCRONITOR_HEADERS = [\"X-A: 1\"]\n[CRONITOR_PING_ENDPOINT_MAP]\nrun = \"start\"\n")
+	file.Close()
+
+	config := viper.New()
+	config.SetConfigFile(file.Name())
+	if err := config.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.GetString(varApiKey) != "abc" || config.GetInt(varPingRetries) != 2 || !config.GetBool(varHttp1Only) {
+		t.Errorf("Unexpected scalar values: %v", config.AllSettings())
+	}
+	if headers := config.GetStringSlice(varHeaders); len(headers) != 1 || headers[0] != "X-A: 1" {
+		t.Errorf("Unexpected headers: %q", headers)
+	}
+	if mapping := config.GetStringMapString(varPingEndpointMap); mapping["run"] != "start" {
+		t.Errorf("Unexpected endpoint map: %v", mapping)
+	}
+}
+
+func TestSendPingDoesNotRetryClientErrors(t *testing.T) {
+	tables := []struct {
+		status     int
+		retryAfter string
+		retries    int
+		attempts   int
+		message    string
+	}{
+		{http.StatusNotFound, "", 5, 1, "monitor code not found: d3x0c1 (404 response)"},
+		{http.StatusGone, "", 5, 1, "monitor code not found"},
+		{http.StatusBadRequest, "", 5, 1, "unexpected 400 ping response"},
+		{http.StatusBadGateway, "", 1, 2, "unexpected 502 ping response"},
+		{http.StatusRequestTimeout, "", 1, 2, "unexpected 408 ping response"},
+		{http.StatusTooManyRequests, "1", 1, 2, "unexpected 429 ping response"},
+		{http.StatusTooManyRequests, "3600", 5, 1, "unexpected 429 ping response"},
+	}
+	defer viper.Set(varPingRetries, 5)
+
+	for _, table := range tables {
+		viper.Set(varPingRetries, table.retries)
+		attempts := 0
+		var firstAttempt, retriedAt time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				firstAttempt = time.Now()
+			} else if attempts == 2 {
+				retriedAt = time.Now()
+			}
+			if len(table.retryAfter) > 0 {
+				w.Header().Set("Retry-After", table.retryAfter)
+			}
+			w.WriteHeader(table.status)
+		}))
+		viper.Set(varPingHost, server.URL)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		err := sendPing("complete", "d3x0c1", "", "", makeStamp(), nil, nil, nil, &wg)
+		server.Close()
+
+		if attempts != table.attempts {
+			t.Errorf("Expected %d attempts after a %d response, got %d", table.attempts, table.status, attempts)
+		}
+		if err == nil || !strings.Contains(err.Error(), table.message) {
+			t.Errorf("Expected an error containing %q after a %d response, got %v", table.message, table.status, err)
+		}
+		if table.retryAfter == "1" && (retriedAt.IsZero() || retriedAt.Sub(firstAttempt) < 900*time.Millisecond) {
+			t.Errorf("Expected the retry after a 429 response to wait for its Retry-After")
+		}
+	}
+	viper.Set(varPingHost, "")
+}
+
+func TestEndpointPingSettings(t *testing.T) {
+	if retries, timeout := endpointPingRetries("fail"), endpointPingTimeout("fail"); retries != 5 || timeout != 10*time.Second {
+		t.Errorf("Expected fail pings to use --ping-retries and --ping-timeout by default, got %d and %s", retries, timeout)
+	}
+
+	viper.Set(varFailPingRetries, 10)
+	viper.Set(varFailPingTimeout, 30*time.Second)
+	defer viper.Set(varFailPingRetries, -1)
+	defer viper.Set(varFailPingTimeout, 0)
+
+	if retries, timeout := endpointPingRetries("fail"), endpointPingTimeout("fail"); retries != 10 || timeout != 30*time.Second {
+		t.Errorf("Expected --fail-ping-retries and --fail-ping-timeout, got %d and %s", retries, timeout)
+	}
+
+	if retries, timeout := endpointPingRetries("run"), endpointPingTimeout("run"); retries != 5 || timeout != 10*time.Second {
+		t.Errorf("Expected run pings to keep the global settings, got %d and %s", retries, timeout)
+	}
+
+	if retries := endpointPingRetries("tick"); retries != 5 {
+		t.Errorf("Expected tick pings to keep the global settings, got %d", retries)
+	}
+}
+
+func TestSendPingTimezone(t *testing.T) {
+	pingTimezoneOnce.Do(func() {})
+	pingTimezoneName = "America/Chicago"
+	defer func() { pingTimezoneName = ""; noPingTimezone = false }()
+
+	tables := []struct {
+		endpoint   string
+		timezone   string
+		noTimezone bool
+		expected   string
+	}{
+		{"run", "America/Chicago", false, "tz=America%2FChicago"},
+		{"complete", "America/Chicago", false, ""},
+		{"run", "America/Chicago", true, ""},
+		{"run", "", false, ""},
+	}
+
+	for _, table := range tables {
+		pingTimezoneName = table.timezone
+		noPingTimezone = table.noTimezone
+		query := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.RawQuery
+		}))
+		viper.Set(varPingHost, server.URL)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		sendPing(table.endpoint, "d3x0c1", "", "", makeStamp(), nil, nil, nil, &wg)
+		server.Close()
+
+		if table.expected == "" && strings.Contains(query, "tz=") {
+			t.Errorf("Expected no tz parameter for %+v, got %s", table, query)
+		} else if !strings.Contains(query, table.expected) {
+			t.Errorf("Expected %s for %+v, got %s", table.expected, table, query)
+		}
+	}
+	viper.Set(varPingHost, "")
+}
+
+func TestSendPingEscapesSeries(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+	}))
+	viper.Set(varPingHost, server.URL)
+	defer viper.Set(varPingHost, "")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	sendPing("complete", "d3x0c1", "", "deploy 42&state=fail#x", makeStamp(), nil, nil, nil, &wg)
+	server.Close()
+
+	if query.Get("series") != "deploy 42&state=fail#x" || len(query["state"]) > 1 {
+		t.Errorf("Expected the series to be escaped, got %v", query)
+	}
+}
+
+func TestValidateExportSeries(t *testing.T) {
+	defer func() { series = "" }()
+
+	for _, value := range []string{"", "1715000000.123", "deploy-42:web_1"} {
+		series = value
+		if err := validateExportSeries(); err != nil {
+			t.Errorf("Expected %q to be valid, got %s", value, err)
+		}
+	}
+
+	for _, value := range []string{"deploy 42", "a&b", "$(reboot)", strings.Repeat("x", 129)} {
+		series = value
+		if err := validateExportSeries(); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestParseOutputTemplate(t *testing.T) {
+	if tmpl, err := parseOutputTemplate("table", ""); tmpl != nil || err != nil {
+		t.Errorf("Expected no template for table output, got %v, %v", tmpl, err)
+	}
+
+	for _, args := range [][2]string{{"json", ""}, {"table", "{{.Name}}"}, {"template", ""}, {"template", "{{.Name"}} {
+		if _, err := parseOutputTemplate(args[0], args[1]); err == nil {
+			t.Errorf("Expected an error for --output=%s --template '%s'", args[0], args[1])
+		}
+	}
+
+	tmpl, err := parseOutputTemplate("template", "{{.Name}} {{.State}}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var buf strings.Builder
+	tmpl.Execute(&buf, StatusMonitor{Name: "Nightly backup", Passing: false})
+	if buf.String() != "Nightly backup Failing" {
+		t.Errorf("Unexpected template output %q", buf.String())
+	}
+}
+
+func TestSendPingExtraHostTags(t *testing.T) {
+	query := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+	viper.Set(varPingHost, server.URL)
+	viper.Set(varExtraHostTags, []string{"node=worker-3, pod=" + strings.Repeat("p", 60), " "})
+	defer viper.Set(varPingHost, "")
+	defer viper.Set(varExtraHostTags, []string{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	sendPing("complete", "d3x0c1", "", "", makeStamp(), nil, nil, nil, &wg)
+
+	expected := "&host_tag=node%3Dworker-3&host_tag=pod%3D" + strings.Repeat("p", 46)
+	if !strings.HasSuffix(query, expected) {
+		t.Errorf("Expected %s in %s", expected, query)
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// MonitorRule overrides settings for the monitors whose key matches Match. Unset fields are left alone.
+type MonitorRule struct {
+	Match         string   `mapstructure:"match"`
+	Timeout       string   `mapstructure:"timeout"`
+	Retries       *int     `mapstructure:"retries"`
+	Env           string   `mapstructure:"env"`
+	Tags          []string `mapstructure:"tags"`
+	MessagePrefix string   `mapstructure:"message-prefix"`
+}
+
+// ruleTags are the tags from the rules file for the current monitor, added to monitors created by exec --on-missing-monitor
+var ruleTags []string
+
+// loadMonitorRules reads the rules list from a YAML rules file
+func loadMonitorRules(file string) ([]MonitorRule, error) {
+	rulesViper := viper.New()
+	rulesViper.SetConfigFile(file)
+	rulesViper.SetConfigType("yaml")
+	if err := rulesViper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("cannot read rules file %s: %s", file, err.Error())
+	}
+
+	var rules []MonitorRule
+	if err := rulesViper.UnmarshalKey("rules", &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %s", file, err.Error())
+	}
+
+	for i, rule := range rules {
+		if len(rule.Match) == 0 {
+			return nil, fmt.Errorf("invalid rules file %s: rule %d has no match", file, i+1)
+		}
+
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid rules file %s: rule %d has an invalid match pattern '%s'", file, i+1, rule.Match)
+		}
+
+		if len(rule.Timeout) > 0 {
+			if timeout, err := time.ParseDuration(rule.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid rules file %s: rule %d has an invalid timeout '%s', expecting a duration, e.g. 30s", file, i+1, rule.Timeout)
+			}
+		}
+
+		if rule.Retries != nil && *rule.Retries < 0 {
+			return nil, fmt.Errorf("invalid rules file %s: rule %d has a negative number of retries", file, i+1)
+		}
+	}
+
+	return rules, nil
+}
+
+// matchingRule merges every rule that matches code, in file order, so a later rule overrides an earlier one
+func matchingRule(rules []MonitorRule, code string) MonitorRule {
+	merged := MonitorRule{}
+	for _, rule := range rules {
+		if matched, _ := path.Match(rule.Match, code); !matched {
+			continue
+		}
+
+		merged.Match = rule.Match
+		if len(rule.Timeout) > 0 {
+			merged.Timeout = rule.Timeout
+		}
+		if rule.Retries != nil {
+			merged.Retries = rule.Retries
+		}
+		if len(rule.Env) > 0 {
+			merged.Env = rule.Env
+		}
+		if rule.Tags != nil {
+			merged.Tags = rule.Tags
+		}
+		if len(rule.MessagePrefix) > 0 {
+			merged.MessagePrefix = rule.MessagePrefix
+		}
+	}
+
+	return merged
+}
+
+// applyMonitorRules applies the rules file to the settings used for code. Rules override the config file and
+// environment variables, and flags set on the command line override rules.
+func applyMonitorRules(cmd *cobra.Command, code string) error {
+	file := viper.GetString(varRulesFile)
+	if len(file) == 0 {
+		return nil
+	}
+
+	rules, err := loadMonitorRules(file)
+	if err != nil {
+		return err
+	}
+
+	rule := matchingRule(rules, code)
+	if len(rule.Match) == 0 {
+		return nil
+	}
+
+	log(fmt.Sprintf("Applying rules matching %s from %s", code, file))
+	flagSet := func(name string) bool {
+		return cmd.Flags().Changed(name)
+	}
+
+	if len(rule.Timeout) > 0 && !flagSet("ping-timeout") {
+		timeout, _ := time.ParseDuration(rule.Timeout)
+		viper.Set(varPingTimeout, timeout)
+	}
+	if rule.Retries != nil && !flagSet("ping-retries") {
+		viper.Set(varPingRetries, *rule.Retries)
+	}
+	if len(rule.Env) > 0 && !flagSet("env") {
+		viper.Set(varEnv, rule.Env)
+	}
+	if len(rule.MessagePrefix) > 0 && !flagSet("message-prefix") {
+		viper.Set(varMessagePrefix, rule.MessagePrefix)
+	}
+	ruleTags = rule.Tags
+
+	return nil
+}
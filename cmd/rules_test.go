@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchingRule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "rules.yaml")
+	ioutil.WriteFile(file, []byte(`
+rules:
+  - match: "*"
+    timeout: 5s
+    env: staging
+  - match: "backup-*"
+    timeout: 30s
+    retries: 8
+    tags: [database]
+  - match: backup-logs
+    env: production
+`), 0644)
+
+	rules, err := loadMonitorRules(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := matchingRule(rules, "backup-logs")
+	if rule.Timeout != "30s" || rule.Retries == nil || *rule.Retries != 8 || rule.Env != "production" || len(rule.Tags) != 1 {
+		t.Errorf("Unexpected merged rule: %+v", rule)
+	}
+
+	rule = matchingRule(rules, "report")
+	if rule.Timeout != "5s" || rule.Retries != nil || rule.Env != "staging" || rule.Tags != nil {
+		t.Errorf("Unexpected rule for a monitor only matching the wildcard: %+v", rule)
+	}
+}
+
+func TestLoadMonitorRulesRejectsInvalidRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cronitor-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, contents := range []string{
+		"rules:\n  - timeout: 5s\n",
+		"rules:\n  - match: \"[\"\n",
+		"rules:\n  - match: \"*\"\n    timeout: soon\n",
+		"rules:\n  - match: \"*\"\n    retries: -1\n",
+	} {
+		file := filepath.Join(dir, "rules.yaml")
+		ioutil.WriteFile(file, []byte(contents), 0644)
+		if _, err := loadMonitorRules(file); err == nil {
+			t.Errorf("Expected an error for %q", contents)
+		}
+	}
+
+	if _, err := loadMonitorRules(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
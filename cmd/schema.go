@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/spf13/cobra"
+)
+
+var schemaValidateFile string
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema of monitor manifests",
+	Long: `
+Print the JSON schema of the monitor manifest written by 'cronitor discover --manifest-out', so manifests can be checked
+in an editor or in CI.
+
+Example saving the schema for your editor:
+  $ cronitor schema > cronitor-manifest.schema.json
+
+Example checking a manifest in CI:
+  $ cronitor schema --validate monitors.json
+      > Every error is printed with the line of the monitor and the field, e.g. "line 14: [1].key is required"
+      > Exits with code 1 when the manifest is not valid, or 66 when it cannot be read
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(schemaValidateFile) == 0 {
+			fmt.Print(lib.ManifestSchema)
+			return
+		}
+
+		contents, err := ioutil.ReadFile(schemaValidateFile)
+		if err != nil {
+			fatal(fmt.Sprintf("The manifest could not be read: %s", err.Error()), exitNoInput)
+		}
+
+		errs := lib.ValidateManifest(contents)
+		for _, err := range errs {
+			printErrorText(err.Error(), false)
+		}
+
+		if len(errs) > 0 {
+			fatal(fmt.Sprintf("%s is not a valid manifest, %d errors were found", schemaValidateFile, len(errs)), exitFailure)
+		}
+
+		printDoneText(fmt.Sprintf("%s is a valid manifest", schemaValidateFile), false)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(schemaCmd)
+	schemaCmd.Flags().StringVar(&schemaValidateFile, "validate", schemaValidateFile, "Check this manifest against the schema instead of printing it")
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-	"os"
 	"os/user"
 )
 
@@ -104,10 +103,9 @@ Example:
 			}
 		} else if err == promptui.ErrInterrupt {
 			fmt.Println("Exited by user signal")
-			os.Exit(-1)
-			os.Exit(-1)
+			exit(exitInterrupted)
 		} else {
-			fmt.Println("Error: " + err.Error() + "\n")
+			fatal("Error: "+err.Error(), exitInternal)
 		}
 	},
 }
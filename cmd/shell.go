@@ -60,9 +60,9 @@ Example:
 
 			} else if err == promptui.ErrInterrupt {
 				fmt.Println("Exited by user signal")
-				os.Exit(-1)
+				exit(exitInterrupted)
 			} else {
-				fmt.Println("Error: " + err.Error() + "\n")
+				fatal("Error: "+err.Error(), exitInternal)
 			}
 
 			break
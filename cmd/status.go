@@ -3,12 +3,11 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"os"
+	"text/template"
 )
 
 type StatusMonitor struct {
@@ -18,10 +17,23 @@ type StatusMonitor struct {
 	Status  string `json:"status"`
 }
 
+// State is the health shown in the status table, Ok or Failing
+func (m StatusMonitor) State() string {
+	if m.Passing {
+		return "Ok"
+	}
+	return "Failing"
+}
+
 type StatusMonitors struct {
 	Monitors []StatusMonitor `json:"monitors"`
 }
 
+var statusFromFile string
+var statusOutput string
+var statusTemplate string
+var statusOutputTemplate *template.Template
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "View monitor status",
@@ -34,67 +46,134 @@ Examples:
 
   View status of a single monitor:
   $ cronitor status d3x0c1
+
+  View status of several monitors, reading codes from a file with one code per line (# comments are allowed):
+  $ cronitor status d3x0c1 --from-file codes.txt
+  Every monitor is checked even if some fail, and the exit code is 69 if any could not be retrieved.
+
+  The status of all monitors is cached for --cache-ttl (default 1m). To always fetch the latest status:
+  $ cronitor status --no-cache
+
+  Print each monitor with a Go template instead of a table, one line per monitor:
+  $ cronitor status --output=template --template '{{.Name}} {{.State}}'
+  The fields are .Name, .Code, .Status, .Passing (true or false) and .State (Ok or Failing). Use {{"\t"}} for a tab.
+  A template that cannot be parsed, or uses a field that does not exist, exits with code 64.
 `,
 
 	Args: func(cmd *cobra.Command, args []string) error {
-		if len(viper.GetString(varApiKey)) < 10 {
-			return errors.New("you must provide an API key with this command or save a key using 'cronitor configure'")
+		var err error
+		if statusOutputTemplate, err = parseOutputTemplate(statusOutput, statusTemplate); err != nil {
+			return err
+		}
+
+		if err := requireApiKey(cmd); err != nil {
+			return err
 		}
 
 		return nil
 	},
 
 	Run: func(cmd *cobra.Command, args []string) {
-		url := getCronitorApi().Url()
-		if len(args) > 0 {
-			url = url + "/" + args[0]
+		codes := args
+		if len(statusFromFile) > 0 {
+			fileCodes, err := readMonitorCodesFromFile(statusFromFile)
+			if err != nil {
+				fatal(err.Error(), exitNoInput)
+			}
+			codes = append(codes, fileCodes...)
 		}
 
-		// @todo refactor this to use GetMonitors
-		response, err := getCronitorApi().GetRawResponse(url)
-		if err != nil {
-			fatal(fmt.Sprintf("Request to %s failed: %s", url, err), 1)
-		}
+		responseMonitors := StatusMonitors{}
+		failed := 0
 
-		buf := new(bytes.Buffer)
-		json.Indent(buf, response, "", "  ")
-		log("\nResponse:")
-		log(buf.String() + "\n")
+		if len(codes) == 0 {
+			url := getCronitorApi().Url()
 
-		// Unmarshall the API response into the StatusMonitor struct.
-		// If this is a detail response (with a monitor code argument) we need to unmarshal it directly into a StatusMonitor
-		responseMonitors := StatusMonitors{}
-		if len(args) == 0 {
+			// @todo refactor this to use GetMonitors
+			response, err := getCronitorApi().GetCachedRawResponse(url)
+			if err != nil {
+				fatal(fmt.Sprintf("Request to %s failed: %s", url, err), exitUnavailable)
+			}
+
+			logStatusResponse(response)
 			if err = json.Unmarshal(response, &responseMonitors); err != nil {
-				fatal(fmt.Sprintf("Error %s from %s: %s", err.Error(), url, response), 1)
+				fatal(fmt.Sprintf("Error %s from %s: %s", err.Error(), url, response), exitUnavailable)
+			}
+
+			if statusOutputTemplate == nil {
+				fmt.Println(url)
 			}
 		} else {
-			singleMonitor := StatusMonitor{}
-			if err = json.Unmarshal(response, &singleMonitor); err != nil {
-				fatal(fmt.Sprintf("Error %s from %s: %s", err.Error(), url, response), 1)
+			// Continue past individual failures so one bad code doesn't hide the status of the rest
+			for _, code := range codes {
+				url := getCronitorApi().Url() + "/" + code
+				response, err := getCronitorApi().GetRawResponse(url)
+				if err != nil {
+					printErrorText(fmt.Sprintf("%s: request to %s failed: %s", code, url, err), false)
+					failed++
+					continue
+				}
+
+				logStatusResponse(response)
+				singleMonitor := StatusMonitor{}
+				if err = json.Unmarshal(response, &singleMonitor); err != nil {
+					printErrorText(fmt.Sprintf("%s: error %s from %s: %s", code, err.Error(), url, response), false)
+					failed++
+					continue
+				}
+
+				responseMonitors.Monitors = append(responseMonitors.Monitors, singleMonitor)
+			}
+
+			if len(codes) == 1 && failed == 0 && statusOutputTemplate == nil {
+				fmt.Println(getCronitorApi().Url() + "/" + codes[0])
+			}
+		}
+
+		if statusOutputTemplate != nil {
+			for _, v := range responseMonitors.Monitors {
+				printOutputTemplate(statusOutputTemplate, v)
 			}
 
-			responseMonitors.Monitors = []StatusMonitor{singleMonitor}
+			if failed > 0 {
+				exit(exitUnavailable)
+			}
+			return
 		}
 
-		fmt.Println(url)
 		table := tablewriter.NewWriter(os.Stdout)
 		table.SetHeader([]string{"Health", "Name", "Code", "Status"})
 		table.SetAutoWrapText(false)
 		table.SetHeaderAlignment(3)
 
 		for _, v := range responseMonitors.Monitors {
-			state := "Ok"
-			if !v.Passing {
-				state = "Failing"
-			}
-			table.Append([]string{state, v.Name, v.Code, v.Status})
+			table.Append([]string{v.State(), v.Name, v.Code, v.Status})
 		}
 
-		table.Render()
+		if len(responseMonitors.Monitors) > 0 || len(codes) == 0 {
+			table.Render()
+		}
+
+		if len(codes) > 1 {
+			fmt.Printf("%d succeeded, %d failed\n", len(codes)-failed, failed)
+		}
+
+		if failed > 0 {
+			exit(exitUnavailable)
+		}
 	},
 }
 
+func logStatusResponse(response []byte) {
+	buf := new(bytes.Buffer)
+	json.Indent(buf, response, "", "  ")
+	log("\nResponse:")
+	log(buf.String() + "\n")
+}
+
 func init() {
 	RootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&statusFromFile, "from-file", statusFromFile, "Read monitor codes from a file, one per line")
+	statusCmd.Flags().StringVar(&statusOutput, "output", "table", "Output format, table or template")
+	statusCmd.Flags().StringVar(&statusTemplate, "template", statusTemplate, "Go template printed for each monitor with --output=template, e.g. '{{.Name}} {{.State}}'")
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var testTimeout time.Duration
+
+var testCmd = &cobra.Command{
+	Use:   "test <key>",
+	Short: "Send a run and complete ping and verify Cronitor received them",
+	Long: `
+Smoke-test a monitor end to end. A run ping and a complete ping are sent, then the monitor's pings are fetched from the
+Cronitor API until both events appear or the timeout is reached. This verifies that both your ping API key and API key work.
+
+Note: The pings sent by this command are recorded in the monitor's history like any other event.
+
+Example:
+  $ cronitor test d3x0c1
+
+Example with a longer timeout:
+  $ cronitor test d3x0c1 --timeout 2m
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("a unique monitor key is required")
+		}
+
+		if err := requireApiKey(cmd); err != nil {
+			return err
+		}
+
+		return nil
+	},
+
+	Run: func(cmd *cobra.Command, args []string) {
+		var wg sync.WaitGroup
+		code := args[0]
+		startTime := time.Now()
+		series := formatStamp(makeStamp())
+		message := fmt.Sprintf("CronitorCLI test %s", series)
+
+		for _, endpoint := range []string{"run", "complete"} {
+			pingStart := time.Now()
+			wg.Add(1)
+			if err := sendPing(endpoint, code, message, series, makeStamp(), nil, nil, nil, &wg); err != nil {
+				printErrorText(fmt.Sprintf("✗ The %s ping failed: %s", endpoint, err.Error()), false)
+				fatal("Test failed", exitUnavailable)
+			}
+			printSuccessText(fmt.Sprintf("✔ The %s ping was delivered in %dms", endpoint, time.Since(pingStart).Milliseconds()), false)
+		}
+
+		url := fmt.Sprintf("%s/%s/pings", getCronitorApi().Url(), code)
+		deadline := startTime.Add(testTimeout)
+		for {
+			response, err := getCronitorApi().GetRawResponse(url)
+			if err != nil {
+				log(fmt.Sprintf("Request to %s failed: %s", url, err))
+			} else if strings.Count(string(response), series) >= 2 {
+				printDoneText(fmt.Sprintf("Test passed: both pings were recorded. Round trip %dms", time.Since(startTime).Milliseconds()), false)
+				return
+			}
+
+			if time.Now().After(deadline) {
+				printErrorText(fmt.Sprintf("✗ The pings were not recorded within %s", testTimeout), false)
+				fatal("Test failed", exitTimeout)
+			}
+
+			time.Sleep(2 * time.Second)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(testCmd)
+	testCmd.Flags().DurationVar(&testTimeout, "timeout", 30*time.Second, "How long to wait for the pings to be recorded")
+}
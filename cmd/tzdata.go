@@ -0,0 +1,12 @@
+//go:build tzdata
+// +build tzdata
+
+package cmd
+
+// Building with -tags tzdata embeds the IANA time zone database, about 450KB, so that time zones can be loaded on
+// hosts without one, e.g. scratch containers. The system database is still used when it exists.
+import _ "time/tzdata"
+
+func init() {
+	embeddedTzdata = true
+}
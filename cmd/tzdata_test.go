@@ -0,0 +1,25 @@
+//go:build tzdata
+// +build tzdata
+
+package cmd
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEmbeddedTzdataIsUsedWithoutSystemDatabase(t *testing.T) {
+	os.Setenv("ZONEINFO", "/nonexistent")
+	defer os.Unsetenv("ZONEINFO")
+
+	for _, name := range []string{"America/New_York", "Etc/GMT+5"} {
+		if _, err := time.LoadLocation(name); err != nil {
+			t.Errorf("Could not load %s from the embedded database: %s", name, err.Error())
+		}
+	}
+
+	if !embeddedTzdata {
+		t.Errorf("The embedded database was not registered")
+	}
+}
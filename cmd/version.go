@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// Commit and BuildDate are set at build time, e.g.
+// go build -ldflags "-X github.com/cronitorio/cronitor-cli/cmd.Commit=$(git rev-parse HEAD) -X github.com/cronitorio/cronitor-cli/cmd.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var Commit string
+var BuildDate string
+
+var versionJson bool
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version and build information",
+	Long: `
+Print the CronitorCLI version along with the git commit, build date, Go version and platform it was built for.
+
+Example:
+  $ cronitor version
+
+Example with machine-readable output:
+  $ cronitor version --json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		info := VersionInfo{
+			Version:   Version,
+			Commit:    valueOrUnknown(Commit),
+			BuildDate: valueOrUnknown(BuildDate),
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+		}
+
+		if versionJson {
+			b, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				fatal(err.Error(), exitInternal)
+			}
+			fmt.Println(string(b))
+			return
+		}
+
+		fmt.Println(shortDescription(info.Version))
+		fmt.Printf("Commit:      %s\n", info.Commit)
+		fmt.Printf("Build date:  %s\n", info.BuildDate)
+		fmt.Printf("Go version:  %s\n", info.GoVersion)
+		fmt.Printf("OS/Arch:     %s/%s\n", info.OS, info.Arch)
+	},
+}
+
+func valueOrUnknown(value string) string {
+	if len(value) == 0 {
+		return "unknown"
+	}
+
+	return value
+}
+
+func init() {
+	RootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().BoolVar(&versionJson, "json", versionJson, "Print version information as JSON")
+}
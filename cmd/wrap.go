@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/cronitorio/cronitor-cli/lib"
+	"github.com/spf13/cobra"
+)
+
+var wrapCode string
+
+var wrapCmd = &cobra.Command{
+	Use:   "wrap <command>",
+	Short: "Print the cronitor exec invocation that monitors a command",
+	Long: `
+Print the 'cronitor exec' invocation that discover would write into a crontab for a command, without running anything.
+Use it to add monitoring to crontabs you manage by hand.
+
+Quote the command, or put it after --, so that its flags are not read as flags of wrap. Commands that use ;, |, && or || are quoted so the whole command is run by exec. On Linux and MacOS the quoting is for
+the shell cron uses, and a \% in the command is kept as it is because cron removes the backslash. On Windows the
+command is quoted for PowerShell.
+
+Example:
+  $ cronitor wrap --code d3x0c1 "cd /var/app && ./backup.sh > /dev/null"
+  cronitor exec d3x0c1 "cd /var/app && ./backup.sh > /dev/null"
+
+Example with the flags discover would add:
+  $ cronitor wrap --code d3x0c1 --no-stdout --ping-api-key-map /etc/cronitor/ping-keys.txt /var/app/billing/run.sh
+  cronitor --ping-api-key 4f3e9a... --no-stdout exec d3x0c1 /var/app/billing/run.sh
+      > A --ping-api-key given to wrap is used when no pattern in the map matches the command
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("a command is required e.g. cronitor wrap --code d3x0c1 /path/to/command.sh")
+		}
+
+		if len(wrapCode) < 1 {
+			return errors.New("a monitor key is required, set it with --code")
+		}
+
+		if len(pingApiKeyMapFile) > 0 {
+			var err error
+			if pingApiKeyMap, err = readPingApiKeyMap(pingApiKeyMapFile); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		command := strings.Join(args, " ")
+		fmt.Println(wrapCommand(command, runtime.GOOS))
+	},
+}
+
+// wrapCommand returns the exec invocation for command, quoted for the shell used on goos
+func wrapCommand(command string, goos string) string {
+	key := pingApiKeyForCommand(command)
+	if len(key) == 0 && RootCmd.PersistentFlags().Changed("ping-api-key") {
+		key = pingApiKey
+	}
+
+	line := lib.Line{
+		CommandToRun: command,
+		Mon:          lib.Monitor{Code: wrapCode, PingApiKey: key, NoStdoutPassthru: noStdoutPassthru},
+	}
+
+	if goos == "windows" {
+		line.CommandToRun = ""
+		return line.Integration() + " " + powershellQuote(command)
+	}
+
+	return line.Integration()
+}
+
+// powershellQuote quotes s as a PowerShell literal string, where the only special character is '
+func powershellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func init() {
+	RootCmd.AddCommand(wrapCmd)
+	wrapCmd.Flags().StringVar(&wrapCode, "code", wrapCode, "Monitor key to report to")
+	wrapCmd.Flags().BoolVar(&noStdoutPassthru, "no-stdout", noStdoutPassthru, "Do not send cron job output to Cronitor when your job completes")
+	wrapCmd.Flags().StringVar(&pingApiKeyMapFile, "ping-api-key-map", pingApiKeyMapFile, "File of \"<pattern> <ping api key>\" lines used to add a --ping-api-key to matching commands")
+}
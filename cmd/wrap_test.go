@@ -0,0 +1,24 @@
+package cmd
+
+import "testing"
+
+func TestWrapCommand(t *testing.T) {
+	wrapCode = "d3x0c1"
+	defer func() { wrapCode = "" }()
+
+	tables := []struct {
+		command  string
+		goos     string
+		expected string
+	}{
+		{"/path/to/backup.sh --full", "linux", "cronitor exec d3x0c1 /path/to/backup.sh --full"},
+		{"backup.sh && echo $HOME", "linux", `cronitor exec d3x0c1 "backup.sh && echo \$HOME"`},
+		{"backup.ps1; Write-Output 'done'", "windows", "cronitor exec d3x0c1 'backup.ps1; Write-Output ''done'''"},
+	}
+
+	for _, table := range tables {
+		if actual := wrapCommand(table.command, table.goos); actual != table.expected {
+			t.Errorf("wrapCommand(%q, %s) was %s, expected %s", table.command, table.goos, actual, table.expected)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff describes jittered exponential backoff between retries. It is shared by pings and API requests so a
+// fleet of hosts that fail at the same moment don't retry in lockstep.
+type Backoff struct {
+	// Attempts is the total number of attempts, including the first. Zero or less means no retries.
+	Attempts int
+	// Base is the maximum delay before the first retry. Each later retry doubles it, up to Max.
+	Base time.Duration
+	Max  time.Duration
+	// MaxElapsed bounds the total time spent retrying. Zero means no limit.
+	MaxElapsed time.Duration
+}
+
+// Delay returns a random delay between 0 and the exponential cap for the given retry, starting at 1.
+func (b Backoff) Delay(retry int) time.Duration {
+	ceiling := b.Base
+	for i := 1; i < retry && ceiling < b.Max; i++ {
+		ceiling *= 2
+	}
+
+	if b.Max > 0 && ceiling > b.Max {
+		ceiling = b.Max
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Wait sleeps before the given retry. It returns false without sleeping when the delay would end after
+// MaxElapsed has passed since start, in which case the caller should stop retrying.
+func (b Backoff) Wait(retry int, start time.Time) bool {
+	return b.WaitAtLeast(retry, start, 0)
+}
+
+// WaitAtLeast is Wait with a minimum delay, e.g. the Retry-After of a 429 response
+func (b Backoff) WaitAtLeast(retry int, start time.Time, minimum time.Duration) bool {
+	delay := b.Delay(retry)
+	if delay < minimum {
+		delay = minimum
+	}
+
+	if b.MaxElapsed > 0 && time.Since(start)+delay > b.MaxElapsed {
+		return false
+	}
+
+	time.Sleep(delay)
+	return true
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelayIsCapped(t *testing.T) {
+	backoff := Backoff{Base: 10 * time.Millisecond, Max: 40 * time.Millisecond}
+	tables := []struct {
+		retry   int
+		ceiling time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{10, 40 * time.Millisecond},
+	}
+
+	for _, table := range tables {
+		for i := 0; i < 100; i++ {
+			if delay := backoff.Delay(table.retry); delay < 0 || delay > table.ceiling {
+				t.Fatalf("Delay(%d) = %s, expected at most %s", table.retry, delay, table.ceiling)
+			}
+		}
+	}
+}
+
+func TestBackoffWaitRespectsMaxElapsed(t *testing.T) {
+	backoff := Backoff{Base: time.Hour, Max: time.Hour, MaxElapsed: time.Millisecond}
+	start := time.Now().Add(-time.Second)
+	if backoff.Wait(1, start) {
+		t.Error("Wait should not sleep past MaxElapsed")
+	}
+}
+
+func newFlakyServer(failures int, status int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"monitors": []}`))
+	}))
+
+	return server, &requests
+}
+
+func TestSendRetriesUntilSuccess(t *testing.T) {
+	server, requests := newFlakyServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	api := CronitorApi{Backoff: Backoff{Attempts: 3, Base: time.Millisecond}, Logger: func(string) {}}
+	response, err := api.sendHttpPut(server.URL, `[]`)
+	if err != nil || !strings.Contains(string(response), "monitors") {
+		t.Errorf("Unexpected response %q, error %v", response, err)
+	}
+
+	if *requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", *requests)
+	}
+}
+
+func TestSendGivesUpAfterAttempts(t *testing.T) {
+	server, requests := newFlakyServer(5, http.StatusTooManyRequests)
+	defer server.Close()
+
+	api := CronitorApi{Backoff: Backoff{Attempts: 2, Base: time.Millisecond}, Logger: func(string) {}}
+	if _, err := api.GetRawResponse(server.URL); err == nil {
+		t.Error("Expected an error after exhausting retries")
+	}
+
+	if *requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", *requests)
+	}
+}
+
+func TestSendDoesNotRetryClientErrors(t *testing.T) {
+	server, requests := newFlakyServer(5, http.StatusForbidden)
+	defer server.Close()
+
+	api := CronitorApi{Backoff: Backoff{Attempts: 3, Base: time.Millisecond}, Logger: func(string) {}}
+	api.sendHttpGet(server.URL)
+
+	if *requests != 1 {
+		t.Errorf("Expected 1 request, got %d", *requests)
+	}
+}
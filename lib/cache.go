@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// cacheFile returns the path of the cache entry for url. Entries are keyed by a hash of the API key so
+// accounts sharing a host never read each other's monitors.
+func (api CronitorApi) cacheFile(url string) string {
+	account := sha256.Sum256([]byte(viper.GetString(api.ApiKey)))
+	request := sha256.Sum256([]byte(url))
+	return filepath.Join(api.CacheDir, fmt.Sprintf("%x-%x.json", account[:8], request[:8]))
+}
+
+// GetCachedRawResponse is GetRawResponse for responses that can be reused for api.CacheTTL. Caching is
+// disabled when CacheDir is empty or CacheTTL is zero.
+func (api CronitorApi) GetCachedRawResponse(url string) ([]byte, error) {
+	if len(api.CacheDir) == 0 || api.CacheTTL <= 0 {
+		return api.GetRawResponse(url)
+	}
+
+	path := api.cacheFile(url)
+	if stat, err := os.Stat(path); err == nil && time.Since(stat.ModTime()) < api.CacheTTL {
+		if contents, err := ioutil.ReadFile(path); err == nil {
+			api.log("Using cached response from " + path)
+			return contents, nil
+		}
+	}
+
+	contents, err := api.GetRawResponse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(api.CacheDir, 0700); err == nil {
+		if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+			api.log("Could not write cache file: " + err.Error())
+		}
+	}
+
+	return contents, nil
+}
+
+// ClearCache removes every cache entry in cacheDir and returns the number of entries removed
+func ClearCache(cacheDir string) (int, error) {
+	files, err := ioutil.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(cacheDir, file.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
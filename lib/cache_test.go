@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetCachedRawResponse(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"monitors": []}`))
+	}))
+	defer server.Close()
+
+	cacheDir, _ := ioutil.TempDir("", "cronitor-cache-test")
+	defer os.RemoveAll(cacheDir)
+
+	viper.Set("CACHE_TEST_API_KEY", "account-one")
+	api := CronitorApi{ApiKey: "CACHE_TEST_API_KEY", CacheDir: cacheDir, CacheTTL: time.Minute, Logger: func(string) {}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetCachedRawResponse(server.URL); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+
+	if stat, err := os.Stat(api.cacheFile(server.URL)); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("Expected a cache file with 0600 permissions, got %v, %v", stat, err)
+	}
+
+	viper.Set("CACHE_TEST_API_KEY", "account-two")
+	api.GetCachedRawResponse(server.URL)
+	if requests != 2 {
+		t.Errorf("A different API key should not use the cached response")
+	}
+
+	if removed, err := ClearCache(cacheDir); err != nil || removed != 2 {
+		t.Errorf("Expected 2 cache files to be removed, got %d, %v", removed, err)
+	}
+
+	api.GetCachedRawResponse(server.URL)
+	if requests != 3 {
+		t.Errorf("A cleared cache should not be used")
+	}
+}
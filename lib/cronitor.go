@@ -3,18 +3,87 @@ package lib
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/tls"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"fmt"
 	"github.com/getsentry/raven-go"
 	"github.com/spf13/viper"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Transport is shared by every request CronitorCLI makes so connections are reused and
+// protocol settings apply to pings and API calls alike.
+var Transport = http.DefaultTransport.(*http.Transport).Clone()
+
+// DisableHTTP2 forces HTTP/1.1 for all requests, for networks where HTTP/2 misbehaves behind proxies
+func DisableHTTP2() {
+	Transport.ForceAttemptHTTP2 = false
+	Transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+}
+
+// DisableTLSVerification skips certificate verification, for testing against relays with self-signed certificates
+func DisableTLSVerification() {
+	tlsClientConfig().InsecureSkipVerify = true
+}
+
+// RequireTLS13 only allows TLS 1.3 connections and refuses to send any request over plain HTTP
+func RequireTLS13() {
+	tlsClientConfig().MinVersion = tls.VersionTLS13
+	tlsClientConfig().MaxVersion = tls.VersionTLS13
+	Transport.RegisterProtocol("http", plainHTTPRejecter{})
+}
+
+// UseSocks5Proxy sends every request through a SOCKS5 proxy. The address is host:port, optionally with credentials as
+// user:password@host:port, or a socks5:// or socks5h:// URL. Host names are always resolved by the proxy.
+func UseSocks5Proxy(address string) error {
+	if !strings.Contains(address, "://") {
+		address = "socks5://" + address
+	}
+
+	proxyUrl, err := url.Parse(address)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid SOCKS5 proxy address: %s", err.Error()))
+	}
+
+	if proxyUrl.Scheme != "socks5" && proxyUrl.Scheme != "socks5h" {
+		return errors.New(fmt.Sprintf("invalid SOCKS5 proxy address %s, expecting host:port or a socks5:// URL", proxyUrl.Host))
+	}
+
+	if len(proxyUrl.Hostname()) == 0 || len(proxyUrl.Port()) == 0 {
+		return errors.New(fmt.Sprintf("invalid SOCKS5 proxy address %s, expecting host:port", proxyUrl.Host))
+	}
+
+	// net/http implements SOCKS5 for the socks5 scheme and always sends host names to the proxy
+	proxyUrl.Scheme = "socks5"
+	Transport.Proxy = http.ProxyURL(proxyUrl)
+	return nil
+}
+
+// plainHTTPRejecter is registered for the http scheme so that no request leaves the host unencrypted
+type plainHTTPRejecter struct{}
+
+func (plainHTTPRejecter) RoundTrip(request *http.Request) (*http.Response, error) {
+	return nil, errors.New(fmt.Sprintf("refusing to send a request to %s over plain HTTP because TLS 1.3 is required", request.URL.Host))
+}
+
+func tlsClientConfig() *tls.Config {
+	if Transport.TLSClientConfig == nil {
+		Transport.TLSClientConfig = &tls.Config{}
+	}
+
+	return Transport.TLSClientConfig
+}
+
 type RuleValue string
 
 type Rule struct {
@@ -35,7 +104,9 @@ type Monitor struct {
 	Timezone         string              `json:"timezone,omitempty"`
 	Note             string              `json:"defaultNote,omitempty"`
 	Notifications    map[string][]string `json:"notifications,omitempty"`
+	Assertions       []string            `json:"assertions,omitempty"`
 	NoStdoutPassthru bool                `json:"-"`
+	PingApiKey       string              `json:"-"`
 }
 
 type MonitorSummary struct {
@@ -48,9 +119,17 @@ type MonitorSummary struct {
 type CronitorApi struct {
 	IsDev          bool
 	IsAutoDiscover bool
+	ApiUrl         string
 	ApiKey         string
 	UserAgent      string
-	Logger         func(string)
+	RequestId      string
+	Headers        http.Header
+	Backoff        Backoff
+	CacheDir       string
+	CacheTTL       time.Duration
+	// PageConcurrency is the number of monitor list pages fetched at a time after the first. Less than 1 means 1.
+	PageConcurrency int
+	Logger          func(string)
 }
 
 
@@ -103,6 +182,11 @@ func (api CronitorApi) PutMonitors(monitors map[string]*Monitor) (map[string]*Mo
 		return nil, errors.New(fmt.Sprintf("Error from %s: %s", url, response))
 	}
 
+	// Cached monitor lists no longer reflect what was just saved
+	if len(api.CacheDir) > 0 {
+		ClearCache(api.CacheDir)
+	}
+
 	for _, value := range responseMonitors {
 		// We only need to update the Monitor struct with a code if this is a new monitor.
 		// For updates the monitor code is sent as well as the key and that takes precedence.
@@ -115,65 +199,142 @@ func (api CronitorApi) PutMonitors(monitors map[string]*Monitor) (map[string]*Mo
 	return monitors, nil
 }
 
+// MonitorExists reports whether a monitor with the given key or code exists
+func (api CronitorApi) MonitorExists(key string) (bool, error) {
+	url := fmt.Sprintf("%s/%s", api.Url(), key)
+	_, statusCode, err := api.send("GET", url, "", 0)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Request to %s failed: %s", url, err))
+	}
+
+	switch statusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, errors.New(fmt.Sprintf("Unexpected %d API response from %s", statusCode, url))
+	}
+}
+
+// GetMonitorSchedule returns the cron schedule and timezone of a monitor. The schedule is empty for monitors that
+// are not scheduled with a cron expression.
+func (api CronitorApi) GetMonitorSchedule(key string) (string, string, error) {
+	url := fmt.Sprintf("%s/%s", api.Url(), key)
+	response, err := api.GetRawResponse(url)
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("Request to %s failed: %s", url, err))
+	}
+
+	monitor := struct {
+		Schedule string `json:"schedule"`
+		Timezone string `json:"timezone"`
+	}{}
+	if err = json.Unmarshal(response, &monitor); err != nil {
+		return "", "", errors.New(fmt.Sprintf("Error from %s: %s", url, err.Error()))
+	}
+
+	return monitor.Schedule, monitor.Timezone, nil
+}
+
+type monitorsPage struct {
+	TotalMonitorCount int              `json:"total_monitor_count"`
+	PageSize          int              `json:"page_size"`
+	Monitors          []MonitorSummary `json:"monitors"`
+}
+
+// GetMonitors returns every monitor in the account. The first page gives the number of pages, and the rest are
+// fetched api.PageConcurrency at a time and reassembled in order.
 func (api CronitorApi) GetMonitors() ([]MonitorSummary, error) {
-	url := api.Url()
-	page := 1
-	monitors := []MonitorSummary{}
+	first, err := api.getMonitorsPage(1)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		response, err := api.sendHttpGet(fmt.Sprintf("%s?page=%d", url, page))
-		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Request to %s failed: %s", url, err))
-		}
+	pages := 1
+	if first.PageSize > 0 && first.TotalMonitorCount > first.PageSize {
+		pages = (first.TotalMonitorCount + first.PageSize - 1) / first.PageSize
+	}
 
-		type ExpectedResponse struct {
-			TotalMonitorCount int              `json:"total_monitor_count"`
-			PageSize          int              `json:"page_size"`
-			Monitors          []MonitorSummary `json:"monitors"`
-		}
+	concurrency := api.PageConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-		responseMonitors := ExpectedResponse{}
-		if err = json.Unmarshal(response, &responseMonitors); err != nil {
-			return nil, errors.New(fmt.Sprintf("Error from %s: %s", url, err.Error()))
-		}
+	results := make([][]MonitorSummary, pages)
+	errs := make([]error, pages)
+	results[0] = first.Monitors
 
-		monitors = append(monitors, responseMonitors.Monitors...)
-		if page*responseMonitors.PageSize >= responseMonitors.TotalMonitorCount {
-			break
-		}
+	var failed int32
+	var workers sync.WaitGroup
+	queue := make(chan int)
+	for i := 0; i < concurrency && i < pages-1; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for page := range queue {
+				response, err := api.getMonitorsPage(page)
+				if err != nil {
+					errs[page-1] = err
+					atomic.StoreInt32(&failed, 1)
+					continue
+				}
+				results[page-1] = response.Monitors
+			}
+		}()
+	}
 
-		page += 1
+	// Stop handing out pages after a failure, the result would be incomplete anyway
+	for page := 2; page <= pages && atomic.LoadInt32(&failed) == 0; page++ {
+		queue <- page
+	}
+	close(queue)
+	workers.Wait()
+
+	monitors := []MonitorSummary{}
+	for i := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		monitors = append(monitors, results[i]...)
 	}
 
 	return monitors, nil
 }
 
-func (api CronitorApi) GetRawResponse(url string) ([]byte, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest("GET", url, nil)
-	request.SetBasicAuth(viper.GetString(api.ApiKey), "")
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("User-Agent", api.UserAgent)
-	response, err := client.Do(request)
+func (api CronitorApi) getMonitorsPage(page int) (monitorsPage, error) {
+	url := api.Url()
+	responseMonitors := monitorsPage{}
+	response, err := api.GetCachedRawResponse(fmt.Sprintf("%s?page=%d", url, page))
 	if err != nil {
-		return nil, err
+		return responseMonitors, errors.New(fmt.Sprintf("Request to %s failed: %s", url, err))
 	}
 
-	if response.StatusCode != 200 {
-		return nil, errors.New(fmt.Sprintf("Unexpected %d API response", response.StatusCode))
+	if err = json.Unmarshal(response, &responseMonitors); err != nil {
+		return responseMonitors, errors.New(fmt.Sprintf("Error from %s: %s", url, err.Error()))
 	}
 
-	defer response.Body.Close()
-	contents, err := ioutil.ReadAll(response.Body)
+	return responseMonitors, nil
+}
+
+func (api CronitorApi) GetRawResponse(url string) ([]byte, error) {
+	contents, statusCode, err := api.send("GET", url, "", 0)
 	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
 		return nil, err
 	}
 
+	if statusCode != 200 {
+		return nil, errors.New(fmt.Sprintf("Unexpected %d API response", statusCode))
+	}
+
 	return contents, nil
 }
 
 func (api CronitorApi) Url() string {
+	if len(api.ApiUrl) > 0 {
+		return api.ApiUrl + "/monitors"
+	}
+
 	if api.IsDev {
 		return "http://dev.cronitor.io/v3/monitors"
 	} else {
@@ -181,51 +342,172 @@ func (api CronitorApi) Url() string {
 	}
 }
 
+// NormalizeApiUrl validates a custom API base URL and returns it without a trailing slash or "/monitors",
+// so both https://example.com/v3 and https://example.com/v3/monitors are accepted.
+func NormalizeApiUrl(apiUrl string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(apiUrl))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return "", errors.New(fmt.Sprintf("invalid API URL %s: expected a URL like https://cronitor.io/v3", apiUrl))
+	}
+
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	normalized := strings.TrimRight(parsed.String(), "/")
+	normalized = strings.TrimSuffix(normalized, "/monitors")
+	return normalized, nil
+}
+
 func (api CronitorApi) sendHttpPut(url string, body string) ([]byte, error) {
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	request, err := http.NewRequest("PUT", url, strings.NewReader(body))
-	request.SetBasicAuth(viper.GetString(api.ApiKey), "")
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("User-Agent", api.UserAgent)
-	request.ContentLength = int64(len(body))
-	response, err := client.Do(request)
-	if err != nil {
-		return nil, err
+	contents, _, err := api.send("PUT", url, body, 120*time.Second)
+	return contents, err
+}
+
+func (api CronitorApi) sendHttpGet(url string) ([]byte, error) {
+	contents, _, err := api.send("GET", url, "", 120*time.Second)
+	return contents, err
+}
+
+// rateLimit holds back every API request until the Retry-After of the last 429 response has passed, so requests
+// made at the same time, e.g. for monitor list pages, back off together
+var rateLimit struct {
+	sync.Mutex
+	until time.Time
+}
+
+func waitForRateLimit() {
+	rateLimit.Lock()
+	delay := time.Until(rateLimit.until)
+	rateLimit.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
 	}
+}
 
-	defer response.Body.Close()
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
-		return nil, err
+func holdRateLimit(delay time.Duration) {
+	rateLimit.Lock()
+	defer rateLimit.Unlock()
+
+	if until := time.Now().Add(delay); until.After(rateLimit.until) {
+		rateLimit.until = until
 	}
+}
 
-	return contents, nil
+// ParseRetryAfter returns the delay of a Retry-After header in seconds or as an HTTP date, or 0 when it is not set
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
 }
 
-func (api CronitorApi) sendHttpGet(url string) ([]byte, error) {
+// send makes an API request, retrying network errors, 429 and 5xx responses with api.Backoff. A 429 response is
+// retried no sooner than its Retry-After, and other API requests wait for it too.
+// The body and status code of the last response are returned.
+func (api CronitorApi) send(method string, url string, body string, timeout time.Duration) ([]byte, int, error) {
 	client := &http.Client{
-		Timeout: 120 * time.Second,
+		Transport: Transport,
+		Timeout:   timeout,
 	}
-	request, err := http.NewRequest("GET", url, nil)
-	request.SetBasicAuth(viper.GetString(api.ApiKey), "")
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("User-Agent", api.UserAgent)
-	response, err := client.Do(request)
-	if err != nil {
-		return nil, err
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		request, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			return nil, 0, err
+		}
+		AddHeaders(request, api.Headers)
+		request.SetBasicAuth(viper.GetString(api.ApiKey), "")
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Add("User-Agent", api.UserAgent)
+		request.Header.Add("Accept-Encoding", "gzip")
+		if len(api.RequestId) > 0 {
+			request.Header.Add("X-Cronitor-Request-Id", api.RequestId)
+		}
+		if len(body) > 0 {
+			request.ContentLength = int64(len(body))
+		}
+		waitForRateLimit()
+		CountRequest(attempt)
+
+		var contents []byte
+		statusCode := 0
+		retryAfter := time.Duration(0)
+		response, err := client.Do(request)
+		if err == nil {
+			statusCode = response.StatusCode
+			if statusCode == http.StatusTooManyRequests {
+				retryAfter = ParseRetryAfter(response.Header.Get("Retry-After"))
+			}
+			api.log(fmt.Sprintf("Received %d API response over %s", response.StatusCode, response.Proto))
+			contents, err = readResponseBody(response)
+			response.Body.Close()
+			if err != nil {
+				raven.CaptureErrorAndWait(err, nil)
+				return nil, statusCode, err
+			}
+		}
+
+		retryable := err != nil || statusCode == http.StatusTooManyRequests || statusCode >= 500
+		if !retryable || attempt >= api.Backoff.Attempts {
+			return contents, statusCode, err
+		}
+
+		if err != nil {
+			api.log(fmt.Sprintf("API request failed, retrying: %s", err.Error()))
+		} else if retryAfter > 0 {
+			api.log(fmt.Sprintf("Unexpected %d API response, retrying after %s", statusCode, retryAfter))
+			if api.Backoff.MaxElapsed <= 0 || time.Since(start)+retryAfter <= api.Backoff.MaxElapsed {
+				holdRateLimit(retryAfter)
+			}
+		} else {
+			api.log(fmt.Sprintf("Unexpected %d API response, retrying", statusCode))
+		}
+
+		if !api.Backoff.WaitAtLeast(attempt, start, retryAfter) {
+			api.log("Not retrying API request, the retry time limit was reached")
+			return contents, statusCode, err
+		}
 	}
+}
 
-	defer response.Body.Close()
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
-		return nil, err
+// AddHeaders adds custom headers, e.g. from --header, to a request before CronitorCLI sets its own
+func AddHeaders(request *http.Request, headers http.Header) {
+	for name, values := range headers {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
 	}
+}
 
-	return contents, nil
+func (api CronitorApi) log(message string) {
+	if api.Logger != nil {
+		api.Logger(message)
+	}
+}
+
+// readResponseBody reads an API response, decompressing it when the server honored our Accept-Encoding header.
+// Because the header is set explicitly, the transport leaves the decompression to us.
+func readResponseBody(response *http.Response) ([]byte, error) {
+	var body io.Reader = response.Body
+	if strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	return ioutil.ReadAll(body)
 }
 
 func gzipLogData(logData string) *bytes.Buffer {
@@ -246,34 +528,42 @@ func gzipLogData(logData string) *bytes.Buffer {
 	return &b
 }
 
-func getPresignedUrl(apiKey string, postBody []byte) ([]byte, error) {
-	url := "https://cronitor.io/api/logs/presign"
+// LogsPresignUrl returns the endpoint that hands out upload URLs for job output logs. It is not versioned, so with a
+// custom API URL it is next to the version path, e.g. https://relay.internal/api/logs/presign for https://relay.internal/v3
+func (api CronitorApi) LogsPresignUrl() string {
+	if len(api.ApiUrl) > 0 {
+		if parsed, err := url.Parse(api.ApiUrl); err == nil {
+			dir := path.Dir(parsed.Path)
+			if dir == "." || dir == "/" {
+				dir = ""
+			}
+			parsed.Path = dir + "/api/logs/presign"
+			return parsed.String()
+		}
+	}
 
-	client := &http.Client{Timeout: 120 * time.Second}
-	request, err := http.NewRequest("POST", url, strings.NewReader(string(postBody)))
-	if err != nil {
-		return nil, errors.Wrap(err, "could not create request for URL presign")
+	if api.IsDev {
+		return "http://dev.cronitor.io/api/logs/presign"
+	} else {
+		return "https://cronitor.io/api/logs/presign"
 	}
-	request.SetBasicAuth(apiKey, "")
-	request.Header.Add("Content-Type", "application/json")
-	response, err := client.Do(request)
+}
+
+func (api CronitorApi) getPresignedUrl(postBody []byte) ([]byte, error) {
+	contents, statusCode, err := api.send("POST", api.LogsPresignUrl(), string(postBody), 120*time.Second)
 	if err != nil {
 		return nil, errors.Wrap(err, "error requesting presigned url")
 	}
-	if response.StatusCode != 200 && response.StatusCode != 201 {
-		return nil, fmt.Errorf("error response code %d returned", response.StatusCode)
+	if statusCode != 200 && statusCode != 201 {
+		return nil, fmt.Errorf("error response code %d returned", statusCode)
 	}
 
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
-	response.Body = ioutil.NopCloser(bytes.NewBuffer(contents))
 	return contents, nil
 }
 
-func SendLogData(apiKey string, monitorKey string, seriesID string, outputLogs string) ([]byte, error) {
+// SendLogData uploads job output logs. The upload URL is requested like any other API call, with the custom headers,
+// User-Agent and request id, and the logs are then sent straight to that URL.
+func (api CronitorApi) SendLogData(monitorKey string, seriesID string, outputLogs string) ([]byte, error) {
 	gzippedLogs := gzipLogData(outputLogs)
 	jsonBytes, err := json.Marshal(map[string]string{
 		"job_key": monitorKey,
@@ -285,7 +575,7 @@ func SendLogData(apiKey string, monitorKey string, seriesID string, outputLogs s
 	var responseJson struct {
 		Url string `json:"url"`
 	}
-	response, err := getPresignedUrl(apiKey, jsonBytes)
+	response, err := api.getPresignedUrl(jsonBytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating presign url for log uploading")
 	}
@@ -301,8 +591,10 @@ func SendLogData(apiKey string, monitorKey string, seriesID string, outputLogs s
 		return nil, err
 	}
 	client := &http.Client{
-		Timeout: 120 * time.Second,
+		Transport: Transport,
+		Timeout:   120 * time.Second,
 	}
+	CountRequest(1)
 	response2, err := client.Do(req)
 	if err != nil || response == nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error putting logs: %v", response2))
@@ -2,6 +2,8 @@ package lib
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +13,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -100,9 +103,23 @@ func (c *Crontab) Parse(noAutoDiscover bool) (error, int) {
 
 		// If this job is already being wrapped by the Cronitor client, read current code.
 		// Expects a wrapped command to look like: cronitor exec d3x0 /path/to/cmd.sh
-		if len(command) > 1 && strings.HasSuffix(command[0], "cronitor") && command[1] == "exec" {
-			line.Code = command[2]
-			command = command[3:]
+		// Global flags like --ping-api-key may appear between "cronitor" and "exec". The ping API key is kept so
+		// the integration can be written again with it.
+		if len(command) > 1 && strings.HasSuffix(command[0], "cronitor") {
+			pingApiKey := ""
+			for i := 1; i < len(command)-1; i++ {
+				if command[i] == "-p" || command[i] == "--ping-api-key" {
+					pingApiKey = command[i+1]
+					i++
+				} else if strings.HasPrefix(command[i], "--ping-api-key=") {
+					pingApiKey = strings.TrimPrefix(command[i], "--ping-api-key=")
+				} else if command[i] == "exec" {
+					line.Code = command[i+1]
+					line.PingApiKey = pingApiKey
+					command = command[i+2:]
+					break
+				}
+			}
 		}
 
 		line.CommandToRun = strings.Join(command, " ")
@@ -262,6 +279,7 @@ type Line struct {
 	CronExpression string
 	CommandToRun   string
 	Code           string
+	PingApiKey     string
 	RunAs          string
 	Mon            Monitor
 }
@@ -299,20 +317,7 @@ func (l Line) Write() string {
 	lineParts = append(lineParts, l.RunAs)
 
 	if len(l.Mon.Code) > 0 {
-		lineParts = append(lineParts, "cronitor")
-		if l.Mon.NoStdoutPassthru {
-			lineParts = append(lineParts, "--no-stdout")
-		}
-		lineParts = append(lineParts, "exec")
-		lineParts = append(lineParts, l.Mon.Code)
-
-		if len(l.CommandToRun) > 0 {
-			if l.CommandIsComplex() {
-				lineParts = append(lineParts, "\""+strings.Replace(l.CommandToRun, "\"", "\\\"", -1)+"\"")
-			} else {
-				lineParts = append(lineParts, l.CommandToRun)
-			}
-		}
+		lineParts = append(lineParts, l.Integration())
 	} else {
 		return l.FullLine
 	}
@@ -320,6 +325,49 @@ func (l Line) Write() string {
 	return strings.Replace(strings.Join(lineParts, " "), "  ", " ", -1)
 }
 
+// Integration returns the cronitor exec invocation that runs the line's command with monitoring
+func (l Line) Integration() string {
+	var parts []string
+	parts = append(parts, "cronitor")
+	if len(l.Mon.PingApiKey) > 0 {
+		parts = append(parts, "--ping-api-key", l.Mon.PingApiKey)
+	}
+	if l.Mon.NoStdoutPassthru {
+		parts = append(parts, "--no-stdout")
+	}
+	parts = append(parts, "exec")
+	parts = append(parts, l.Mon.Code)
+
+	if len(l.CommandToRun) > 0 {
+		if l.CommandIsComplex() {
+			parts = append(parts, doubleQuote(l.CommandToRun))
+		} else {
+			parts = append(parts, l.CommandToRun)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// doubleQuote quotes a command for a POSIX shell so that it reaches exec unchanged. Characters that are special
+// inside double quotes are escaped so variables and command substitutions are expanded by the shell exec runs.
+// A \% is left as it is because cron removes the backslash before the shell sees the line.
+func doubleQuote(command string) string {
+	var quoted strings.Builder
+	quoted.WriteString("\"")
+	runes := []rune(command)
+	for i, r := range runes {
+		switch {
+		case r == '\\' && i+1 < len(runes) && runes[i+1] == '%':
+		case r == '"', r == '\\', r == '$', r == '`':
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteString("\"")
+	return quoted.String()
+}
+
 func (l Line) Key(CanonicalPath string) string {
 	var CommandToRun, RunAs, CronExpression string
 	if l.IsAutoDiscoverCommand() {
@@ -333,12 +381,107 @@ func (l Line) Key(CanonicalPath string) string {
 		CronExpression = l.CronExpression
 	}
 
+	return createKey(CommandToRun, CronExpression, RunAs)
+}
+
+// NormalizedKey is Key computed from the normalized command, so trivial edits to a command do not change the key
+func (l Line) NormalizedKey(CanonicalPath string, sortFlags bool) string {
+	if l.IsAutoDiscoverCommand() {
+		return l.Key(CanonicalPath)
+	}
+
+	return createKey(NormalizeCommand(l.CommandToRun, sortFlags), l.CronExpression, l.RunAs)
+}
+
+// DeterministicCode is a monitor code derived from the hostname, normalized command, schedule and user, so the same
+// job on the same host is always given the same code. The code is the first length hex characters of a SHA-256 digest.
+func (l Line) DeterministicCode(hostname string, sortFlags bool, length int) string {
+	data := []byte(fmt.Sprintf("%s-%s-%s-%s", hostname, NormalizeCommand(l.CommandToRun, sortFlags), l.CronExpression, l.RunAs))
+	sum := sha256.Sum256(data)
+	code := hex.EncodeToString(sum[:])
+	if length > 0 && length < len(code) {
+		code = code[:length]
+	}
+
+	return code
+}
+
+func createKey(CommandToRun, CronExpression, RunAs string) string {
 	// Always use os.Hostname when creating a key so the key does not change when a user modifies their hostname using param/var
 	hostname, _ := os.Hostname()
 	data := []byte(fmt.Sprintf("%s-%s-%s-%s", hostname, CommandToRun, CronExpression, RunAs))
 	return fmt.Sprintf("%x", sha1.Sum(data))
 }
 
+var leadingCdPattern = regexp.MustCompile(`^cd\s+("[^"]*"|'[^']*'|\S+)\s*&&\s*`)
+
+// NormalizeCommand returns a canonical form of a cron command for matching jobs across trivial edits:
+//   - Leading "cd <dir> &&" wrappers are removed
+//   - Runs of whitespace are collapsed to a single space
+//   - With sortFlags, the flags of each simple command are sorted and moved ahead of its other arguments. Segments
+//     containing quotes are left in order because quoted arguments cannot be split on whitespace.
+func NormalizeCommand(command string, sortFlags bool) string {
+	command = strings.Join(strings.Fields(command), " ")
+	for leadingCdPattern.MatchString(command) {
+		command = leadingCdPattern.ReplaceAllString(command, "")
+	}
+
+	if !sortFlags {
+		return command
+	}
+
+	var normalized, segment []string
+	for _, word := range strings.Fields(command) {
+		if isShellOperator(word) {
+			normalized = append(normalized, sortCommandFlags(segment)...)
+			normalized = append(normalized, word)
+			segment = nil
+		} else {
+			segment = append(segment, word)
+		}
+	}
+
+	normalized = append(normalized, sortCommandFlags(segment)...)
+	return strings.Join(normalized, " ")
+}
+
+func isShellOperator(word string) bool {
+	switch word {
+	case "|", "||", "&&", ";", "&":
+		return true
+	}
+
+	return strings.ContainsAny(word, "<>;|")
+}
+
+// sortCommandFlags sorts the words after the program name that begin with "-". A flag value given as a separate
+// word is treated as an argument, so "-o out -v" and "-v -o out" both become "-o -v out".
+func sortCommandFlags(segment []string) []string {
+	if len(segment) < 3 {
+		return segment
+	}
+
+	for _, word := range segment {
+		if strings.ContainsAny(word, `"'`) {
+			return segment
+		}
+	}
+
+	var flags, arguments []string
+	for _, word := range segment[1:] {
+		if strings.HasPrefix(word, "-") {
+			flags = append(flags, word)
+		} else {
+			arguments = append(arguments, word)
+		}
+	}
+
+	sort.Strings(flags)
+	sorted := []string{segment[0]}
+	sorted = append(sorted, flags...)
+	return append(sorted, arguments...)
+}
+
 func createAutoDiscoverLine(crontab *Crontab) *Line {
 	cronExpression := fmt.Sprintf("%d * * * *", randomMinute())
 	if crontab.UsesSixFieldExpressions {
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCommand(t *testing.T) {
+	tables := []struct {
+		command   string
+		sortFlags bool
+		expected  string
+	}{
+		{"/usr/bin/backup.sh   --full\t-q", false, "/usr/bin/backup.sh --full -q"},
+		{"cd /var/app && ./backup.sh", false, "./backup.sh"},
+		{"cd \"/var/my app\" &&  cd lib && ./backup.sh", false, "./backup.sh"},
+		{"./backup.sh && cd /tmp && ls", false, "./backup.sh && cd /tmp && ls"},
+		{"./backup.sh --verbose -q", false, "./backup.sh --verbose -q"},
+		{"./backup.sh --verbose -q", true, "./backup.sh --verbose -q"},
+		{"./backup.sh -q db1 --verbose", true, "./backup.sh --verbose -q db1"},
+		{"./backup.sh -o out -v", true, "./backup.sh -o -v out"},
+		{"./backup.sh -v -o out", true, "./backup.sh -o -v out"},
+		{"./a.sh -z -a | ./b.sh -y -b > /dev/null 2>&1", true, "./a.sh -a -z | ./b.sh -b -y > /dev/null 2>&1"},
+		{"./a.sh -z -a \"two words\"", true, "./a.sh -z -a \"two words\""},
+	}
+
+	for _, table := range tables {
+		if actual := NormalizeCommand(table.command, table.sortFlags); actual != table.expected {
+			t.Errorf("NormalizeCommand(%q, %t) was %q, expected %q", table.command, table.sortFlags, actual, table.expected)
+		}
+	}
+}
+
+func TestNormalizedKey(t *testing.T) {
+	a := Line{CronExpression: "0 * * * *", CommandToRun: "cd /var/app && ./backup.sh  -q"}
+	b := Line{CronExpression: "0 * * * *", CommandToRun: "./backup.sh -q"}
+	c := Line{CronExpression: "5 * * * *", CommandToRun: "./backup.sh -q"}
+
+	if a.Key("") == b.Key("") {
+		t.Errorf("Expected exact keys to differ")
+	}
+
+	if a.NormalizedKey("", false) != b.NormalizedKey("", false) {
+		t.Errorf("Expected normalized keys to match")
+	}
+
+	if b.NormalizedKey("", false) == c.NormalizedKey("", false) {
+		t.Errorf("Expected the schedule to be part of the normalized key")
+	}
+}
+
+func TestDeterministicCode(t *testing.T) {
+	a := Line{CronExpression: "0 * * * *", CommandToRun: "cd /var/app && ./backup.sh  -q"}
+	b := Line{CronExpression: "0 * * * *", CommandToRun: "./backup.sh -q"}
+	c := Line{CronExpression: "5 * * * *", CommandToRun: "./backup.sh -q"}
+
+	if code := a.DeterministicCode("web-1", false, 12); len(code) != 12 || code != b.DeterministicCode("web-1", false, 12) {
+		t.Errorf("Expected normalized commands to have the same 12 character code, got %s and %s", code, b.DeterministicCode("web-1", false, 12))
+	}
+
+	if a.DeterministicCode("web-1", false, 12) == a.DeterministicCode("web-2", false, 12) {
+		t.Errorf("Expected the hostname to be part of the code")
+	}
+
+	if b.DeterministicCode("web-1", false, 12) == c.DeterministicCode("web-1", false, 12) {
+		t.Errorf("Expected the schedule to be part of the code")
+	}
+
+	if long := a.DeterministicCode("web-1", false, 16); long[:12] != a.DeterministicCode("web-1", false, 12) {
+		t.Errorf("Expected a longer code to extend the shorter one, got %s", long)
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	tables := []struct {
+		line     Line
+		expected string
+	}{
+		{Line{CommandToRun: "/usr/bin/backup.sh --full", Mon: Monitor{Code: "d3x0c1"}}, "cronitor exec d3x0c1 /usr/bin/backup.sh --full"},
+		{Line{CommandToRun: "/usr/bin/backup.sh", Mon: Monitor{Code: "d3x0c1", PingApiKey: "4f3e", NoStdoutPassthru: true}}, "cronitor --ping-api-key 4f3e --no-stdout exec d3x0c1 /usr/bin/backup.sh"},
+		{Line{CommandToRun: `cd /app && echo "$HOME" | grep -c \\ > out`, Mon: Monitor{Code: "d3x0c1"}}, `cronitor exec d3x0c1 "cd /app && echo \"\$HOME\" | grep -c \\\\ > out"`},
+		{Line{CommandToRun: "date +\\%Y; echo `hostname`", Mon: Monitor{Code: "d3x0c1"}}, "cronitor exec d3x0c1 \"date +\\%Y; echo \\`hostname\\`\""},
+	}
+
+	for _, table := range tables {
+		if actual := table.line.Integration(); actual != table.expected {
+			t.Errorf("Integration() was %s, expected %s", actual, table.expected)
+		}
+	}
+}
+
+func TestParseKeepsPingApiKey(t *testing.T) {
+	tables := []struct {
+		fullLine string
+		expected string
+	}{
+		{"0 2 * * * cronitor -p 4f3e exec d3x0c1 /usr/bin/backup.sh", "4f3e"},
+		{"0 2 * * * cronitor --ping-api-key 4f3e --no-stdout exec d3x0c1 /usr/bin/backup.sh", "4f3e"},
+		{"0 2 * * * cronitor --ping-api-key=4f3e exec d3x0c1 /usr/bin/backup.sh", "4f3e"},
+		{"0 2 * * * cronitor exec d3x0c1 /usr/bin/backup.sh", ""},
+	}
+
+	for _, table := range tables {
+		file, err := ioutil.TempFile("", "crontab")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		file.WriteString(table.fullLine)
+		file.Close()
+
+		crontab := &Crontab{Filename: file.Name()}
+		if err, _ := crontab.Parse(true); err != nil {
+			t.Fatal(err)
+		}
+
+		line := crontab.Lines[0]
+		if line.Code != "d3x0c1" || line.CommandToRun != "/usr/bin/backup.sh" || line.PingApiKey != table.expected {
+			t.Errorf("Parse(%s) read code %s, command %s and ping API key %s", table.fullLine, line.Code, line.CommandToRun, line.PingApiKey)
+		}
+
+		if written := crontab.Write(); written != table.fullLine {
+			t.Errorf("Write() was %s, expected %s", written, table.fullLine)
+		}
+
+		line.Mon = Monitor{Code: line.Code, PingApiKey: line.PingApiKey}
+		if len(table.expected) > 0 && !strings.Contains(line.Integration(), "--ping-api-key "+table.expected+" exec") {
+			t.Errorf("Integration() was %s, expected the ping API key %s", line.Integration(), table.expected)
+		}
+	}
+}
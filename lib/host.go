@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostMetadata describes the kind of host a monitor runs on. It has no host names, addresses or user information.
+type HostMetadata struct {
+	OS     string
+	Arch   string
+	Kernel string
+	Uptime time.Duration
+}
+
+var bootTimeRegex = regexp.MustCompile(`sec = (\d+)`)
+
+// ReadHostMetadata returns the OS and architecture of this build, with the kernel version and uptime when they can be
+// read. They are read from /proc on Linux, and from uname and sysctl on other Unix systems.
+func ReadHostMetadata() HostMetadata {
+	metadata := HostMetadata{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if runtime.GOOS == "windows" {
+		return metadata
+	}
+
+	if release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		metadata.Kernel = strings.TrimSpace(string(release))
+	} else if release, err := exec.Command("uname", "-r").Output(); err == nil {
+		metadata.Kernel = strings.TrimSpace(string(release))
+	}
+
+	if uptime, err := ioutil.ReadFile("/proc/uptime"); err == nil {
+		if fields := strings.Fields(string(uptime)); len(fields) > 0 {
+			if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				metadata.Uptime = time.Duration(seconds) * time.Second
+			}
+		}
+	} else if bootTime, err := exec.Command("sysctl", "-n", "kern.boottime").Output(); err == nil {
+		if matches := bootTimeRegex.FindStringSubmatch(string(bootTime)); matches != nil {
+			if seconds, err := strconv.ParseInt(matches[1], 10, 64); err == nil {
+				metadata.Uptime = time.Since(time.Unix(seconds, 0))
+			}
+		}
+	}
+
+	return metadata
+}
+
+// Tags returns the OS and architecture as monitor tags, e.g. os:linux and arch:amd64
+func (m HostMetadata) Tags() []string {
+	return []string{"os:" + m.OS, "arch:" + m.Arch}
+}
+
+// String describes the host for a monitor note, e.g. "linux/amd64, kernel 6.1.0-18-amd64, up 12 days". Uptime is
+// rounded to days, or hours on the first day, so the description changes rarely.
+func (m HostMetadata) String() string {
+	parts := []string{m.OS + "/" + m.Arch}
+	if len(m.Kernel) > 0 {
+		parts = append(parts, "kernel "+m.Kernel)
+	}
+
+	if days := int(m.Uptime.Hours() / 24); days > 1 {
+		parts = append(parts, fmt.Sprintf("up %d days", days))
+	} else if days == 1 {
+		parts = append(parts, "up 1 day")
+	} else if hours := int(m.Uptime.Hours()); hours > 1 {
+		parts = append(parts, fmt.Sprintf("up %d hours", hours))
+	} else if hours == 1 {
+		parts = append(parts, "up 1 hour")
+	} else if m.Uptime > 0 {
+		parts = append(parts, "up less than an hour")
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHostMetadataString(t *testing.T) {
+	tables := []struct {
+		metadata HostMetadata
+		expected string
+	}{
+		{HostMetadata{OS: "linux", Arch: "amd64", Kernel: "6.1.0", Uptime: 12*24*time.Hour + 3*time.Hour}, "linux/amd64, kernel 6.1.0, up 12 days"},
+		{HostMetadata{OS: "linux", Arch: "arm64", Kernel: "6.1.0", Uptime: 25 * time.Hour}, "linux/arm64, kernel 6.1.0, up 1 day"},
+		{HostMetadata{OS: "darwin", Arch: "arm64", Uptime: 5*time.Hour + 59*time.Minute}, "darwin/arm64, up 5 hours"},
+		{HostMetadata{OS: "darwin", Arch: "arm64", Uptime: 90 * time.Minute}, "darwin/arm64, up 1 hour"},
+		{HostMetadata{OS: "linux", Arch: "amd64", Uptime: 10 * time.Minute}, "linux/amd64, up less than an hour"},
+		{HostMetadata{OS: "windows", Arch: "amd64"}, "windows/amd64"},
+	}
+
+	for _, table := range tables {
+		if actual := table.metadata.String(); actual != table.expected {
+			t.Errorf("String() = %q, expected %q", actual, table.expected)
+		}
+	}
+}
+
+func TestReadHostMetadata(t *testing.T) {
+	metadata := ReadHostMetadata()
+	if metadata.OS != runtime.GOOS || metadata.Arch != runtime.GOARCH {
+		t.Errorf("Unexpected OS and arch %s/%s", metadata.OS, metadata.Arch)
+	}
+
+	if tags := metadata.Tags(); len(tags) != 2 || tags[0] != "os:"+runtime.GOOS {
+		t.Errorf("Unexpected tags %q", tags)
+	}
+}
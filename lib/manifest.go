@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ManifestSchema is the JSON schema of a monitor manifest, the JSON array of monitors written by
+// 'cronitor discover --manifest-out'. It is printed by 'cronitor schema'.
+const ManifestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Cronitor monitor manifest",
+  "description": "Monitors written by cronitor discover --manifest-out",
+  "type": "array",
+  "items": {
+    "type": "object",
+    "required": ["defaultName", "key", "rules", "tags", "type"],
+    "additionalProperties": false,
+    "properties": {
+      "name": {"type": "string", "minLength": 1},
+      "defaultName": {"type": "string", "minLength": 1},
+      "key": {"type": "string", "minLength": 1},
+      "rules": {
+        "type": "array",
+        "items": {
+          "type": "object",
+          "required": ["rule_type", "value"],
+          "additionalProperties": false,
+          "properties": {
+            "rule_type": {"type": "string", "minLength": 1},
+            "value": {"type": "string", "minLength": 1},
+            "time_unit": {"type": "string"},
+            "grace_seconds": {"type": "integer", "minimum": 0}
+          }
+        }
+      },
+      "tags": {"type": "array", "items": {"type": "string", "minLength": 1}},
+      "type": {"type": "string", "minLength": 1},
+      "code": {"type": "string", "minLength": 1},
+      "timezone": {"type": "string", "minLength": 1},
+      "defaultNote": {"type": "string"},
+      "notifications": {
+        "type": "object",
+        "additionalProperties": {"type": "array", "items": {"type": "string"}}
+      },
+      "assertions": {"type": "array", "items": {"type": "string", "minLength": 1}}
+    }
+  }
+}
+`
+
+// ValidateManifest checks a manifest against ManifestSchema. Every error is returned rather than only the first, each
+// with the line the monitor starts on and the path of the field, e.g. "line 14: [1].rules[0].rule_type is required".
+func ValidateManifest(contents []byte) []error {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(ManifestSchema), &schema); err != nil {
+		return []error{err}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return []error{errors.New(fmt.Sprintf("the manifest is not valid JSON: %s", err.Error()))}
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return []error{errors.New("line 1: the manifest must be a JSON array of monitors")}
+	}
+
+	items := schema["items"].(map[string]interface{})
+	var errs []error
+	for index := 0; decoder.More(); index++ {
+		line := lineAtOffset(contents, decoder.InputOffset())
+		var monitor interface{}
+		if err := decoder.Decode(&monitor); err != nil {
+			return append(errs, errors.New(fmt.Sprintf("line %d: [%d] is not valid JSON: %s", line, index, err.Error())))
+		}
+
+		for _, problem := range validateSchemaValue(items, monitor, fmt.Sprintf("[%d]", index)) {
+			errs = append(errs, errors.New(fmt.Sprintf("line %d: %s", line, problem)))
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("the manifest is not valid JSON: %s", err.Error())))
+	}
+
+	return errs
+}
+
+// lineAtOffset returns the line of the next value after offset. The decoder offset is where the previous value ended,
+// so the separator after it is skipped.
+func lineAtOffset(contents []byte, offset int64) int {
+	for offset < int64(len(contents)) && strings.IndexByte(" \t\r\n,", contents[offset]) >= 0 {
+		offset++
+	}
+
+	return bytes.Count(contents[:offset], []byte("\n")) + 1
+}
+
+// validateSchemaValue checks a decoded JSON value against the subset of JSON schema used by ManifestSchema: type,
+// minLength, minimum, items, required, properties and additionalProperties
+func validateSchemaValue(schema map[string]interface{}, value interface{}, path string) []string {
+	if expected, ok := schema["type"].(string); ok && !hasSchemaType(value, expected) {
+		return []string{fmt.Sprintf("%s must be of type %s, not %s", path, expected, schemaTypeOf(value))}
+	}
+
+	var problems []string
+	switch typed := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(utf8.RuneCountInString(typed)) < minLength {
+			problems = append(problems, fmt.Sprintf("%s must not be empty", path))
+		}
+	case json.Number:
+		if minimum, ok := schema["minimum"].(float64); ok {
+			if number, err := typed.Float64(); err == nil && number < minimum {
+				problems = append(problems, fmt.Sprintf("%s must be at least %v", path, minimum))
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range typed {
+				problems = append(problems, validateSchemaValue(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, exists := typed[name.(string)]; !exists {
+					problems = append(problems, fmt.Sprintf("%s.%s is required", path, name))
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		var names []string
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, validateSchemaValue(property, typed[name], path+"."+name)...)
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s.%s is not a known field", path, name))
+				}
+			case map[string]interface{}:
+				problems = append(problems, validateSchemaValue(additional, typed[name], path+"."+name)...)
+			}
+		}
+	}
+
+	return problems
+}
+
+func hasSchemaType(value interface{}, expected string) bool {
+	if expected == "integer" {
+		if number, ok := value.(json.Number); ok {
+			_, err := number.Int64()
+			return err == nil
+		}
+		return false
+	}
+
+	return schemaTypeOf(value) == expected
+}
+
+func schemaTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestManifestSchemaIsValidJSON(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(ManifestSchema), &schema); err != nil {
+		t.Fatalf("ManifestSchema is not valid JSON: %v", err)
+	}
+}
+
+func TestValidateManifestAcceptsMarshaledMonitors(t *testing.T) {
+	monitors := []*Monitor{
+		{
+			DefaultName:   "[web-1] /usr/bin/backup.sh",
+			Key:           "4f3e9a",
+			Rules:         []Rule{{RuleType: "not_on_schedule", Value: "0 2 * * *"}},
+			Tags:          []string{"cron-job"},
+			Type:          "heartbeat",
+			Code:          "d3x0c1",
+			Timezone:      "America/Chicago",
+			Note:          "Discovered on web-1",
+			Notifications: map[string][]string{"templates": {"default"}},
+			Assertions:    []string{"metric.duration < 5 min"},
+		},
+		{DefaultName: "[web-1] /usr/bin/report.sh", Key: "9b1c2d", Rules: []Rule{}, Tags: []string{}, Type: "heartbeat"},
+	}
+
+	contents, err := json.MarshalIndent(monitors, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := ValidateManifest(contents); len(errs) > 0 {
+		t.Errorf("Expected a valid manifest, got %v", errs)
+	}
+}
+
+func TestValidateManifestReportsEveryError(t *testing.T) {
+	manifest := `[
+  {"defaultName": "backup", "key": "4f3e9a", "rules": [], "tags": [], "type": "heartbeat"},
+  {
+    "defaultName": "",
+    "key": "9b1c2d",
+    "rules": [{"value": "0 2 * * *", "grace_seconds": -1}],
+    "tags": null,
+    "type": "heartbeat",
+    "owner": "ops"
+  }
+]`
+
+	expected := []string{
+		"line 3: [1].defaultName must not be empty",
+		"line 3: [1].owner is not a known field",
+		"line 3: [1].rules[0].rule_type is required",
+		"line 3: [1].rules[0].grace_seconds must be at least 0",
+		"line 3: [1].tags must be of type array, not null",
+	}
+
+	errs := ValidateManifest([]byte(manifest))
+	var actual []string
+	for _, err := range errs {
+		actual = append(actual, err.Error())
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ValidateManifest returned\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestValidateManifestRejectsNonArray(t *testing.T) {
+	for _, manifest := range []string{`{"key": "4f3e9a"}`, `not json`, `[{"key": "4f3e9a"}`} {
+		if errs := ValidateManifest([]byte(manifest)); len(errs) == 0 {
+			t.Errorf("Expected %s to be rejected", manifest)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NomadClient reads jobs from the Nomad HTTP API
+type NomadClient struct {
+	Addr      string
+	Token     string
+	UserAgent string
+}
+
+type NomadJobStub struct {
+	ID        string `json:"ID"`
+	ParentID  string `json:"ParentID"`
+	Name      string `json:"Name"`
+	Namespace string `json:"Namespace"`
+	Type      string `json:"Type"`
+	Periodic  bool   `json:"Periodic"`
+	Status    string `json:"Status"`
+}
+
+type NomadPeriodicConfig struct {
+	Enabled  bool     `json:"Enabled"`
+	Spec     string   `json:"Spec"`
+	Specs    []string `json:"Specs"`
+	SpecType string   `json:"SpecType"`
+	TimeZone string   `json:"TimeZone"`
+}
+
+type NomadJob struct {
+	ID        string               `json:"ID"`
+	Name      string               `json:"Name"`
+	Namespace string               `json:"Namespace"`
+	Region    string               `json:"Region"`
+	Type      string               `json:"Type"`
+	Periodic  *NomadPeriodicConfig `json:"Periodic"`
+}
+
+// ListJobs returns the jobs in every namespace the token can read
+func (c NomadClient) ListJobs() ([]NomadJobStub, error) {
+	var jobs []NomadJobStub
+	err := c.get("/v1/jobs", url.Values{"namespace": {"*"}}, &jobs)
+	return jobs, err
+}
+
+func (c NomadClient) GetJob(id string, namespace string) (*NomadJob, error) {
+	query := url.Values{}
+	if len(namespace) > 0 {
+		query.Set("namespace", namespace)
+	}
+
+	job := &NomadJob{}
+	if err := c.get("/v1/job/"+url.PathEscape(id), query, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (c NomadClient) get(path string, query url.Values, v interface{}) error {
+	requestUrl := strings.TrimRight(c.Addr, "/") + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest("GET", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("User-Agent", c.UserAgent)
+	if len(c.Token) > 0 {
+		request.Header.Add("X-Nomad-Token", c.Token)
+	}
+
+	client := &http.Client{Transport: Transport, Timeout: 30 * time.Second}
+	CountRequest(1)
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Request to Nomad at %s failed: %s", c.Addr, err.Error()))
+	}
+	defer response.Body.Close()
+
+	contents, err := readResponseBody(response)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != 200 {
+		return errors.New(fmt.Sprintf("Unexpected %d response from Nomad at %s: %s", response.StatusCode, requestUrl, strings.TrimSpace(string(contents))))
+	}
+
+	if err := json.Unmarshal(contents, v); err != nil {
+		return errors.New(fmt.Sprintf("Unexpected response from Nomad at %s: %s", requestUrl, err.Error()))
+	}
+
+	return nil
+}
+
+// CronSpec returns the cron expression of a periodic job and whether it can be monitored. Jobs with several specs
+// use the first one.
+func (j NomadJob) CronSpec() (string, bool) {
+	if j.Periodic == nil || !j.Periodic.Enabled {
+		return "", false
+	}
+
+	if len(j.Periodic.SpecType) > 0 && j.Periodic.SpecType != "cron" {
+		return "", false
+	}
+
+	if len(j.Periodic.Spec) > 0 {
+		return j.Periodic.Spec, true
+	}
+
+	if len(j.Periodic.Specs) > 0 {
+		return j.Periodic.Specs[0], true
+	}
+
+	return "", false
+}
+
+// Timezone returns the zone Nomad evaluates the cron spec in. Nomad uses UTC, not the local zone of the servers,
+// unless the job sets time_zone.
+func (j NomadJob) Timezone() string {
+	if j.Periodic != nil && len(j.Periodic.TimeZone) > 0 {
+		return j.Periodic.TimeZone
+	}
+
+	return "UTC"
+}
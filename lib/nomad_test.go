@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNomadClientReadsPeriodicJobs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Nomad-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/jobs":
+			w.Write([]byte(`[{"ID": "report", "Namespace": "batch", "Type": "batch", "Periodic": true},
+				{"ID": "web", "Namespace": "default", "Type": "service", "Periodic": false}]`))
+		case "/v1/job/report":
+			if r.URL.Query().Get("namespace") != "batch" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"ID": "report", "Name": "report", "Namespace": "batch", "Region": "global",
+				"Periodic": {"Enabled": true, "Spec": "0 5 * * *", "SpecType": "cron", "TimeZone": "America/New_York"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NomadClient{Addr: server.URL + "/", Token: "secret"}
+	stubs, err := client.ListJobs()
+	if err != nil || len(stubs) != 2 || !stubs[0].Periodic || stubs[1].Periodic {
+		t.Fatalf("Unexpected jobs: %v, %v", stubs, err)
+	}
+
+	job, err := client.GetJob(stubs[0].ID, stubs[0].Namespace)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if spec, ok := job.CronSpec(); !ok || spec != "0 5 * * *" || job.Timezone() != "America/New_York" {
+		t.Errorf("Unexpected periodic configuration: %q %v %q", spec, ok, job.Timezone())
+	}
+
+	if _, err := (NomadClient{Addr: server.URL}).ListJobs(); err == nil {
+		t.Errorf("Expected an error without a token")
+	}
+}
+
+func TestNomadJobCronSpec(t *testing.T) {
+	tables := []struct {
+		periodic *NomadPeriodicConfig
+		spec     string
+		ok       bool
+		timezone string
+	}{
+		{nil, "", false, "UTC"},
+		{&NomadPeriodicConfig{Enabled: false, Spec: "* * * * *"}, "", false, "UTC"},
+		{&NomadPeriodicConfig{Enabled: true, Spec: "*/5 * * * *", SpecType: "cron"}, "*/5 * * * *", true, "UTC"},
+		{&NomadPeriodicConfig{Enabled: true, Specs: []string{"0 1 * * *", "0 13 * * *"}, TimeZone: "Europe/Berlin"}, "0 1 * * *", true, "Europe/Berlin"},
+		{&NomadPeriodicConfig{Enabled: true, Spec: "@daily", SpecType: "test"}, "", false, "UTC"},
+	}
+
+	for _, table := range tables {
+		job := NomadJob{ID: "job", Periodic: table.periodic}
+		spec, ok := job.CronSpec()
+		if spec != table.spec || ok != table.ok || job.Timezone() != table.timezone {
+			t.Errorf("Unexpected result for %+v: %q %v %q", table.periodic, spec, ok, job.Timezone())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed 5 field cron expression
+type CronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	anyDay   bool
+	anyWeek  bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// ParseCronSchedule parses a cron expression with 5 fields, or a macro like @daily. Names can be used for months and
+// days of the week, and 7 is Sunday. @reboot has no schedule and is rejected.
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression '%s', expecting 5 fields", expression)
+	}
+
+	schedule := &CronSchedule{anyDay: fields[2] == "*", anyWeek: fields[4] == "*"}
+	var weekdays [8]bool
+	for i, spec := range []struct {
+		min, max int
+		names    map[string]int
+		values   []bool
+	}{
+		{0, 59, nil, schedule.minutes[:]},
+		{0, 23, nil, schedule.hours[:]},
+		{1, 31, nil, schedule.days[:]},
+		{1, 12, monthNames, schedule.months[:]},
+		{0, 7, weekdayNames, weekdays[:]},
+	} {
+		if err := parseCronField(fields[i], spec.min, spec.max, spec.names, spec.values); err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %s", expression, err.Error())
+		}
+	}
+
+	copy(schedule.weekdays[:], weekdays[:7])
+	schedule.weekdays[0] = schedule.weekdays[0] || weekdays[7]
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min int, max int, names map[string]int, values []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[index+1:]); err != nil || step < 1 {
+				return fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:index]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], names); err != nil {
+				return err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], names); err != nil {
+					return err
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("invalid value '" + value + "'")
+	}
+
+	return number, nil
+}
+
+// Previous returns the latest time the schedule fires at or before t, in the location of t. Only the last 366 days
+// are searched, so the result is false for a schedule like February 30th that never fires.
+func (s *CronSchedule) Previous(t time.Time) (time.Time, bool) {
+	candidate := t.Truncate(time.Minute)
+	for limit := candidate.AddDate(-1, 0, -1); candidate.After(limit); candidate = candidate.Add(-time.Minute) {
+		if s.matches(candidate) {
+			return candidate, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (s *CronSchedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[t.Month()] {
+		return false
+	}
+
+	// Like cron, when both the day of the month and the day of the week are restricted either one can match
+	day, weekday := s.days[t.Day()], s.weekdays[t.Weekday()]
+	if !s.anyDay && !s.anyWeek {
+		return day || weekday
+	}
+
+	return day && weekday
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedulePrevious(t *testing.T) {
+	now := time.Date(2024, 5, 1, 2, 7, 30, 0, time.UTC) // A Wednesday
+	tables := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 1, 2, 7, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 4, 30, 2, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 4, 30, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 4, 28, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 3", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, table := range tables {
+		schedule, err := ParseCronSchedule(table.expression)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", table.expression, err)
+			continue
+		}
+
+		if actual, found := schedule.Previous(now); !found || !actual.Equal(table.expected) {
+			t.Errorf("Previous(%s) for %s was %s, expected %s", now, table.expression, actual, table.expected)
+		}
+	}
+}
+
+func TestParseCronScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "@reboot", "0 0 * * x"} {
+		if _, err := ParseCronSchedule(expression); err == nil {
+			t.Errorf("Expected an error for %q", expression)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// TransferStats counts the requests made by this process and the bytes sent and received for them. Bytes are
+// counted on the connections opened by Transport, so they include TLS and HTTP overhead.
+type TransferStats struct {
+	Requests      int64
+	Retries       int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+var transferStats TransferStats
+
+// CountRequest records a request. Attempts after the first are also counted as retries.
+func CountRequest(attempt int) {
+	atomic.AddInt64(&transferStats.Requests, 1)
+	if attempt > 1 {
+		atomic.AddInt64(&transferStats.Retries, 1)
+	}
+}
+
+func GetTransferStats() TransferStats {
+	return TransferStats{
+		Requests:      atomic.LoadInt64(&transferStats.Requests),
+		Retries:       atomic.LoadInt64(&transferStats.Retries),
+		BytesSent:     atomic.LoadInt64(&transferStats.BytesSent),
+		BytesReceived: atomic.LoadInt64(&transferStats.BytesReceived),
+	}
+}
+
+type countingConn struct {
+	net.Conn
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&transferStats.BytesReceived, int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&transferStats.BytesSent, int64(n))
+	return n, err
+}
+
+func init() {
+	dial := Transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	Transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		return countingConn{conn}, nil
+	}
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransferStatsCountRequestsAndBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"monitors": []}`))
+	}))
+	defer server.Close()
+
+	before := GetTransferStats()
+	api := CronitorApi{UserAgent: "CronitorCLI/test", Logger: func(string) {}}
+	if _, _, err := api.send("PUT", server.URL, `{"key": "d3x0c1"}`, time.Second); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	after := GetTransferStats()
+
+	if after.Requests-before.Requests != 1 || after.Retries != before.Retries {
+		t.Errorf("Expected one request and no retries, got %+v", after)
+	}
+
+	if after.BytesSent-before.BytesSent < int64(len(`{"key": "d3x0c1"}`)) || after.BytesReceived-before.BytesReceived < int64(len(`{"monitors": []}`)) {
+		t.Errorf("Bytes were not counted: before %+v, after %+v", before, after)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/cronitorio/cronitor-cli/cmd"
 	"os"
+	"strings"
 )
 
 func init() {
@@ -14,20 +15,45 @@ func main() {
 	// Inject a `--` param
 	commandIndex := 0
 	argsEscaped := false
-	for idx, arg := range os.Args {
-		if arg == "exec" && commandIndex == 0 {
+	foundExec := false
+	codeFromScript := false
+	for idx := 1; idx < len(os.Args); idx++ {
+		arg := os.Args[idx]
+		if arg == "exec" && !foundExec {
 			// The first "exec" we come across is the one we care about.
-			// After we find it we continue looking at the rest of the args but we have our commandIndex set
-			commandIndex = idx + 2
+			// After we find it we continue looking at the rest of the args to find the monitor code
+			foundExec = true
+			continue
 		}
 
-		if arg == "help" && commandIndex == 0 {
+		if arg == "help" && !foundExec {
 			break
 		}
 
-		if commandIndex > 0 && arg == "--" {
+		if !foundExec {
+			continue
+		}
+
+		if arg == "--" {
 			argsEscaped = true
 		}
+
+		if arg == "--code-from-script" || arg == "--code-from-script=true" {
+			codeFromScript = true
+		}
+
+		if commandIndex == 0 {
+			if cmd.IsExecFlagWithValue(arg) {
+				// Skip over the flag value so it isn't mistaken for the monitor code
+				idx++
+			} else if !strings.HasPrefix(arg, "-") && codeFromScript {
+				// There is no monitor code with --code-from-script, this is the command to run
+				commandIndex = idx
+			} else if !strings.HasPrefix(arg, "-") {
+				// This is the monitor code, the command to run starts after it
+				commandIndex = idx + 1
+			}
+		}
 	}
 
 	if commandIndex > 0 && !argsEscaped && len(os.Args) > commandIndex+1 {
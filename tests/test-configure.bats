@@ -83,3 +83,67 @@ teardown() {
   ../cronitor $CRONITOR_ARGS configure --exclude-from-name "${MSG}A" --exclude-from-name "${MSG}B"  2>/dev/null
   grep -q "CRONITOR_EXCLUDE_TEXT" $CLI_CONFIGFILE && grep -q "${MSG}A" $CLI_CONFIGFILE && grep -q "${MSG}B" $CLI_CONFIGFILE
 }
+
+@test "Configure uses ping api key from systemd credentials" {
+  mkdir -p $BATS_TMPDIR/credentials
+  echo "credkey123" > $BATS_TMPDIR/credentials/cronitor-ping-api-key
+  CREDENTIALS_DIRECTORY=$BATS_TMPDIR/credentials ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --log $CLI_LOGFILE
+  grep -q "ping/credkey123" $CLI_LOGFILE
+}
+
+@test "Configure does not write systemd credentials to the config file" {
+  mkdir -p $BATS_TMPDIR/credentials
+  echo "credkey123" > $BATS_TMPDIR/credentials/cronitor-api-key
+  CREDENTIALS_DIRECTORY=$BATS_TMPDIR/credentials ../cronitor $CRONITOR_ARGS configure --config $CLI_CONFIGFILE > /dev/null
+  ! grep -q "credkey123" $CLI_CONFIGFILE
+}
+
+@test "Configure reads a config file with a custom extension using config-format" {
+  echo '{"CRONITOR_HOSTNAME": "configFormatHost"}' > $BATS_TMPDIR/cronitor.conf
+  ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.conf --config-format json ping d3x0c1 --run --log $CLI_LOGFILE
+  grep -q "host=configFormatHost" $CLI_LOGFILE
+}
+
+@test "Configure exits when the config file does not parse as the config-format" {
+  echo '{not json' > $BATS_TMPDIR/cronitor.conf
+  run -64 ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.conf --config-format json ping d3x0c1 --run
+  [[ "$output" == *"could not be parsed as json"* ]]
+}
+
+@test "Configure expands environment variables in config file values" {
+  echo '{"CRONITOR_HOSTNAME": "${NODE_NAME}-worker"}' > $BATS_TMPDIR/cronitor.json
+  NODE_NAME=envExpandHost ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.json ping d3x0c1 --run --log $CLI_LOGFILE
+  grep -q "host=envExpandHost-worker" $CLI_LOGFILE
+}
+
+@test "Configure exits on an undefined variable in the config file with strict-env-expand" {
+  echo '{"CRONITOR_HOSTNAME": "${UNDEFINED_NODE_NAME}"}' > $BATS_TMPDIR/cronitor.json
+  run -64 ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.json --strict-env-expand ping d3x0c1 --run
+  [[ "$output" == *"UNDEFINED_NODE_NAME"* ]]
+}
+
+@test "Configure writes environment variable references back to the config file" {
+  echo '{"CRONITOR_HOSTNAME": "${NODE_NAME}"}' > $BATS_TMPDIR/cronitor.json
+  NODE_NAME=envExpandHost ../cronitor $CRONITOR_ARGS configure --config $BATS_TMPDIR/cronitor.json > /dev/null
+  grep -q '${NODE_NAME}' $BATS_TMPDIR/cronitor.json
+}
+
+@test "Configure reads network options from the config file" {
+  echo '{"CRONITOR_REQUIRE_TLS13": true}' > $BATS_TMPDIR/cronitor.json
+  run -69 ../cronitor --config $BATS_TMPDIR/cronitor.json --ping-host http://127.0.0.1:9 ping d3x0c1 --run
+  [[ "$output" == *"TLS 1.3 is required"* ]]
+}
+
+@test "Configure finds a cronitor.toml config file" {
+  mkdir -p $BATS_TMPDIR/xdg/cronitor
+  rm -f $BATS_TMPDIR/xdg/cronitor/cronitor.*
+  printf 'CRONITOR_HOSTNAME = "tomlHost"\n' > $BATS_TMPDIR/xdg/cronitor/cronitor.toml
+  XDG_CONFIG_HOME=$BATS_TMPDIR/xdg ../cronitor --ping-host http://127.0.0.1:9 --ping-retries 0 ping d3x0c1 --run --log $CLI_LOGFILE || true
+  grep -q "host=tomlHost" $CLI_LOGFILE
+}
+
+@test "Configure will not write JSON into a TOML config file" {
+  printf 'CRONITOR_HOSTNAME = "tomlHost"\n' > $BATS_TMPDIR/cronitor.toml
+  run -64 ../cronitor configure --config $BATS_TMPDIR/cronitor.toml
+  grep -q 'tomlHost' $BATS_TMPDIR/cronitor.toml
+}
@@ -20,70 +20,126 @@ teardown() {
 #################
 
 @test "Discover reads file and sends PUT" {
-  ../cronitor $CRONITOR_ARGS discover --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" --log $CLI_LOGFILE > /dev/null
+  ../cronitor $CRONITOR_ARGS discover --import --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" --log $CLI_LOGFILE > /dev/null
   grep -q "Request" $CLI_LOGFILE
 }
 
 @test "Discover parses response and rewrites crontab" {
-  ../cronitor $CRONITOR_ARGS discover --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" | grep "slave_status.sh" | grep -q "cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" | grep "slave_status.sh" | grep -q "cronitor exec"
 }
 
 
 @test "Discover is silent when being run under exec" {
- [[ $(../cronitor $CRONITOR_ARGS exec d3x0c1 ../cronitor $CRONITOR_ARGS discover --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" | wc -c) -eq 0 ]]
+ [[ $(../cronitor $CRONITOR_ARGS exec d3x0c1 ../cronitor $CRONITOR_ARGS discover --import --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" | wc -c) -eq 0 ]]
 }
 
 
 @test "Discover correctly parses crontab with username" {
   echo "* * * * * $CLI_USERNAME echo 'username parse'" | cat - $FIXTURES_DIR/crontab.txt > $CLI_CRONTAB_TEMP
-  ../cronitor $CRONITOR_ARGS discover --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "$CLI_USERNAME cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "$CLI_USERNAME cronitor exec"
 }
 
 @test "Discover correctly parses crontab with 6 digits" {
   echo "* * * * * 0 echo 'six dig parse'" | cat - $FIXTURES_DIR/crontab.txt > $CLI_CRONTAB_TEMP
-  ../cronitor $CRONITOR_ARGS discover --auto $CLI_CRONTAB_TEMP -k "$API_KEY"| grep "echo '" | grep -q "0 cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $CLI_CRONTAB_TEMP -k "$API_KEY"| grep "echo '" | grep -q "0 cronitor exec"
 }
 
 @test "Discover correctly parses crontab with 6th digit DoW string range" {
   echo "* * * * * Mon-Fri echo 'DoW string parse'" | cat - $FIXTURES_DIR/crontab.txt > $CLI_CRONTAB_TEMP
-  ../cronitor $CRONITOR_ARGS discover --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon-Fri cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon-Fri cronitor exec"
 }
 
 @test "Discover correctly parses crontab with 6th digit DoW string list" {
   echo "* * * * * Mon,Wed,Fri echo 'DoW string list parse'" | cat - $FIXTURES_DIR/crontab.txt > $CLI_CRONTAB_TEMP
-  ../cronitor $CRONITOR_ARGS discover --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon,Wed,Fri cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon,Wed,Fri cronitor exec"
 }
 
 @test "Discover correctly parses crontab with 6th digit DoW string name" {
   echo "* * * * * Mon echo 'DoW string name parse'" | cat - $FIXTURES_DIR/crontab.txt > $CLI_CRONTAB_TEMP
-  ../cronitor $CRONITOR_ARGS discover --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon cronitor exec"
+  ../cronitor $CRONITOR_ARGS discover --import --auto $CLI_CRONTAB_TEMP -k "$API_KEY" | grep "echo '" | grep -q "Mon cronitor exec"
 }
 
 @test "Discover rewrites crontab in place" {
   cp $FIXTURES_DIR/crontab.txt $TMPFILE
-  ../cronitor $CRONITOR_ARGS discover --auto $TMPFILE -k "$API_KEY" > /dev/null
+  ../cronitor $CRONITOR_ARGS discover --import --auto $TMPFILE -k "$API_KEY" > /dev/null
   grep "slave_status.sh" $TMPFILE | grep -q "cronitor exec"
 }
 
 @test "Discover ignores meta crontab entries" {
   cp $FIXTURES_DIR/metacrontab.txt $TMPFILE
-  ../cronitor $CRONITOR_ARGS discover --auto $TMPFILE -k "$API_KEY" > /dev/null
+  ../cronitor $CRONITOR_ARGS discover --import --auto $TMPFILE -k "$API_KEY" > /dev/null
   run -1 bash -c 'grep "cron.hourly" $TMPFILE | grep -q "cronitor exec"'
 }
 
 @test "Discover adds no-stdout flag when supplied" {
-  run -1 bash -c '../cronitor $CRONITOR_ARGS discover --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" --no-stdout | grep "cronitor exec" | grep -q "no-stdout"'
+  run -1 bash -c '../cronitor $CRONITOR_ARGS discover --import --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" --no-stdout | grep "cronitor exec" | grep -q "no-stdout"'
 }
 
 @test "Discover omits 'notifications' if notification-list not specificed" {
-  run -1 bash -c '../cronitor $CRONITOR_ARGS discover --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" | grep -q "notifications"'
+  run -1 bash -c '../cronitor $CRONITOR_ARGS discover --import --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" | grep -q "notifications"'
 }
 
 @test "Discover includes custom notification-list" {
-  ../cronitor $CRONITOR_ARGS discover --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" --notification-list test-list-name | grep -q "test-list-name"
+  ../cronitor $CRONITOR_ARGS discover --import --auto -v $FIXTURES_DIR/crontab.txt -k "$API_KEY" --notification-list test-list-name | grep -q "test-list-name"
 }
 
 @test "Discover reads all of the crontabs in a directory" {
-  OUTPUT="$(../cronitor $CRONITOR_ARGS discover --auto $FIXTURES_DIR/cron.d -k "$API_KEY")"
+  OUTPUT="$(../cronitor $CRONITOR_ARGS discover --import --auto $FIXTURES_DIR/cron.d -k "$API_KEY")"
   echo "$OUTPUT" | grep -q "every_minute" && echo "$OUTPUT" | grep -q "top_of_hour"
-}
\ No newline at end of file
+}
+@test "Discover adds ping-api-key to jobs matching the ping api key map" {
+  ../cronitor $CRONITOR_ARGS discover --import --auto $FIXTURES_DIR/crontab.txt -k "$API_KEY" --ping-api-key-map $FIXTURES_DIR/ping-api-key-map.txt | grep "slave_status.sh" | grep -q "cronitor --ping-api-key 0123456789abcdef exec"
+}
+
+@test "Discover without import prints jobs and does not modify the crontab" {
+  cp $FIXTURES_DIR/crontab.txt $TMPFILE
+  ../cronitor $CRONITOR_ARGS discover $TMPFILE --log $CLI_LOGFILE | grep -q "slave_status.sh"
+  diff -q $FIXTURES_DIR/crontab.txt $TMPFILE
+  run -1 grep -q "Request" $CLI_LOGFILE
+}
+
+@test "Discover writes a manifest without modifying the crontab" {
+  cp $FIXTURES_DIR/crontab.txt $TMPFILE
+  ../cronitor $CRONITOR_ARGS discover $TMPFILE --manifest-out $BATS_TMPDIR/manifest.json > /dev/null
+  diff -q $FIXTURES_DIR/crontab.txt $TMPFILE
+  grep -q "slave_status.sh" $BATS_TMPDIR/manifest.json
+}
+
+@test "Discover rejects import combined with manifest-out" {
+  run -64 ../cronitor $CRONITOR_ARGS discover --import --manifest-out $BATS_TMPDIR/manifest.json $FIXTURES_DIR/crontab.txt -k "$API_KEY"
+  echo "$output" | grep -q "cannot be used together"
+}
+
+@test "Discover adds assertions to monitors" {
+  ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --manifest-out $BATS_TMPDIR/manifest.json --assert "metric.duration < 5 min" > /dev/null
+  grep -q "metric.duration < 5 min" $BATS_TMPDIR/manifest.json
+}
+
+@test "Discover gives jobs the same deterministic code on every run" {
+  ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --manifest-out $BATS_TMPDIR/manifest-1.json --deterministic-codes > /dev/null
+  ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --manifest-out $BATS_TMPDIR/manifest-2.json --deterministic-codes > /dev/null
+  grep -q '"code": "[0-9a-f]\{12\}"' $BATS_TMPDIR/manifest-1.json
+  diff -q $BATS_TMPDIR/manifest-1.json $BATS_TMPDIR/manifest-2.json
+}
+
+@test "Discover adds host metadata to monitors" {
+  ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --manifest-out $BATS_TMPDIR/manifest.json --report-host-metadata > /dev/null
+  grep -q '"os:' $BATS_TMPDIR/manifest.json
+  grep -q 'Host: ' $BATS_TMPDIR/manifest.json
+}
+
+@test "Discover rejects an invalid assertion" {
+  run -64 ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --assert "metric.duration < 5 days"
+  echo "$output" | grep -q "invalid assertion"
+}
+
+@test "Discover matches trivially different commands with normalize-command" {
+  printf '0 * * * * cd /var/app && ./backup.sh  --verbose -q\n0 * * * * ./backup.sh -q --verbose\n' > $TMPFILE
+  ../cronitor $CRONITOR_ARGS discover $TMPFILE --manifest-out $BATS_TMPDIR/manifest.json --normalize-command --normalize-sort-flags > /dev/null
+  [ "$(grep -o '"key": *"[^"]*"' $BATS_TMPDIR/manifest.json | uniq | wc -l)" -eq 1 ]
+}
+
+@test "Discover writes a manifest that matches the schema" {
+  ../cronitor $CRONITOR_ARGS discover $FIXTURES_DIR/crontab.txt --manifest-out $BATS_TMPDIR/manifest.json > /dev/null
+  ../cronitor $CRONITOR_ARGS schema --validate $BATS_TMPDIR/manifest.json
+}
@@ -0,0 +1,22 @@
+#!/usr/bin/env bats
+
+setup() {
+  SCRIPT_DIR="$(dirname $BATS_TEST_FILENAME)"
+  cd $SCRIPT_DIR
+
+  source $SCRIPT_DIR/setup.sh
+}
+
+#################
+# DOCTOR TESTS
+#################
+
+@test "Doctor prints a table of checks" {
+  run ../cronitor $CRONITOR_ARGS doctor -k "$CRONITOR_API_KEY"
+  echo "$output" | grep -q "Timezone" && echo "$output" | grep -q "Ping host DNS"
+}
+
+@test "Doctor fails when no key is set" {
+  run -1 ../cronitor doctor --config $BATS_TMPDIR/missing-config.json
+  echo "$output" | grep -q "No API key or ping API key is set"
+}
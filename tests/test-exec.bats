@@ -95,3 +95,329 @@ setup() {
 @test "Exec passes exitcode through to caller" {
   run -123 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec d3x0c1 $PROJECT_DIR/bin/fail.sh > /dev/null
 }
+
+@test "Exec passes exitcode through when ping cannot be delivered" {
+  run -0 ../cronitor --ping-host http://127.0.0.1:9 --log $CLI_LOGFILE exec d3x0c1 true
+}
+
+@test "Exec exits nonzero when ping cannot be delivered with fail-on-ping-error" {
+  run -69 ../cronitor --ping-host http://127.0.0.1:9 --log $CLI_LOGFILE exec --fail-on-ping-error d3x0c1 true
+}
+
+@test "Exec runs on-success hook with exit code and monitor code" {
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --on-success 'echo "hook $CRONITOR_EXIT_CODE $CRONITOR_MONITOR_CODE" > $BATS_TMPDIR/hook.txt' d3x0c1 true > /dev/null
+  grep -q "hook 0 d3x0c1" $BATS_TMPDIR/hook.txt
+}
+
+@test "Exec runs on-failure hook" {
+  run -123 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --on-failure 'echo "hook $CRONITOR_EXIT_CODE" > $BATS_TMPDIR/hook.txt' d3x0c1 $PROJECT_DIR/bin/fail.sh
+  grep -q "hook 123" $BATS_TMPDIR/hook.txt
+}
+
+@test "Exec hook failure does not change exit code" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --on-success false d3x0c1 true
+}
+
+@test "Exec hook failure changes exit code with strict-hooks" {
+  run -1 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --strict-hooks --on-success false d3x0c1 true
+}
+
+@test "Exec runs a command from a file" {
+  echo 'echo "from a file"' > $BATS_TMPDIR/command.sh
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --command-file $BATS_TMPDIR/command.sh d3x0c1 | grep -q "from a file"
+  grep "state=run" $CLI_LOGFILE | grep -q "command.sh+%28sha256%3A"
+}
+
+@test "Exec sends a fail ping when the command file is missing" {
+  run -66 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --command-file $BATS_TMPDIR/missing.sh d3x0c1
+  grep "state=fail" $CLI_LOGFILE | grep -q "Cannot+read+command+file"
+}
+
+@test "Exec skips the command when there is no stdin with skip-if-no-stdin" {
+  printf '' | ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --skip-if-no-stdin d3x0c1 $PROJECT_DIR/bin/fail.sh
+  grep -q "Skipping command" $CLI_LOGFILE
+  run -1 grep -q "Sending ping" $CLI_LOGFILE
+}
+
+@test "Exec passes stdin through with skip-if-no-stdin" {
+  echo "piped input" | ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --skip-if-no-stdin d3x0c1 cat | grep -q "piped input"
+}
+
+@test "Exec with clean-env only passes allowlisted variables" {
+  output="$(CRONITOR_TEST_KEEP=1 CRONITOR_TEST_DROP=1 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --clean-env --env-passthrough CRONITOR_TEST_KEEP d3x0c1 env)"
+  echo "$output" | grep -q "CRONITOR_TEST_KEEP=1"
+  ! echo "$output" | grep -q "CRONITOR_TEST_DROP"
+}
+
+@test "Exec sends only stderr with capture stderr" {
+  run -1 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --capture stderr d3x0c1 'echo stdout-data; echo stderr-data >&2; exit 1'
+  grep "state=fail" $CLI_LOGFILE | grep -q "stderr-data"
+  ! grep "state=fail" $CLI_LOGFILE | grep -q "stdout-data"
+}
+
+@test "Exec writes the captured stream to capture-file" {
+  rm -rf $BATS_TMPDIR/capture
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --capture stderr --capture-file "$BATS_TMPDIR/capture/%code.log" d3x0c1 'echo stdout-data; echo stderr-data >&2'
+  grep -q "stderr-data" $BATS_TMPDIR/capture/d3x0c1.log
+  ! grep -q "stdout-data" $BATS_TMPDIR/capture/d3x0c1.log
+}
+
+@test "Exec skips the command when max-concurrent-exec slots are taken" {
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --max-concurrent-exec 1 d3x0c1 'sleep 3' &
+  sleep 1
+  run -75 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --max-concurrent-exec 1 d3x0c1 'echo should-not-run'
+  [[ "$output" != *"should-not-run"* ]]
+  grep "state=tick" $CLI_LOGFILE | grep -q "max-concurrent-exec"
+  ! grep "state=fail" $CLI_LOGFILE | grep -q "max-concurrent-exec"
+  wait
+}
+
+@test "Exec reports a late start with start-deadline" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --start-deadline 0s --schedule "0 0 * * *" d3x0c1 true
+  grep "state=run" $CLI_LOGFILE | grep -q "msg=Late+start"
+  grep "state=run" $CLI_LOGFILE | grep -q "metric=start_delay%3A[0-9]"
+}
+
+@test "Exec sends metrics from the last line with result-json-line" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --result-json-line --result-metric processed=count d3x0c1 'echo starting; echo "{\"processed\": 1200}"'
+  grep "state=complete" $CLI_LOGFILE | grep -q "metric=count%3A1200"
+}
+
+@test "Exec runs a multi-line script from stdin with -" {
+  output="$(printf 'echo line-one\necho line-two\n' | ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec d3x0c1 -)"
+  [[ "$output" == *"line-one"* ]]
+  [[ "$output" == *"line-two"* ]]
+  grep "state=run" $CLI_LOGFILE | grep -q "script+from+stdin"
+}
+
+@test "Exec fails with an empty script-stdin" {
+  run -66 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --script-stdin d3x0c1 < /dev/null
+}
+
+@test "Exec sends a complete ping with a warning for warn-exit-codes" {
+  run -3 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --warn-exit-codes 3 d3x0c1 'exit 3'
+  grep "state=complete" $CLI_LOGFILE | grep -q "metric=warning_count%3A1"
+  ! grep -q "state=fail" $CLI_LOGFILE
+}
+
+@test "Exec stops capturing output at max-output-total" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --max-output-total 20 d3x0c1 'for i in 1 2 3 4 5; do echo line-$i; done'
+  [[ "$output" == *"line-5"* ]]
+  grep "state=complete" $CLI_LOGFILE | grep -q "Output+capture+stopped"
+}
+
+@test "Exec sets variables from job-env-file in order" {
+  printf 'A=1\nB=one\n' > $BATS_TMPDIR/first.env
+  printf 'B=two\n' > $BATS_TMPDIR/second.env
+  [[ "$(A=0 ../cronitor $CRONITOR_ARGS exec --job-env-file $BATS_TMPDIR/first.env --job-env-file $BATS_TMPDIR/second.env d3x0c1 'echo $A $B')" == "1 two" ]]
+}
+
+@test "Exec does not run the command when a job-env-file is missing" {
+  run ../cronitor $CRONITOR_ARGS exec --job-env-file $BATS_TMPDIR/missing.env d3x0c1 "echo ran"
+  [ "$status" -eq 66 ]
+  [[ "$output" != *"ran"* ]]
+}
+
+@test "Exec does not run the command for a monitor that is not in allowed-codes" {
+  run ../cronitor $CRONITOR_ARGS --allowed-codes "backup-*" exec d3x0c1 "echo ran"
+  [ "$status" -eq 64 ]
+  [[ "$output" != *"ran"* ]]
+}
+
+@test "Exec sends progress pings with progress-interval" {
+  ../cronitor $CRONITOR_ARGS --ping-host http://127.0.0.1:9 --ping-retries 0 --log $CLI_LOGFILE exec --progress-interval 1s d3x0c1 "sleep 1.5" > /dev/null
+  grep "Sending ping http://127.0.0.1:9/d3x0c1/run" $CLI_LOGFILE | grep -q "metric=elapsed%3A1"
+}
+
+@test "Exec rejects progress-interval with ping-on-change" {
+  run -64 ../cronitor $CRONITOR_ARGS exec --progress-interval 1m --ping-on-change d3x0c1 "true"
+}
+
+@test "Exec passes piped stdin to the command" {
+  [[ "$(echo piped-data | ../cronitor $CRONITOR_ARGS exec d3x0c1 cat)" == "piped-data" ]]
+}
+
+@test "Exec connects the command to /dev/null with no-stdin" {
+  [[ "$(echo piped-data | ../cronitor $CRONITOR_ARGS exec --no-stdin d3x0c1 'cat; echo done')" == "done" ]]
+}
+
+@test "Exec rejects no-stdin with script-stdin" {
+  run -64 ../cronitor $CRONITOR_ARGS exec --no-stdin --script-stdin d3x0c1
+}
+
+@test "Exec uses fail-ping-retries for the fail ping only" {
+  run ../cronitor $CRONITOR_ARGS --ping-host http://127.0.0.1:9 --ping-retries 1 --fail-ping-retries 0 --log $CLI_LOGFILE exec d3x0c1 "exit 3"
+  grep -q "Sending ping http://127.0.0.1:9/d3x0c1/run?try=2" $CLI_LOGFILE
+  ! grep -q "Sending ping http://127.0.0.1:9/d3x0c1/fail?try=2" $CLI_LOGFILE
+}
+
+@test "Exec --detach returns before the command finishes" {
+  rm -f $BATS_TMPDIR/detached.txt
+  run ../cronitor $CRONITOR_ARGS exec --detach d3x0c1 "sleep 2 && touch $BATS_TMPDIR/detached.txt"
+  [ "$status" -eq 0 ]
+  [ ! -f $BATS_TMPDIR/detached.txt ]
+  sleep 4
+  [ -f $BATS_TMPDIR/detached.txt ]
+  rm -f $BATS_TMPDIR/detached.txt
+}
+
+@test "Exec --detach cannot be used with --script-stdin" {
+  run ../cronitor $CRONITOR_ARGS exec --detach --script-stdin d3x0c1 <<< "true"
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"--detach cannot be used with --script-stdin"* ]]
+}
+
+@test "Exec reads the monitor code from the script with --code-from-script" {
+  printf '#!/bin/sh\n# cronitor: d3x0c1\necho "ran with $1"\n' > $BATS_TMPDIR/job.sh
+  chmod +x $BATS_TMPDIR/job.sh
+  run ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --code-from-script $BATS_TMPDIR/job.sh --full
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"ran with --full"* ]]
+  grep -q "/d3x0c1/" $CLI_LOGFILE
+  rm -f $BATS_TMPDIR/job.sh
+}
+
+@test "Exec --code-from-script fails without a marker comment" {
+  printf '#!/bin/sh\necho hi\n' > $BATS_TMPDIR/job.sh
+  run ../cronitor $CRONITOR_ARGS exec --code-from-script $BATS_TMPDIR/job.sh
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"no \"# cronitor: <code>\" comment"* ]]
+  rm -f $BATS_TMPDIR/job.sh
+}
+
+@test "Exec reports the working directory and redacted arguments" {
+  cd /tmp && run -0 $PROJECT_DIR/cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --report-cwd --report-args d3x0c1 true --since yesterday DB_PASSWORD=hunter2
+  grep "state=complete" $CLI_LOGFILE | grep -q "cwd%3D%2Ftmp"
+  grep "state=complete" $CLI_LOGFILE | grep -q "args%3D--since+yesterday+DB_PASSWORD%3DREDACTED"
+}
+
+@test "Exec does not report arguments by default" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec d3x0c1 true --since yesterday
+  ! grep "Sending ping" $CLI_LOGFILE | grep -q "args%3D"
+}
+
+@test "Exec sends the output of the message filter" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --message-filter "grep ERROR" d3x0c1 'echo starting; echo ERROR: disk full'
+  grep "state=complete" $CLI_LOGFILE | grep -q "msg=ERROR%3A+disk+full"
+}
+
+@test "Exec sends the unfiltered output when the message filter fails" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --message-filter "exit 1" d3x0c1 'echo starting'
+  grep "state=complete" $CLI_LOGFILE | grep -q "msg=starting"
+  grep -q "Message filter failed" $CLI_LOGFILE
+}
+
+@test "Exec sends the static message before the captured output" {
+  run -1 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --message "Nightly backup" d3x0c1 'echo backup-failed; exit 1'
+  grep "state=fail" $CLI_LOGFILE | grep -q "exit+status+1%5D+Nightly+backup%0Abackup-failed"
+}
+
+@test "Exec sends a fail ping when there is no output with fail-if-empty-output" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-if-empty-output d3x0c1 true
+  grep "state=fail" $CLI_LOGFILE | grep -q "wrote+nothing"
+}
+
+@test "Exec remaps the exit code with fail-if-empty-output-exit" {
+  run -3 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-if-empty-output --fail-if-empty-output-exit 3 d3x0c1 true
+}
+
+@test "Exec fail-if-empty-output only checks the captured stream" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-if-empty-output --capture stderr d3x0c1 'echo stdout-data'
+  grep "state=fail" $CLI_LOGFILE | grep -q "wrote+nothing+to+stderr"
+}
+
+@test "Exec sends a fail ping when the command writes to stderr with fail-on-stderr" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-on-stderr d3x0c1 'echo oops >&2'
+  grep "state=fail" $CLI_LOGFILE | grep -q "fail-on-stderr%5D.*oops"
+}
+
+@test "Exec fail-on-stderr-pattern only fails on matching lines" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-on-stderr-pattern 'ERROR|FATAL' d3x0c1 'echo "warning: slow" >&2'
+  grep -q "state=complete" $CLI_LOGFILE
+  rm -f $CLI_LOGFILE
+
+  run -5 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --fail-on-stderr-pattern 'ERROR|FATAL' --fail-on-stderr-exit 5 d3x0c1 'echo "ERROR: no connection" >&2'
+  grep "state=fail" $CLI_LOGFILE | grep -q "ERROR%3A+no+connection"
+}
+
+@test "Exec sends the command checksum with the run ping" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --checksum-command d3x0c1 "$PROJECT_DIR/bin/test-bash.sh"
+  grep "state=run" $CLI_LOGFILE | grep -q "Command+checksum%3A+sha256%3A$(sha256sum $PROJECT_DIR/bin/test-bash.sh | cut -c1-64)"
+}
+
+@test "Exec checks the script run by an interpreter with expected-checksum" {
+  run -0 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --expected-checksum "$(sha256sum $PROJECT_DIR/bin/test-bash.sh | cut -c1-64)" d3x0c1 "bash $PROJECT_DIR/bin/test-bash.sh"
+}
+
+@test "Exec does not run a command with an unexpected checksum" {
+  run -1 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --expected-checksum 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 d3x0c1 "$PROJECT_DIR/bin/test-bash.sh"
+  [[ "$output" == *"Command checksum mismatch"* ]]
+  [[ "$output" != *"i am an array"* ]]
+}
+
+@test "Exec does not run the command without a key when ping-api-key-required is set" {
+  run -64 env -u CRONITOR_API_KEY -u CRONITOR_PING_API_KEY CRONITOR_PING_API_KEY_REQUIRED=true ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/missing-config.json exec d3x0c1 echo should-not-run
+  [[ "$output" != *"should-not-run"* ]]
+}
+
+@test "Exec rejects an invalid on-missing-monitor value" {
+  run -64 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --on-missing-monitor maybe d3x0c1 true
+}
+
+@test "Exec writes the PID file while the command runs and removes it afterwards" {
+  rm -f $BATS_TMPDIR/exec-test.pid
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --pidfile $BATS_TMPDIR/exec-test.pid d3x0c1 "sleep 0.5; cat $BATS_TMPDIR/exec-test.pid" | grep -qE "^[0-9]+$"
+  [ ! -f $BATS_TMPDIR/exec-test.pid ]
+}
+
+@test "Exec replaces a stale PID file" {
+  echo 999999 > $BATS_TMPDIR/exec-test.pid
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --pidfile $BATS_TMPDIR/exec-test.pid d3x0c1 true
+  grep -q "Replacing stale PID file" $CLI_LOGFILE
+  [ ! -f $BATS_TMPDIR/exec-test.pid ]
+}
+
+@test "Exec only pings when the outcome changes with ping-on-change" {
+  rm -rf $BATS_TMPDIR/state
+  ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.json --log $CLI_LOGFILE exec --ping-on-change d3x0c1 true
+  ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.json --log $CLI_LOGFILE exec --ping-on-change d3x0c1 true
+  run -1 ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/cronitor.json --log $CLI_LOGFILE exec --ping-on-change d3x0c1 false
+  [ "$(grep -c "state=complete&try=1" $CLI_LOGFILE)" -eq 1 ]
+  grep -q "Skipping complete ping" $CLI_LOGFILE
+  grep -q "state=fail" $CLI_LOGFILE
+  run -1 grep -q "state=run" $CLI_LOGFILE
+  rm -rf $BATS_TMPDIR/state
+}
+
+@test "Exec appends a record of each ping with dump-ping" {
+  rm -f $BATS_TMPDIR/pings.jsonl
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --dump-ping $BATS_TMPDIR/pings.jsonl d3x0c1 true
+  [ "$(wc -l < $BATS_TMPDIR/pings.jsonl)" -eq 2 ]
+  grep -q '"endpoint":"run"' $BATS_TMPDIR/pings.jsonl
+  grep -q '"endpoint":"complete"' $BATS_TMPDIR/pings.jsonl
+  rm -f $BATS_TMPDIR/pings.jsonl
+}
+
+@test "Exec sends output written before a hang when the command is killed" {
+  ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec d3x0c1 'echo before-hang; sleep 30' &
+  CRONITOR_PID=$!
+  sleep 1
+  kill -TERM $CRONITOR_PID
+  SECONDS=0
+  wait $CRONITOR_PID || true
+  [ $SECONDS -lt 10 ]
+  grep "state=fail" $CLI_LOGFILE | grep -q "before-hang"
+}
+
+@test "Exec runs the notify-local hook when the fail ping cannot be delivered" {
+  rm -f $BATS_TMPDIR/notify-local.txt
+  run -3 ../cronitor --ping-host http://127.0.0.1:9 --log $CLI_LOGFILE exec --notify-local "echo \$CRONITOR_MONITOR_CODE \$CRONITOR_EXIT_CODE \$CRONITOR_MESSAGE > $BATS_TMPDIR/notify-local.txt" d3x0c1 'echo boom; exit 3'
+  grep -q "d3x0c1 3 .*boom" $BATS_TMPDIR/notify-local.txt
+  rm -f $BATS_TMPDIR/notify-local.txt
+}
+
+@test "Exec does not run the notify-local hook when the fail ping is delivered" {
+  rm -f $BATS_TMPDIR/notify-local.txt
+  run -3 ../cronitor $CRONITOR_ARGS --log $CLI_LOGFILE exec --notify-local "touch $BATS_TMPDIR/notify-local.txt" d3x0c1 'exit 3'
+  [ ! -f $BATS_TMPDIR/notify-local.txt ]
+}
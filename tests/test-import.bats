@@ -0,0 +1,48 @@
+#!/usr/bin/env bats
+
+setup() {
+  SCRIPT_DIR="$(dirname $BATS_TEST_FILENAME)"
+  FIXTURES_DIR="$SCRIPT_DIR/../fixtures"
+  cd $SCRIPT_DIR
+
+  source $SCRIPT_DIR/setup.sh
+  CSVFILE="$BATS_TMPDIR/monitors.csv"
+}
+
+teardown() {
+  rm -f $CSVFILE
+  rm -f $CLI_LOGFILE
+}
+
+#################
+# IMPORT TESTS
+#################
+
+@test "Import requires --csv or --crontab" {
+  run ../cronitor $CRONITOR_ARGS import --dry-run
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"either --csv or --crontab is required"* ]]
+}
+
+@test "Import dry run lists monitors from a CSV file" {
+  printf 'name,schedule,code,tags\nNightly backup,0 2 * * *,nightly-backup,backup;db\nHourly report,@hourly,,reports\n' > $CSVFILE
+  run ../cronitor $CRONITOR_ARGS import --csv $CSVFILE --dry-run
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"Nightly backup  (nightly-backup)"* ]]
+  [[ "$output" == *"2 monitors would be created or updated"* ]]
+}
+
+@test "Import rejects a CSV row with an invalid schedule" {
+  printf 'name,schedule\nNightly backup,not a schedule\n' > $CSVFILE
+  run ../cronitor $CRONITOR_ARGS import --csv $CSVFILE --dry-run
+  [ "$status" -eq 66 ]
+  [[ "$output" == *"L2"* ]]
+}
+
+@test "Import dry run lists monitors from a crontab file without changing it" {
+  cp $FIXTURES_DIR/crontab.txt $CSVFILE
+  run ../cronitor $CRONITOR_ARGS import --crontab $CSVFILE --dry-run
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"monitors would be created or updated"* ]]
+  diff $FIXTURES_DIR/crontab.txt $CSVFILE
+}
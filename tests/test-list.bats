@@ -18,4 +18,16 @@ setup() {
 
 @test "List reads crontab and formats table correctly" {
   ../cronitor $CRONITOR_ARGS list ../fixtures/crontab.txt | grep -q "\-----"
-}
\ No newline at end of file
+}
+@test "List prints each cron job with a template" {
+  run ../cronitor $CRONITOR_ARGS list ../fixtures/crontab.txt --output=template --template '{{.Schedule}}|{{.Command}}'
+  [ "$status" -eq 0 ]
+  [[ "$output" == *"0,15,30,45 * * * *|/usr/bin/true"* ]]
+  [[ "$output" != *"-----"* ]]
+}
+
+@test "List rejects a template that cannot be parsed" {
+  run ../cronitor $CRONITOR_ARGS list ../fixtures/crontab.txt --output=template --template '{{.Schedule'
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"invalid --template"* ]]
+}
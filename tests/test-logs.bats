@@ -0,0 +1,22 @@
+#!/usr/bin/env bats
+
+setup() {
+  SCRIPT_DIR="$(dirname $BATS_TEST_FILENAME)"
+  cd $SCRIPT_DIR
+
+  source $SCRIPT_DIR/setup.sh
+}
+
+#################
+# LOGS TESTS
+#################
+
+@test "Logs requires a monitor key" {
+  run -64 ../cronitor $CRONITOR_ARGS logs -k "$CRONITOR_API_KEY"
+  echo "$output" | grep -q "a unique monitor key is required"
+}
+
+@test "Logs rejects an unknown output format" {
+  run -64 ../cronitor $CRONITOR_ARGS logs d3x0c1 --output yaml -k "$CRONITOR_API_KEY"
+  echo "$output" | grep -q "Expecting 'text' or 'json'"
+}
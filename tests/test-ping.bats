@@ -43,3 +43,118 @@ teardown() {
   ../cronitor $CRONITOR_ARGS ping 44oI2n --run --msg "$MSG" --log $CLI_LOGFILE -k $CRONITOR_API_KEY && sleep 3
   ../cronitor $CRONITOR_ARGS activity 44oI2n -k $CRONITOR_API_KEY | grep -q "$MSG"
 }
+
+@test "Ping exits nonzero when the ping cannot be delivered" {
+  run ../cronitor ping d3x0c1 --run --ping-host http://127.0.0.1:9 --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  [ "$status" -eq 69 ]
+}
+
+@test "Ping with trace includes a request id in log lines" {
+  ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --trace --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping" $CLI_LOGFILE | grep -qE '^\[[0-9a-f]{16}\] '
+}
+
+@test "Test command verifies run and complete pings are recorded" {
+  ../cronitor $CRONITOR_ARGS test 44oI2n --log $CLI_LOGFILE -k $CRONITOR_API_KEY | grep -q "Test passed"
+}
+
+@test "Ping with message prefix and suffix" {
+  ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --msg "body" --message-prefix "PREFIX-" --message-suffix "-SUFFIX" --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep -q "msg=PREFIX-body-SUFFIX" $CLI_LOGFILE
+}
+
+@test "Ping with extra ping params" {
+  ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --ping-param "new_field=a b" --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep -q "new_field=a+b" $CLI_LOGFILE
+}
+
+@test "Ping rejects reserved ping params" {
+  run ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --ping-param "auth_key=abc" --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  [ "$status" -eq 64 ]
+  echo "$output" | grep -q "cannot be overridden"
+}
+
+@test "Ping with fast fail offline does not retry when the host is unreachable" {
+  run ../cronitor ping d3x0c1 --run --fast-fail-offline --ping-host http://127.0.0.1:9 --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  [ "$status" -ne 0 ]
+  grep -q "Ping host is unreachable" $CLI_LOGFILE
+  ! grep -q "Sending ping" $CLI_LOGFILE
+}
+
+@test "Ping with duration and metrics" {
+  ../cronitor $CRONITOR_ARGS ping d3x0c1 --complete --duration 42.5 --metric count=1200 --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep -q "duration=42.500" $CLI_LOGFILE && grep -q "metric=count%3A1200" $CLI_LOGFILE
+}
+
+@test "Ping with report metadata appends the pid and redacted command line" {
+  ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --msg "body" --report-metadata --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping" $CLI_LOGFILE | grep -q "pid%3D[0-9]*+ppid%3D"
+  grep "Sending ping" $CLI_LOGFILE | grep -q "cmd%3D.*-k+REDACTED"
+}
+
+@test "Ping rejects a header that overrides Authorization" {
+  run -64 ../cronitor $CRONITOR_ARGS --header "Authorization: Basic abc" ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+}
+
+@test "Ping export-series prints an export line" {
+  ../cronitor $CRONITOR_ARGS ping --export-series | grep -qE "^export CRONITOR_SERIES=[0-9.]+$"
+}
+
+@test "Ping uses the series from CRONITOR_SERIES" {
+  CRONITOR_SERIES=stage-series ../cronitor $CRONITOR_ARGS ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep -q "series=stage-series" $CLI_LOGFILE
+}
+
+@test "Ping refuses to send an unauthenticated ping with ping-api-key-required" {
+  run -64 env -u CRONITOR_API_KEY -u CRONITOR_PING_API_KEY ../cronitor $CRONITOR_ARGS --config $BATS_TMPDIR/missing-config.json --ping-api-key-required ping d3x0c1 --run --log $CLI_LOGFILE
+  [[ "$output" == *"ping API key is required"* ]]
+}
+
+@test "Ping refuses plain HTTP with require-tls13" {
+  run -69 ../cronitor --require-tls13 --ping-host http://127.0.0.1:9 ping d3x0c1 --run --log $CLI_LOGFILE
+  [[ "$output" == *"TLS 1.3 is required"* ]]
+}
+
+@test "Ping signs pings to a custom ping host with ping-hmac-secret" {
+  run ../cronitor --ping-host http://127.0.0.1:9 --ping-hmac-secret s3cret ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping http://127.0.0.1:9" $CLI_LOGFILE | grep -q "&sig="
+}
+
+@test "Ping applies a matching rule from rules-file" {
+  printf 'rules:\n  - match: "d3x0*"\n    env: rules-env\n' > $BATS_TMPDIR/rules.yaml
+  ../cronitor $CRONITOR_ARGS --rules-file $BATS_TMPDIR/rules.yaml ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping" $CLI_LOGFILE | grep -q "env=rules-env"
+}
+
+@test "Ping renames endpoints with ping-endpoint-map" {
+  run ../cronitor --ping-host http://127.0.0.1:9 --ping-endpoint-map run=start --ping-retries 0 ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping http://127.0.0.1:9" $CLI_LOGFILE | grep -q "state=start"
+}
+
+@test "Ping rejects an invalid socks5 proxy address" {
+  run ../cronitor $CRONITOR_ARGS --socks5 proxy.internal ping d3x0c1 --run -k $CRONITOR_API_KEY
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"invalid SOCKS5 proxy address"* ]]
+}
+
+@test "Ping sends pings through the socks5 proxy" {
+  run ../cronitor $CRONITOR_ARGS --socks5 127.0.0.1:9 --ping-retries 0 ping d3x0c1 --run --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep -q "proxyconnect tcp: dial tcp 127.0.0.1:9" $CLI_LOGFILE
+}
+
+@test "Ping rounds the duration with duration-precision" {
+  run ../cronitor --ping-host http://127.0.0.1:9 --ping-retries 0 --duration-precision 0 ping d3x0c1 --complete --duration 42.7 --log $CLI_LOGFILE -k $CRONITOR_API_KEY
+  grep "Sending ping http://127.0.0.1:9" $CLI_LOGFILE | grep -q "duration=43$"
+}
+
+@test "Ping refuses a monitor that is not in allowed-codes" {
+  run ../cronitor $CRONITOR_ARGS --allowed-codes "backup-*" ping d3x0c1 --run -k $CRONITOR_API_KEY
+  [ "$status" -eq 64 ]
+  [[ "$output" == *"monitor d3x0c1 is not allowed"* ]]
+}
+
+@test "Ping export-series rejects a series that would need escaping" {
+  export CRONITOR_SERIES='a&b'
+  run -64 ../cronitor $CRONITOR_ARGS ping --export-series
+  unset CRONITOR_SERIES
+}
@@ -23,3 +23,9 @@ setup() {
 @test "Status integration test with bad monitor code" {
   ../cronitor $CRONITOR_ARGS status asdfgh --log $CLI_LOGFILE 2>&1 | grep -q "404"
 }
+
+@test "Status reads codes from file and reports a summary" {
+  printf "# monitors\n44oI2n\nasdfgh\n" > $BATS_TMPDIR/codes.txt
+  run -69 ../cronitor $CRONITOR_ARGS status --from-file $BATS_TMPDIR/codes.txt --log $CLI_LOGFILE
+  echo "$output" | grep -q "1 succeeded, 1 failed"
+}
@@ -0,0 +1,18 @@
+#!/usr/bin/env bats
+
+setup() {
+  SCRIPT_DIR="$(dirname $BATS_TEST_FILENAME)"
+  cd $SCRIPT_DIR
+}
+
+#################
+# VERSION TESTS
+#################
+
+@test "Version prints build information" {
+  ../cronitor version | grep -q "OS/Arch:"
+}
+
+@test "Version prints JSON" {
+  ../cronitor version --json | grep -q '"go_version"'
+}